@@ -0,0 +1,265 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "sync"
+    "time"
+    "net/http"
+    "math/rand"
+    "sync/atomic"
+
+    dto "github.com/prometheus/client_model/go"
+    "github.com/prometheus/common/expfmt"
+
+    "github.com/PSauerborn/hermes/pkg/hermes"
+    hermes_client "github.com/PSauerborn/hermes/pkg/client"
+)
+
+// struct used to define a metric that traffic is generated for
+type benchMetric struct {
+    metricType string
+    name       string
+    labels     []string
+}
+
+// struct used to define the number of samples of all benchmarked
+// metrics and the self metrics of a hermes server at a given time
+type benchScrape struct {
+    samples  float64
+    dropped  float64
+    rejected float64
+}
+
+// function used to generate traffic for all metrics of a hermes
+// config and send it to a running hermes server. the achieved rate
+// is reported once done, together with the number of lost packets
+// if the prometheus interface of the server can be scraped. note
+// that gauges are set to random values and sets only count unique
+// values, so neither is included when determining lost packets
+func RunBench(args []string) int {
+    flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+    configPath := flags.String("config", cfg.Get("hermes_config_path"), "path of the hermes config defining the metrics")
+    target := flags.String("target", fmt.Sprintf("udp://localhost:%s", cfg.Get("listen_port")),
+        "URL of the target hermes server (udp, tcp, unix or unixgram)")
+    metricsURL := flags.String("metrics-url", fmt.Sprintf("http://localhost:%s/metrics", cfg.Get("prometheus_port")),
+        "URL scraped to determine the number of lost packets (empty to disable)")
+    rate := flags.Float64("rate", 1000, "total number of packets sent per second (0 for unlimited)")
+    concurrency := flags.Int("concurrency", 4, "number of concurrent senders")
+    duration := flags.Duration("duration", 10 * time.Second, "duration of the benchmark")
+    count := flags.Int("count", 0, "total number of packets to send (overrides duration)")
+    cardinality := flags.Int("cardinality", 10, "number of distinct values of each label")
+    encoding := flags.String("encoding", "json", "encoding of packets (json or binary)")
+    keyID := flags.String("key-id", "", "optional key ID used to sign packets")
+    secret := flags.String("secret", "", "optional secret used to sign packets")
+    settle := flags.Duration("settle", 2 * time.Second, "time to wait for the server to process packets before scraping")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+    if *concurrency < 1 || *cardinality < 1 || *rate < 0 {
+        flags.Usage()
+        return 2
+    }
+
+    config, err := hermes.LoadHermesConfig(*configPath)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "unable to load hermes config: %v\n", err)
+        return 1
+    }
+    metrics := benchMetrics(config)
+    if len(metrics) == 0 {
+        fmt.Fprintln(os.Stderr, "hermes config does not define any metrics")
+        return 1
+    }
+
+    var before *benchScrape
+    if len(*metricsURL) > 0 {
+        if before, err = scrapeBench(*metricsURL, metrics); err != nil {
+            fmt.Fprintf(os.Stderr, "unable to scrape hermes server, loss will not be reported: %v\n", err)
+        }
+    }
+
+    var (sent, failed, counted int64; wg sync.WaitGroup)
+    deadline := time.Now().Add(*duration)
+    start := time.Now()
+    for i := 0; i < *concurrency; i++ {
+        client, err := hermes_client.NewFromURL(*target)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "invalid target: %v\n", err)
+            return 1
+        }
+        client.SetEncoding(*encoding)
+        if len(*secret) > 0 {
+            client.SetAuth(*keyID, *secret)
+        }
+        // split rate across senders, and space packets evenly
+        var interval time.Duration
+        if *rate > 0 {
+            interval = time.Duration(float64(time.Second) * float64(*concurrency) / *rate)
+        }
+        wg.Add(1)
+        go func(client *hermes_client.HermesClient, seed int64) {
+            defer wg.Done()
+            random := rand.New(rand.NewSource(seed))
+            next := time.Now()
+            for {
+                if *count > 0 {
+                    if atomic.AddInt64(&sent, 1) > int64(*count) {
+                        atomic.AddInt64(&sent, -1)
+                        return
+                    }
+                } else if time.Now().After(deadline) {
+                    return
+                } else {
+                    atomic.AddInt64(&sent, 1)
+                }
+                metric := metrics[random.Intn(len(metrics))]
+                if err := client.SendUDPPacket(benchPacket(metric, *cardinality, random)); err != nil {
+                    atomic.AddInt64(&failed, 1)
+                } else if metric.metricType != "gauge" && metric.metricType != "set" {
+                    atomic.AddInt64(&counted, 1)
+                }
+                if interval > 0 {
+                    next = next.Add(interval)
+                    if wait := time.Until(next); wait > 0 {
+                        time.Sleep(wait)
+                    }
+                }
+            }
+        }(client, time.Now().UnixNano() + int64(i))
+    }
+    wg.Wait()
+    elapsed := time.Since(start)
+
+    fmt.Printf("sent %d packets (%d failed) across %d metrics in %v\n", sent, failed, len(metrics),
+        elapsed.Round(time.Millisecond))
+    fmt.Printf("achieved rate: %.1f packets/s\n", float64(sent - failed) / elapsed.Seconds())
+    if before == nil {
+        return 0
+    }
+    time.Sleep(*settle)
+    after, err := scrapeBench(*metricsURL, metrics)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "unable to scrape hermes server: %v\n", err)
+        return 1
+    }
+    received := after.samples - before.samples
+    lost := float64(counted) - received
+    var loss float64
+    if counted > 0 {
+        loss = 100 * lost / float64(counted)
+    }
+    fmt.Printf("received %.0f of %d counter, histogram and summary packets (lost %.0f, %.2f%%)\n",
+        received, counted, lost, loss)
+    fmt.Printf("server dropped %.0f and rejected %.0f packets\n", after.dropped - before.dropped,
+        after.rejected - before.rejected)
+    return 0
+}
+
+// function used to list all metrics of a hermes config
+func benchMetrics(config hermes.HermesConfig) []benchMetric {
+    metrics := []benchMetric{}
+    for _, counter := range(config.Counters) {
+        metrics = append(metrics, benchMetric{"counter", counter.MetricName, counter.Labels})
+    }
+    for _, gauge := range(config.Gauges) {
+        metrics = append(metrics, benchMetric{"gauge", gauge.MetricName, gauge.Labels})
+    }
+    for _, histogram := range(config.Histograms) {
+        metrics = append(metrics, benchMetric{"histogram", histogram.MetricName, histogram.Labels})
+    }
+    for _, summary := range(config.Summaries) {
+        metrics = append(metrics, benchMetric{"summary", summary.MetricName, summary.Labels})
+    }
+    for _, set := range(config.Sets) {
+        metrics = append(metrics, benchMetric{"set", set.MetricName, set.Labels})
+    }
+    return metrics
+}
+
+// function used to generate random packet for the given metric.
+// label values are drawn from the given number of distinct values,
+// and observations are exponentially distributed around 1
+func benchPacket(metric benchMetric, cardinality int, random *rand.Rand) interface{} {
+    labels := map[string]string{}
+    for _, label := range(metric.labels) {
+        labels[label] = fmt.Sprintf("%s-%d", label, random.Intn(cardinality))
+    }
+    switch metric.metricType {
+    case "counter":
+        return hermes_client.HermesCounterPacket{MetricName: metric.name,
+            Payload: hermes_client.HermesCounterPayload{CounterLabels: labels}}
+    case "gauge":
+        value := random.Float64() * 100
+        return hermes_client.HermesGaugePacket{MetricName: metric.name,
+            Payload: hermes_client.HermesGaugePayload{GaugeOperation: "set", GaugeValue: &value, GaugeLabels: labels}}
+    case "histogram":
+        return hermes_client.HermesHistogramPacket{MetricName: metric.name,
+            Payload: hermes_client.HermesHistogramPayload{HistogramObservation: random.ExpFloat64(), HistogramLabels: labels}}
+    case "set":
+        return hermes_client.HermesSetPacket{MetricName: metric.name,
+            Payload: hermes_client.HermesSetPayload{SetValue: fmt.Sprintf("value-%d", random.Intn(1000)), SetLabels: labels}}
+    default:
+        return hermes_client.HermesSummaryPacket{MetricName: metric.name,
+            Payload: hermes_client.HermesSummaryPayload{SummaryObservation: random.ExpFloat64(), SummaryLabels: labels}}
+    }
+}
+
+// function used to scrape the number of samples of all given
+// metrics, and the number of dropped and rejected packets
+func scrapeBench(metricsURL string, metrics []benchMetric) (*benchScrape, error) {
+    request, err := http.NewRequest(http.MethodGet, metricsURL, nil)
+    if err != nil {
+        return nil, err
+    }
+    if username := cfg.Get("prometheus_basic_auth_username"); len(username) > 0 {
+        request.SetBasicAuth(username, cfg.Get("prometheus_basic_auth_password"))
+    }
+    response, err := http.DefaultClient.Do(request)
+    if err != nil {
+        return nil, err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("received status %s", response.Status)
+    }
+    var parser expfmt.TextParser
+    families, err := parser.TextToMetricFamilies(response.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    scrape := &benchScrape{
+        dropped: sumSamples(families["hermes_dropped_packets_total"]),
+        rejected: sumSamples(families["hermes_rejected_packets_total"]),
+    }
+    for _, metric := range(metrics) {
+        if metric.metricType != "gauge" && metric.metricType != "set" {
+            scrape.samples += sumSamples(families[metric.name])
+        }
+    }
+    return scrape, nil
+}
+
+// function used to sum the samples of a metric family. counters
+// are summed by value, and histograms and summaries by sample count
+func sumSamples(family *dto.MetricFamily) float64 {
+    if family == nil {
+        return 0
+    }
+    var total float64
+    for _, metric := range(family.Metric) {
+        switch {
+        case metric.Counter != nil:
+            total += metric.Counter.GetValue()
+        case metric.Histogram != nil:
+            total += float64(metric.Histogram.GetSampleCount())
+        case metric.Summary != nil:
+            total += float64(metric.Summary.GetSampleCount())
+        case metric.Untyped != nil:
+            total += metric.Untyped.GetValue()
+        }
+    }
+    return total
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+    "os"
+    "time"
+    "syscall"
     "strings"
     "strconv"
+    "os/signal"
 
     log "github.com/sirupsen/logrus"
 
@@ -16,12 +20,48 @@ var (
         map[string]string{
             "listen_port": "7789",
             "listen_address": "0.0.0.0",
+            "prometheus_port": "8080",
+            "max_packet_size": "8192",
+            "udp_read_buffer_size": "0",
+            "max_decompressed_size": "1048576",
+            "workers": "0",
+            "queue_size": "1024",
+            "drop_policy": "drop_newest",
+            "tcp_listen_address": "",
+            "unix_socket_path": "",
+            "unix_socket_type": "unixgram",
+            "stream_idle_timeout": "300",
+            "max_stream_connections": "1024",
+            "prometheus_tls_cert_file": "",
+            "prometheus_tls_key_file": "",
+            "prometheus_tls_client_ca_file": "",
+            "prometheus_tls_require_client_cert": "false",
+            "prometheus_basic_auth_username": "",
+            "prometheus_basic_auth_password": "",
+            "admin_token": "",
+            "capture_path": "",
+            "capture_max_size": "104857600",
+            "capture_max_files": "3",
             "hermes_config_path" : "/etc/hermes/config.json",
+            "hermes_url": "",
+            "hermes_host": "localhost",
+            "hermes_port": "7789",
+            "hermes_key_id": "",
+            "hermes_secret": "",
             "log_level": "INFO",
         },
     )
 )
 
+// define subcommands of the hermes binary. each subcommand
+// receives the remaining arguments and returns the exit code
+var commands = map[string]func(args []string) int{
+    "tail": RunTail,
+    "replay": RunReplay,
+    "bench": RunBench,
+    "send": RunSend,
+}
+
 // function to set log level from environment variables
 func SetLogLevel() {
     level := cfg.Get("log_level")
@@ -37,15 +77,97 @@ func SetLogLevel() {
     }
 }
 
+// function to set TLS and basic auth settings of the
+// prometheus interface from environment variables
+func SetPrometheusSecurity(server *hermes.HermesServer) {
+    if certFile := cfg.Get("prometheus_tls_cert_file"); len(certFile) > 0 {
+        server.PrometheusTLS = &hermes.TLSConfig{
+            CertFile: certFile,
+            KeyFile: cfg.Get("prometheus_tls_key_file"),
+            ClientCAFile: cfg.Get("prometheus_tls_client_ca_file"),
+            RequireClientCert: strings.ToLower(cfg.Get("prometheus_tls_require_client_cert")) == "true",
+        }
+    }
+    if username := cfg.Get("prometheus_basic_auth_username"); len(username) > 0 {
+        server.PrometheusBasicAuth = &hermes.BasicAuthConfig{
+            Username: username,
+            Password: cfg.Get("prometheus_basic_auth_password"),
+        }
+    }
+}
+
 func main() {
     // set log level for server
     SetLogLevel()
+    // run subcommand if given (i.e. hermes tail)
+    if len(os.Args) > 1 {
+        if command, ok := commands[os.Args[1]]; ok {
+            os.Exit(command(os.Args[2:]))
+        }
+    }
+    RunServer()
+}
 
+// function used to start hermes server with the
+// settings given in the environment variables
+func RunServer() {
     port, err := strconv.Atoi(cfg.Get("listen_port"))
     if err != nil {
         panic("received invalid listen port")
     }
+    // prometheus interface can be disabled by setting port to 0
+    prometheusPort, err := strconv.Atoi(cfg.Get("prometheus_port"))
+    if err != nil {
+        panic("received invalid prometheus port")
+    }
     // start new instance of hermes server
-    hermes.New(cfg.Get("hermes_config_path"), cfg.Get("listen_address"),
-        port).Listen()
+    server := hermes.New(cfg.Get("hermes_config_path"), cfg.Get("listen_address"), port)
+    server.PrometheusPort = prometheusPort
+    if server.MaxPacketSize, err = strconv.Atoi(cfg.Get("max_packet_size")); err != nil {
+        panic("received invalid max packet size")
+    }
+    if server.ReadBufferSize, err = strconv.Atoi(cfg.Get("udp_read_buffer_size")); err != nil {
+        panic("received invalid UDP read buffer size")
+    }
+    if server.MaxDecompressedSize, err = strconv.Atoi(cfg.Get("max_decompressed_size")); err != nil {
+        panic("received invalid max decompressed size")
+    }
+    if server.Workers, err = strconv.Atoi(cfg.Get("workers")); err != nil {
+        panic("received invalid number of workers")
+    }
+    if server.QueueSize, err = strconv.Atoi(cfg.Get("queue_size")); err != nil {
+        panic("received invalid queue size")
+    }
+    server.DropPolicy = cfg.Get("drop_policy")
+    if err := hermes.ValidateDropPolicy(server.DropPolicy); err != nil {
+        panic(err)
+    }
+    server.TCPAddress = cfg.Get("tcp_listen_address")
+    server.UnixSocketPath = cfg.Get("unix_socket_path")
+    server.UnixSocketType = cfg.Get("unix_socket_type")
+    idleTimeout, err := strconv.Atoi(cfg.Get("stream_idle_timeout"))
+    if err != nil {
+        panic("received invalid stream idle timeout")
+    }
+    server.StreamIdleTimeout = time.Second * time.Duration(idleTimeout)
+    if server.MaxStreamConnections, err = strconv.Atoi(cfg.Get("max_stream_connections")); err != nil {
+        panic("received invalid max number of stream connections")
+    }
+    SetPrometheusSecurity(server)
+    server.AdminToken = cfg.Get("admin_token")
+    server.CapturePath = cfg.Get("capture_path")
+    if server.CaptureMaxSize, err = strconv.Atoi(cfg.Get("capture_max_size")); err != nil {
+        panic("received invalid max capture size")
+    }
+    if server.CaptureMaxFiles, err = strconv.Atoi(cfg.Get("capture_max_files")); err != nil {
+        panic("received invalid max number of capture files")
+    }
+    // close server gracefully on shutdown to persist state
+    go func() {
+        signals := make(chan os.Signal, 1)
+        signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+        <-signals
+        server.Close()
+    }()
+    server.Listen()
 }
\ No newline at end of file
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "net"
+    "flag"
+    "time"
+
+    "github.com/PSauerborn/hermes/pkg/hermes"
+)
+
+// function used to re-send all datagrams of a capture file to a
+// target hermes server. datagrams are sent at the original speed
+// by default, which can be scaled with the speed flag or ignored
+// entirely to send datagrams as fast as possible
+func RunReplay(args []string) int {
+    flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+    target := flags.String("target", fmt.Sprintf("localhost:%s", cfg.Get("listen_port")),
+        "address of the target hermes server")
+    network := flags.String("network", "udp", "network used to send datagrams (udp or unixgram)")
+    speed := flags.Float64("speed", 1, "speed relative to the original capture (i.e. 2 replays twice as fast)")
+    max := flags.Bool("max", false, "send datagrams as fast as possible")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "usage: hermes replay [flags] <capture file>")
+        flags.PrintDefaults()
+    }
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+    if flags.NArg() != 1 || *speed <= 0 {
+        flags.Usage()
+        return 2
+    }
+
+    file, err := os.Open(flags.Arg(0))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "unable to open capture file: %v\n", err)
+        return 1
+    }
+    defer file.Close()
+    reader, err := hermes.NewCaptureReader(file)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "unable to read capture file: %v\n", err)
+        return 1
+    }
+    conn, err := net.Dial(*network, *target)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "unable to connect to hermes server: %v\n", err)
+        return 1
+    }
+    defer conn.Close()
+
+    var (sent, failed int; first time.Time)
+    start := time.Now()
+    for {
+        captured, err := reader.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "unable to read capture file: %v\n", err)
+            return 1
+        }
+        // wait until the scaled offset of the datagram in the capture
+        if !*max {
+            if first.IsZero() {
+                first = captured.Timestamp
+            }
+            offset := time.Duration(float64(captured.Timestamp.Sub(first)) / *speed)
+            if wait := time.Until(start.Add(offset)); wait > 0 {
+                time.Sleep(wait)
+            }
+        }
+        if _, err := conn.Write(captured.Packet); err != nil {
+            failed++
+            continue
+        }
+        sent++
+    }
+    fmt.Printf("replayed %d datagrams (%d failed) to %s in %v\n", sent, failed, *target,
+        time.Since(start).Round(time.Millisecond))
+    return 0
+}
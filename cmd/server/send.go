@@ -0,0 +1,278 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "time"
+    "errors"
+    "os/exec"
+    "strings"
+    "syscall"
+    "strconv"
+
+    hermes_client "github.com/PSauerborn/hermes/pkg/client"
+)
+
+// struct used to parse repeated key=value label flags
+type labelFlags map[string]string
+
+func(l labelFlags) String() string {
+    labels := []string{}
+    for key, value := range(l) {
+        labels = append(labels, key + "=" + value)
+    }
+    return strings.Join(labels, ",")
+}
+
+func(l labelFlags) Set(value string) error {
+    parts := strings.SplitN(value, "=", 2)
+    if len(parts) != 2 || len(parts[0]) == 0 {
+        return fmt.Errorf("invalid label '%s' (expected key=value)", value)
+    }
+    l[parts[0]] = parts[1]
+    return nil
+}
+
+// function used to send a single metric update to a hermes server,
+// or to run a command and record its duration and exit status. the
+// server is set with the hermes_url environment variable, or with
+// the hermes_host and hermes_port environment variables
+//
+//   hermes send counter jobs_total --label job=backup
+//   hermes send gauge queue_depth 42
+//   hermes send histogram request_duration_seconds --value 1.3
+//   hermes send set active_users alice
+//   hermes send exec --duration-metric job_duration_seconds -- ./backup.sh
+func RunSend(args []string) int {
+    usage := func() {
+        fmt.Fprintln(os.Stderr, "usage: hermes send <counter|gauge|histogram|summary|set> <metric> [value] [flags]")
+        fmt.Fprintln(os.Stderr, "       hermes send exec [flags] -- <command> [args]")
+    }
+    if len(args) == 0 {
+        usage()
+        return 2
+    }
+    metricType, args := args[0], args[1:]
+
+    flags := flag.NewFlagSet("send " + metricType, flag.ContinueOnError)
+    labels := labelFlags{}
+    flags.Var(labels, "label", "label of the metric as key=value (can be repeated)")
+    value := flags.String("value", "", "value of the gauge or set, or observation of the histogram or summary")
+    operation := flags.String("op", "set", "operation applied to gauges (set, increment or decrement)")
+    encoding := flags.String("encoding", "json", "encoding of packets (json or binary)")
+    // flags only used to wrap commands
+    durationMetric := flags.String("duration-metric", "", "metric used to record the duration (in seconds) of the command")
+    durationType := flags.String("duration-type", "histogram", "type of the duration metric (histogram, summary or gauge)")
+    statusMetric := flags.String("status-metric", "", "counter incremented with the exit code of the command")
+    statusLabel := flags.String("status-label", "exit_code", "label of the status metric set to the exit code")
+
+    var positional []string
+    switch metricType {
+    case "counter", "gauge", "histogram", "summary", "set":
+        var err error
+        if positional, err = parseInterspersed(flags, args); err != nil {
+            return 2
+        }
+        if len(positional) < 1 || len(positional) > 2 {
+            usage()
+            return 2
+        }
+        // value can be given as flag or positional argument
+        if len(positional) == 2 {
+            *value = positional[1]
+        }
+    case "exec":
+        if err := flags.Parse(args); err != nil {
+            return 2
+        }
+        if flags.NArg() == 0 || (len(*durationMetric) == 0 && len(*statusMetric) == 0) {
+            fmt.Fprintln(os.Stderr, "a command and at least one of -duration-metric or -status-metric are required")
+            usage()
+            return 2
+        }
+        switch *durationType {
+        case "histogram", "summary", "gauge":
+        default:
+            fmt.Fprintf(os.Stderr, "invalid duration metric type '%s'\n", *durationType)
+            return 2
+        }
+    default:
+        usage()
+        return 2
+    }
+
+    client, err := newSendClient()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "invalid hermes server: %v\n", err)
+        return 1
+    }
+    client.SetEncoding(*encoding)
+    if closer, ok := client.Transport.(io.Closer); ok {
+        defer closer.Close()
+    }
+
+    if metricType == "exec" {
+        return runWrapped(client, flags.Args(), labels, *durationMetric, *durationType,
+            *statusMetric, *statusLabel)
+    }
+    packet, err := SendPacket(metricType, positional[0], labels, *value, *operation)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 2
+    }
+    if err := client.SendUDPPacket(packet); err != nil {
+        fmt.Fprintf(os.Stderr, "unable to send packet: %v\n", err)
+        return 1
+    }
+    return 0
+}
+
+// function used to create hermes client from environment variables.
+// hermes_url takes precedence over hermes_host and hermes_port, and
+// packets are signed if hermes_key_id and hermes_secret are set
+func newSendClient() (*hermes_client.HermesClient, error) {
+    rawURL := cfg.Get("hermes_url")
+    if len(rawURL) == 0 {
+        rawURL = fmt.Sprintf("udp://%s:%s", cfg.Get("hermes_host"), cfg.Get("hermes_port"))
+    }
+    client, err := hermes_client.NewFromURL(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    if secret := cfg.Get("hermes_secret"); len(secret) > 0 {
+        client.SetAuth(cfg.Get("hermes_key_id"), secret)
+    }
+    return client, nil
+}
+
+// function used to generate packet of the given metric type
+func SendPacket(metricType, metricName string, labels map[string]string, value,
+    operation string) (interface{}, error) {
+    var number float64
+    if len(value) > 0 && metricType != "set" {
+        var err error
+        if number, err = strconv.ParseFloat(value, 64); err != nil {
+            return nil, fmt.Errorf("invalid value '%s'", value)
+        }
+    }
+    switch metricType {
+    case "counter":
+        if len(value) > 0 {
+            return nil, errors.New("counters can only be incremented by one")
+        }
+        return hermes_client.HermesCounterPacket{MetricName: metricName,
+            Payload: hermes_client.HermesCounterPayload{CounterLabels: labels}}, nil
+    case "gauge":
+        payload := hermes_client.HermesGaugePayload{GaugeOperation: operation, GaugeLabels: labels}
+        switch operation {
+        case "set":
+            if len(value) == 0 {
+                return nil, errors.New("a value is required to set gauges")
+            }
+            payload.GaugeValue = &number
+        case "increment", "decrement":
+        default:
+            return nil, fmt.Errorf("invalid gauge operation '%s'", operation)
+        }
+        return hermes_client.HermesGaugePacket{MetricName: metricName, Payload: payload}, nil
+    case "histogram", "summary":
+        if len(value) == 0 {
+            return nil, fmt.Errorf("a value is required to observe %ss", metricType)
+        }
+        if metricType == "histogram" {
+            return hermes_client.HermesHistogramPacket{MetricName: metricName,
+                Payload: hermes_client.HermesHistogramPayload{HistogramObservation: number, HistogramLabels: labels}}, nil
+        }
+        return hermes_client.HermesSummaryPacket{MetricName: metricName,
+            Payload: hermes_client.HermesSummaryPayload{SummaryObservation: number, SummaryLabels: labels}}, nil
+    case "set":
+        if len(value) == 0 {
+            return nil, errors.New("a value is required to add to sets")
+        }
+        return hermes_client.HermesSetPacket{MetricName: metricName,
+            Payload: hermes_client.HermesSetPayload{SetValue: value, SetLabels: labels}}, nil
+    }
+    return nil, fmt.Errorf("invalid metric type '%s'", metricType)
+}
+
+// function used to run command and record its duration and exit
+// status. the exit code of the command is returned, where commands
+// killed by a signal are recorded with exit code 128 + signal (as
+// reported by shells) and commands that cannot be started with 127
+func runWrapped(client *hermes_client.HermesClient, command []string, labels labelFlags,
+    durationMetric, durationType, statusMetric, statusLabel string) int {
+    cmd := exec.Command(command[0], command[1:]...)
+    cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+    start := time.Now()
+    err := cmd.Run()
+    duration := time.Since(start)
+    exitCode := 0
+    if err != nil {
+        var exitErr *exec.ExitError
+        if errors.As(err, &exitErr) {
+            exitCode = exitStatus(exitErr)
+        } else {
+            fmt.Fprintf(os.Stderr, "unable to run command: %v\n", err)
+            exitCode = 127
+        }
+    }
+
+    if len(durationMetric) > 0 {
+        packet, err := SendPacket(durationType, durationMetric, labels,
+            strconv.FormatFloat(duration.Seconds(), 'f', -1, 64), "set")
+        if err == nil {
+            err = client.SendUDPPacket(packet)
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "unable to record duration: %v\n", err)
+        }
+    }
+    if len(statusMetric) > 0 {
+        // the status metric carries all labels of the duration metric,
+        // where the exit code takes precedence over a label of the same name
+        statusLabels := map[string]string{}
+        for key, value := range(labels) {
+            statusLabels[key] = value
+        }
+        statusLabels[statusLabel] = strconv.Itoa(exitCode)
+        packet, _ := SendPacket("counter", statusMetric, statusLabels, "", "")
+        if err := client.SendUDPPacket(packet); err != nil {
+            fmt.Fprintf(os.Stderr, "unable to record exit status: %v\n", err)
+        }
+    }
+    return exitCode
+}
+
+// function used to retrieve exit code of command that has exited.
+// the exit code of commands killed by a signal is 128 + signal
+func exitStatus(exitErr *exec.ExitError) int {
+    if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+        return 128 + int(status.Signal())
+    }
+    return exitErr.ExitCode()
+}
+
+// function used to parse flags given before, between or after
+// positional arguments. the positional arguments are returned
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+    positional := []string{}
+    for {
+        if err := flags.Parse(args); err != nil {
+            return nil, err
+        }
+        rest := flags.Args()
+        // all arguments after a terminating "--" are positional
+        if len(rest) > 0 && len(rest) < len(args) && args[len(args) - len(rest) - 1] == "--" {
+            return append(positional, rest...), nil
+        }
+        args = rest
+        if len(args) == 0 {
+            return positional, nil
+        }
+        positional = append(positional, args[0])
+        args = args[1:]
+    }
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "bufio"
+    "strings"
+    "net/url"
+    "net/http"
+    "encoding/json"
+
+    "github.com/PSauerborn/hermes/pkg/hermes"
+)
+
+// function used to stream processed packets from the tail endpoint
+// of a running hermes server. events are printed one per line, either
+// formatted or as raw JSON
+func RunTail(args []string) int {
+    flags := flag.NewFlagSet("tail", flag.ContinueOnError)
+    serverURL := flags.String("url", fmt.Sprintf("http://localhost:%s", cfg.Get("prometheus_port")),
+        "URL of the hermes prometheus interface")
+    token := flags.String("token", cfg.Get("admin_token"), "admin token of the hermes server")
+    metric := flags.String("metric", "", "only show packets for the given metric")
+    source := flags.String("source", "", "only show packets from the given IP address or CIDR range")
+    outcome := flags.String("outcome", "", "only show packets with the given outcome (accepted, rejected or a rejection reason)")
+    rate := flags.Float64("rate", 0, "max number of events per second")
+    raw := flags.Bool("json", false, "print events as raw JSON")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+
+    query := url.Values{}
+    for key, value := range(map[string]string{"metric": *metric, "source": *source, "outcome": *outcome}) {
+        if len(value) > 0 {
+            query.Set(key, value)
+        }
+    }
+    if *rate > 0 {
+        query.Set("rate", fmt.Sprintf("%g", *rate))
+    }
+    request, err := http.NewRequest(http.MethodGet, strings.TrimRight(*serverURL, "/") + "/api/v1/tail?" + query.Encode(), nil)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "invalid hermes URL: %v\n", err)
+        return 1
+    }
+    request.Header.Set("Authorization", "Bearer " + *token)
+    request.Header.Set("Accept", "text/event-stream")
+    response, err := http.DefaultClient.Do(request)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "unable to connect to hermes server: %v\n", err)
+        return 1
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        fmt.Fprintf(os.Stderr, "unable to tail hermes server: received status %s\n", response.Status)
+        return 1
+    }
+
+    scanner := bufio.NewScanner(response.Body)
+    scanner.Buffer(make([]byte, 64 * 1024), hermes.MaxStreamPacketSize)
+    for scanner.Scan() {
+        line := scanner.Text()
+        switch {
+        case strings.HasPrefix(line, ": dropped"):
+            fmt.Fprintln(os.Stderr, strings.TrimPrefix(line, ": "))
+        case strings.HasPrefix(line, "data: "):
+            data := strings.TrimPrefix(line, "data: ")
+            if *raw {
+                fmt.Println(data)
+                continue
+            }
+            var event hermes.TailEvent
+            if err := json.Unmarshal([]byte(data), &event); err != nil {
+                fmt.Fprintf(os.Stderr, "unable to parse tail event: %v\n", err)
+                continue
+            }
+            fmt.Println(FormatTailEvent(event))
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        fmt.Fprintf(os.Stderr, "tail stream closed: %v\n", err)
+        return 1
+    }
+    return 0
+}
+
+// function used to format tail event as a single line
+func FormatTailEvent(event hermes.TailEvent) string {
+    payload, _ := json.Marshal(event.Payload)
+    line := fmt.Sprintf("%s %-21s %-24s %-20s %s", event.Timestamp.Format("15:04:05.000"),
+        event.Source, event.MetricName, event.Outcome, payload)
+    if len(event.Error) > 0 {
+        line += fmt.Sprintf(" (%s)", event.Error)
+    }
+    return line
+}
@@ -31,4 +31,9 @@ func main() {
     // make observation on summary
     client.ObserveSummary("sample_summary",
         map[string]string{"label_1": "test-label"}, 5)
+
+    // make observation on histogram with exemplar
+    client.ObserveHistogramWithExemplar("sample_histogram",
+        map[string]string{"label_1": "test-label"}, 1233,
+        hermes_client.HermesExemplar{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"})
 }
\ No newline at end of file
@@ -3,6 +3,11 @@ module github.com/PSauerborn/hermes
 go 1.14
 
 require (
+	github.com/golang/snappy v0.0.2
+	github.com/klauspost/compress v1.11.13
 	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.10.0
 	github.com/sirupsen/logrus v1.7.0
+	google.golang.org/protobuf v1.23.0
 )
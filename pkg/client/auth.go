@@ -0,0 +1,64 @@
+package hermes_client
+
+import (
+    "time"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+// struct used to define format of signed packets and packets
+// that carry a metric definition. the payload is kept in its
+// raw form to ensure that the signature is generated over the
+// exact bytes sent
+type HermesSignedPacket struct {
+    MetricName string                  `json:"metric_name"`
+    Payload    json.RawMessage         `json:"payload"`
+    KeyID      string                  `json:"key_id,omitempty"`
+    Timestamp  int64                   `json:"timestamp,omitempty"`
+    Nonce      string                  `json:"nonce,omitempty"`
+    Signature  string                  `json:"signature,omitempty"`
+    Definition *HermesMetricDefinition `json:"definition,omitempty"`
+}
+
+// function used to attach metric definition to JSON packet and to
+// sign the packet if a signing key is set. a random nonce is added
+// to each signed packet so that identical packets are not rejected
+// as replays by the server
+func(c *HermesClient) wrapPacket(packet []byte, definition *HermesMetricDefinition) ([]byte, error) {
+    var signed HermesSignedPacket
+    if err := json.Unmarshal(packet, &signed); err != nil {
+        return nil, err
+    }
+    signed.Definition = definition
+    if len(c.Secret) > 0 {
+        var err error
+        signed.KeyID = c.KeyID
+        signed.Timestamp, signed.Nonce, signed.Signature, err = c.sign(signed.MetricName,
+            encodeDefinition(definition), signed.Payload)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return json.Marshal(signed)
+}
+
+// function used to generate timestamp, nonce and signature of a
+// packet with the given metric name, definition and raw payload
+func(c *HermesClient) sign(metricName string, definition *protocol.Definition,
+    payload []byte) (int64, string, string, error) {
+    bytesNonce := make([]byte, 8)
+    if _, err := rand.Read(bytesNonce); err != nil {
+        return 0, "", "", err
+    }
+    timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+    nonce := hex.EncodeToString(bytesNonce)
+    var encoded []byte
+    if definition != nil {
+        encoded = definition.Marshal()
+    }
+    return timestamp, nonce, utils.SignPayload(c.Secret, metricName, timestamp, nonce, encoded, payload), nil
+}
@@ -0,0 +1,82 @@
+package hermes_client
+
+import (
+    "fmt"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+// define encodings supported by the hermes client
+const (
+    EncodingJSON   = "json"
+    EncodingBinary = "binary"
+)
+
+// function used to set encoding used to send packets. the
+// binary encoding produces considerably smaller packets, but
+// requires a hermes server that supports the binary protocol
+func(c *HermesClient) SetEncoding(encoding string) {
+    c.Encoding = encoding
+}
+
+// function used to encode packet with the binary protocol.
+// packets are signed if a signing key is set
+func(c *HermesClient) encodeBinary(packet interface{}) ([]byte, error) {
+    var binary protocol.Packet
+    switch p := packet.(type) {
+    case HermesCounterPacket:
+        binary.MetricName = p.MetricName
+        binary.Payload = protocol.Counter{
+            Labels: p.Payload.CounterLabels,
+            Exemplar: encodeExemplar(p.Payload.CounterExemplar),
+        }.Marshal()
+    case HermesGaugePacket:
+        binary.MetricName = p.MetricName
+        binary.Payload = protocol.Gauge{
+            Labels: p.Payload.GaugeLabels,
+            Operation: p.Payload.GaugeOperation,
+            Value: p.Payload.GaugeValue,
+        }.Marshal()
+    case HermesHistogramPacket:
+        binary.MetricName = p.MetricName
+        binary.Payload = protocol.Histogram{
+            Labels: p.Payload.HistogramLabels,
+            Observation: p.Payload.HistogramObservation,
+            Exemplar: encodeExemplar(p.Payload.HistogramExemplar),
+        }.Marshal()
+    case HermesSummaryPacket:
+        binary.MetricName = p.MetricName
+        binary.Payload = protocol.Summary{
+            Labels: p.Payload.SummaryLabels,
+            Observation: p.Payload.SummaryObservation,
+        }.Marshal()
+    case HermesSetPacket:
+        binary.MetricName = p.MetricName
+        binary.Payload = protocol.Set{
+            Labels: p.Payload.SetLabels,
+            Value: p.Payload.SetValue,
+        }.Marshal()
+    default:
+        return nil, fmt.Errorf("unsupported packet type %T", packet)
+    }
+    binary.Definition = encodeDefinition(c.definition(packet))
+    // sign packet if a signing key is set
+    if len(c.Secret) > 0 {
+        var err error
+        binary.KeyID = c.KeyID
+        binary.Timestamp, binary.Nonce, binary.Signature, err = c.sign(binary.MetricName,
+            binary.Definition, binary.Payload)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return binary.Marshal(), nil
+}
+
+// function used to convert exemplar to binary format
+func encodeExemplar(exemplar *HermesExemplar) *protocol.Exemplar {
+    if exemplar == nil {
+        return nil
+    }
+    return &protocol.Exemplar{TraceID: exemplar.TraceID, SpanID: exemplar.SpanID, Value: exemplar.Value}
+}
@@ -0,0 +1,128 @@
+package hermes_client
+
+import (
+    "fmt"
+    "bytes"
+    "errors"
+    "compress/gzip"
+
+    "github.com/golang/snappy"
+    "github.com/klauspost/compress/zstd"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    ErrHermesPacketCompression = errors.New("Unable to compress hermes udp packet")
+)
+
+// define compression algorithms supported by the hermes client
+const (
+    CompressionNone   = ""
+    CompressionGzip   = "gzip"
+    CompressionZstd   = "zstd"
+    CompressionSnappy = "snappy"
+)
+
+// function used to compress all datagrams sent to the hermes server
+// that exceed the given threshold (in bytes). compression is only
+// applied to datagrams, and is disabled with CompressionNone
+func(c *HermesClient) SetCompression(algorithm string, threshold int) {
+    c.Compression, c.CompressionThreshold = algorithm, threshold
+}
+
+// function used to send a batch of packets to the hermes server. if
+// the client is set to compress datagrams and the batch exceeds the
+// compression threshold, the packets are sent in a single compressed
+// datagram. otherwise, each packet is sent separately. note that the
+// size of compressed batches is still limited by the max packet size
+// of the server
+func(c *HermesClient) SendBatch(packets ...interface{}) error {
+    var batch []byte
+    encoded := make([][]byte, 0, len(packets))
+    for _, packet := range(packets) {
+        bytes, err := c.encodePacket(packet)
+        if err != nil {
+            return err
+        }
+        encoded = append(encoded, bytes)
+        batch = append(batch, framePacket(bytes)...)
+    }
+    if !c.shouldCompress(batch) {
+        for _, bytes := range(encoded) {
+            if err := c.send(bytes); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    compressed, err := compress(batch, c.Compression)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to compress batch: %v", err))
+        return ErrHermesPacketCompression
+    }
+    return c.send(compressed)
+}
+
+// function used to determine if data should be compressed. data
+// is compressed if the client is set to compress datagrams and the
+// data exceeds the threshold. packets sent over stream connections
+// are never compressed
+func(c *HermesClient) shouldCompress(data []byte) bool {
+    if c.Compression == CompressionNone || len(data) <= c.CompressionThreshold {
+        return false
+    }
+    _, stream := c.Transport.(*StreamTransport)
+    return !stream
+}
+
+// function used to compress packet if the client is set to
+// compress datagrams and the packet exceeds the threshold. the
+// packet is framed as a batch containing a single packet
+func(c *HermesClient) compressPacket(packet []byte) ([]byte, error) {
+    if !c.shouldCompress(packet) {
+        return packet, nil
+    }
+    compressed, err := compress(framePacket(packet), c.Compression)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to compress udp packet: %v", err))
+        return nil, ErrHermesPacketCompression
+    }
+    return compressed, nil
+}
+
+// function used to compress data with the given algorithm
+func compress(data []byte, algorithm string) ([]byte, error) {
+    var buffer bytes.Buffer
+    switch algorithm {
+    case CompressionGzip:
+        writer := gzip.NewWriter(&buffer)
+        if _, err := writer.Write(data); err != nil {
+            return nil, err
+        }
+        if err := writer.Close(); err != nil {
+            return nil, err
+        }
+    case CompressionZstd:
+        writer, err := zstd.NewWriter(&buffer, zstd.WithEncoderConcurrency(1))
+        if err != nil {
+            return nil, err
+        }
+        if _, err := writer.Write(data); err != nil {
+            return nil, err
+        }
+        if err := writer.Close(); err != nil {
+            return nil, err
+        }
+    case CompressionSnappy:
+        writer := snappy.NewBufferedWriter(&buffer)
+        if _, err := writer.Write(data); err != nil {
+            return nil, err
+        }
+        if err := writer.Close(); err != nil {
+            return nil, err
+        }
+    default:
+        return nil, fmt.Errorf("unsupported compression algorithm '%s'", algorithm)
+    }
+    return buffer.Bytes(), nil
+}
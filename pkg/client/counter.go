@@ -13,7 +13,8 @@ type HermesCounterPacket struct {
 }
 
 type HermesCounterPayload struct {
-    CounterLabels map[string]string `json:"labels"`
+    CounterLabels   map[string]string `json:"labels"`
+    CounterExemplar *HermesExemplar   `json:"exemplar,omitempty"`
 }
 
 // function used to increment counter value
@@ -27,4 +28,19 @@ func(c *HermesClient) IncrementCounter(metricName string, labels map[string]stri
         },
     }
     c.SendUDPPacket(packet)
+}
+
+// function used to increment counter value with an exemplar
+func(c *HermesClient) IncrementCounterWithExemplar(metricName string, labels map[string]string,
+    exemplar HermesExemplar) {
+    log.Debug(fmt.Sprintf("incrementing counter %s with exemplar %+v", metricName, exemplar))
+    // generate UDP packet and send over client
+    packet := HermesCounterPacket{
+        MetricName: metricName,
+        Payload: HermesCounterPayload{
+            CounterLabels: labels,
+            CounterExemplar: &exemplar,
+        },
+    }
+    c.SendUDPPacket(packet)
 }
\ No newline at end of file
@@ -0,0 +1,65 @@
+package hermes_client
+
+import (
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+// struct used to define metric that is registered dynamically
+// by the hermes server on first use. the type is one of counter,
+// gauge, histogram, summary or set, and the buckets are only used for
+// histograms. note that the hermes server must be configured to
+// accept dynamic metrics
+type HermesMetricDefinition struct {
+    Type        string    `json:"type"`
+    Description string    `json:"description"`
+    Labels      []string  `json:"labels"`
+    Buckets     []float64 `json:"buckets,omitempty"`
+}
+
+// function used to define a metric that is sent with all packets
+// for the metric, so that the hermes server registers the metric
+// on first use (and again after restarts). note that metrics must
+// be defined before packets are sent from multiple goroutines
+func(c *HermesClient) DefineMetric(metricName string, definition HermesMetricDefinition) {
+    if c.Definitions == nil {
+        c.Definitions = map[string]HermesMetricDefinition{}
+    }
+    c.Definitions[metricName] = definition
+}
+
+// function used to convert metric definition to binary format
+func encodeDefinition(definition *HermesMetricDefinition) *protocol.Definition {
+    if definition == nil {
+        return nil
+    }
+    return &protocol.Definition{
+        Type: definition.Type,
+        Description: definition.Description,
+        Labels: definition.Labels,
+        Buckets: definition.Buckets,
+    }
+}
+
+// function used to retrieve definition of the metric of a packet
+func(c *HermesClient) definition(packet interface{}) *HermesMetricDefinition {
+    if len(c.Definitions) == 0 {
+        return nil
+    }
+    var metricName string
+    switch p := packet.(type) {
+    case HermesCounterPacket:
+        metricName = p.MetricName
+    case HermesGaugePacket:
+        metricName = p.MetricName
+    case HermesHistogramPacket:
+        metricName = p.MetricName
+    case HermesSummaryPacket:
+        metricName = p.MetricName
+    case HermesSetPacket:
+        metricName = p.MetricName
+    }
+    if definition, ok := c.Definitions[metricName]; ok {
+        return &definition
+    }
+    return nil
+}
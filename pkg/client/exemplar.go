@@ -0,0 +1,13 @@
+package hermes_client
+
+
+// struct used to define exemplar attached to counter increments
+// and histogram observations. exemplars link a metric update to a
+// particular trace. the value of counter exemplars is the amount
+// that the counter is incremented by (defaults to 1), while the
+// value of histogram exemplars is always the observation
+type HermesExemplar struct {
+    TraceID string   `json:"trace_id"`
+    SpanID  string   `json:"span_id,omitempty"`
+    Value   *float64 `json:"value,omitempty"`
+}
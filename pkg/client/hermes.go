@@ -3,6 +3,7 @@ package hermes_client
 import (
     "fmt"
     "net"
+    "strconv"
     "errors"
     "encoding/json"
 
@@ -13,12 +14,36 @@ var (
     // define custom errors
     ErrHermesConnection = errors.New("Cannot connect to hermes server")
     ErrHermesPacketJSON = errors.New("Unable to convert hermes udp packet to JSON format")
+    ErrHermesPacketBinary = errors.New("Unable to convert hermes udp packet to binary format")
 )
 
+// interface used to define the transport that hermes
+// packets are delivered over once converted to JSON.
+// if no transport is set on the client, packets are
+// sent to the hermes server over UDP
+type Transport interface {
+    Send(packet []byte) error
+}
+
 // struct used to container hermes client details
 type HermesClient struct {
     HermesHost string
     HermesPort int
+
+    Transport  Transport
+    // encoding used to send packets (json or binary)
+    Encoding   string
+    // optional compression applied to datagrams that
+    // exceed the compression threshold (in bytes)
+    Compression          string
+    CompressionThreshold int
+
+    // optional key used to sign packets
+    KeyID      string
+    Secret     string
+
+    // optional definitions of metrics registered dynamically
+    Definitions map[string]HermesMetricDefinition
 }
 
 // function used to generate new hermes client
@@ -29,24 +54,90 @@ func New(host string, port int) *HermesClient {
     }
 }
 
+// function used to generate new hermes client that
+// delivers packets over a custom transport
+func NewWithTransport(transport Transport) *HermesClient {
+    return &HermesClient{Transport: transport}
+}
+
+// function used to encode packet with the encoding of the
+// client. packets are signed if a signing key is set, and
+// carry the definition of the metric if it has been defined
+func(c *HermesClient) encodePacket(packet interface{}) ([]byte, error) {
+    if c.Encoding == EncodingBinary {
+        bytes, err := c.encodeBinary(packet)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to convert udp packet to binary format: %v", err))
+            return nil, ErrHermesPacketBinary
+        }
+        return bytes, nil
+    }
+    // convert JSON packet into bytes array
+    bytes, err := json.Marshal(packet)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to convert udp packet to JSON: %v", err))
+        return nil, ErrHermesPacketJSON
+    }
+    // sign packet and attach definition of metric if set
+    if definition := c.definition(packet); len(c.Secret) > 0 || definition != nil {
+        if bytes, err = c.wrapPacket(bytes, definition); err != nil {
+            log.Error(fmt.Errorf("unable to sign udp packet: %v", err))
+            return nil, ErrHermesPacketJSON
+        }
+    }
+    return bytes, nil
+}
+
+// function used to set the key used to sign all packets
+// sent to the hermes server with HMAC signatures
+func(c *HermesClient) SetAuth(keyID, secret string) {
+    c.KeyID, c.Secret = keyID, secret
+}
+
 // define function used to send UDP packet to Hermes
-// server. UDP Packets are converted to JSON before send
+// server. UDP Packets are converted to JSON before send,
+// unless the client is set to use the binary encoding. packets
+// are compressed if compression is set on the client
 func(c *HermesClient) SendUDPPacket(packet interface{}) error {
     log.Debug(fmt.Sprintf("sending new udp packet %+v to hermes server", packet))
-    // connect to hermes server and defer closing of connection
-    conn, err := net.Dial("udp", fmt.Sprintf("%s:%d", c.HermesHost, c.HermesPort))
+    bytes, err := c.EncodePacket(packet)
     if err != nil {
-        log.Error(fmt.Errorf("unable to connect to hermes server: %v", err))
-        return errors.New(fmt.Sprintf("cannot connect to hermes server %s:%d", c.HermesHost, c.HermesPort))
+        return err
     }
-    defer conn.Close()
+    return c.send(bytes)
+}
 
-    // convert JSON packet into bytes array
-    bytes, err := json.Marshal(packet)
+// function used to encode (and compress) packet into the datagram
+// sent to the hermes server. encoded datagrams can be stored and
+// sent at a later point with Send
+func(c *HermesClient) EncodePacket(packet interface{}) ([]byte, error) {
+    bytes, err := c.encodePacket(packet)
     if err != nil {
-        log.Error(fmt.Errorf("unable to convert udp packet to JSON: %v", err))
-        return ErrHermesPacketJSON
+        return nil, err
+    }
+    return c.compressPacket(bytes)
+}
+
+// function used to send encoded datagram to hermes server
+func(c *HermesClient) Send(bytes []byte) error {
+    return c.send(bytes)
+}
+
+// function used to send encoded packet to hermes server
+func(c *HermesClient) send(bytes []byte) error {
+    // send packet over custom transport if set
+    if c.Transport != nil {
+        return c.Transport.Send(bytes)
     }
+
+    // connect to hermes server and defer closing of connection
+    address := net.JoinHostPort(c.HermesHost, strconv.Itoa(c.HermesPort))
+    conn, err := net.Dial("udp", address)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to connect to hermes server: %v", err))
+        return errors.New(fmt.Sprintf("cannot connect to hermes server %s", address))
+    }
+    defer conn.Close()
     // write hermes packet over UDP socket
     conn.Write(bytes)
     return nil
@@ -15,6 +15,7 @@ type HermesHistogramPacket struct {
 type HermesHistogramPayload struct {
     HistogramObservation float64           `json:"observation"`
     HistogramLabels      map[string]string `json:"labels"`
+    HistogramExemplar    *HermesExemplar   `json:"exemplar,omitempty"`
 }
 
 // function used to make an observation on a histogram metric
@@ -28,4 +29,20 @@ func(c *HermesClient) ObserveHistogram(metricName string, labels map[string]stri
         },
     }
     c.SendUDPPacket(packet)
+}
+
+// function used to make an observation on a histogram metric with
+// an exemplar linking the observation to a particular trace
+func(c *HermesClient) ObserveHistogramWithExemplar(metricName string, labels map[string]string,
+    observation float64, exemplar HermesExemplar) {
+    log.Debug(fmt.Sprintf("setting observation on histogram %s with exemplar %+v", metricName, exemplar))
+    packet := HermesHistogramPacket{
+        MetricName: metricName,
+        Payload: HermesHistogramPayload{
+            HistogramLabels: labels,
+            HistogramObservation: observation,
+            HistogramExemplar: &exemplar,
+        },
+    }
+    c.SendUDPPacket(packet)
 }
\ No newline at end of file
@@ -0,0 +1,32 @@
+package hermes_client
+
+import (
+    "fmt"
+
+    log "github.com/sirupsen/logrus"
+)
+
+
+type HermesSetPacket struct {
+    MetricName string           `json:"metric_name"`
+    Payload    HermesSetPayload `json:"payload"`
+}
+
+type HermesSetPayload struct {
+    SetValue  string            `json:"value"`
+    SetLabels map[string]string `json:"labels"`
+}
+
+// function used to add a value to a set metric, which counts
+// the unique values received over each aggregation window
+func(c *HermesClient) AddToSet(metricName string, labels map[string]string, value string) {
+    log.Debug(fmt.Sprintf("adding value to set %s", metricName))
+    packet := HermesSetPacket{
+        MetricName: metricName,
+        Payload: HermesSetPayload{
+            SetLabels: labels,
+            SetValue: value,
+        },
+    }
+    c.SendUDPPacket(packet)
+}
@@ -0,0 +1,205 @@
+package hermes_client
+
+import (
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "errors"
+    "strconv"
+    "net/url"
+    "encoding/binary"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+var (
+    // define timeout of writes to stream connections, and the
+    // duration after which idle stream connections are re-established
+    DefaultStreamWriteTimeout = 5 * time.Second
+    DefaultStreamIdleTimeout  = time.Minute
+
+    ErrInvalidHermesURL = errors.New("Invalid hermes server URL")
+)
+
+// function used to generate new hermes client from a URL. the
+// scheme of the URL determines the transport used to deliver
+// packets, and can be one of udp://host:port, tcp://host:port,
+// unix:///path/to/socket (stream) or unixgram:///path/to/socket
+func NewFromURL(rawURL string) (*HermesClient, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidHermesURL, err)
+    }
+    switch u.Scheme {
+    case "udp":
+        port, err := strconv.Atoi(u.Port())
+        if err != nil {
+            return nil, fmt.Errorf("%w: invalid port '%s'", ErrInvalidHermesURL, u.Port())
+        }
+        return New(u.Hostname(), port), nil
+    case "tcp":
+        if len(u.Host) == 0 {
+            return nil, fmt.Errorf("%w: missing host", ErrInvalidHermesURL)
+        }
+        return NewWithTransport(NewStreamTransport("tcp", u.Host)), nil
+    case "unix":
+        if len(u.Path) == 0 {
+            return nil, fmt.Errorf("%w: missing socket path", ErrInvalidHermesURL)
+        }
+        return NewWithTransport(NewStreamTransport("unix", u.Path)), nil
+    case "unixgram":
+        if len(u.Path) == 0 {
+            return nil, fmt.Errorf("%w: missing socket path", ErrInvalidHermesURL)
+        }
+        return NewWithTransport(&DatagramTransport{Network: "unixgram", Address: u.Path}), nil
+    }
+    return nil, fmt.Errorf("%w: unsupported scheme '%s'", ErrInvalidHermesURL, u.Scheme)
+}
+
+// struct used to send framed packets over a stream
+// connection (tcp or unix). the connection is kept open between
+// packets and re-established if a write fails, if the server has
+// closed the connection or if the connection has been idle for
+// longer than the idle timeout. note that the protocol has no
+// acknowledgements, so a successful write only means that the
+// packet has been handed to the kernel
+type StreamTransport struct {
+    Network      string
+    Address      string
+    WriteTimeout time.Duration
+    IdleTimeout  time.Duration
+
+    mu      sync.Mutex
+    conn    net.Conn
+    // closed once the connection has been closed by the server
+    broken  chan struct{}
+    last    time.Time
+}
+
+// function used to create new stream transport
+func NewStreamTransport(network, address string) *StreamTransport {
+    return &StreamTransport{Network: network, Address: address,
+        WriteTimeout: DefaultStreamWriteTimeout, IdleTimeout: DefaultStreamIdleTimeout}
+}
+
+// function used to send packet over stream connection. the
+// write is retried once on a new connection if it fails
+func(t *StreamTransport) Send(packet []byte) error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    frame := framePacket(packet)
+    for attempt := 0; attempt < 2; attempt++ {
+        if t.conn != nil && !t.usable() {
+            t.closeConn()
+        }
+        if t.conn == nil {
+            if err := t.dial(); err != nil {
+                log.Error(fmt.Errorf("unable to connect to hermes server: %v", err))
+                return ErrHermesConnection
+            }
+        }
+        if t.WriteTimeout > 0 {
+            t.conn.SetWriteDeadline(time.Now().Add(t.WriteTimeout))
+        }
+        _, err := t.conn.Write(frame)
+        if err == nil {
+            t.last = time.Now()
+            return nil
+        }
+        log.Warn(fmt.Sprintf("unable to write to hermes server. reconnecting: %v", err))
+        t.closeConn()
+    }
+    return ErrHermesConnection
+}
+
+// function used to open new connection. the connection is watched
+// so that connections closed by the server are detected before the
+// next write, rather than after the write has been lost
+func(t *StreamTransport) dial() error {
+    conn, err := net.Dial(t.Network, t.Address)
+    if err != nil {
+        return err
+    }
+    broken := make(chan struct{})
+    go watchConnection(conn, broken)
+    t.conn, t.broken, t.last = conn, broken, time.Now()
+    return nil
+}
+
+// function used to detect connections closed by the server. the
+// server never writes to stream connections, so any read that
+// returns an error means that the connection can no longer be used
+func watchConnection(conn net.Conn, broken chan struct{}) {
+    defer close(broken)
+    buffer := make([]byte, 64)
+    for {
+        if _, err := conn.Read(buffer); err != nil {
+            return
+        }
+    }
+}
+
+// function used to determine if the current connection can still
+// be used, i.e. it has not been closed by the server and has not
+// been idle for longer than the idle timeout
+func(t *StreamTransport) usable() bool {
+    select {
+    case <-t.broken:
+        log.Debug("hermes server closed connection. reconnecting")
+        return false
+    default:
+    }
+    return t.IdleTimeout <= 0 || time.Since(t.last) < t.IdleTimeout
+}
+
+// function used to close current connection
+func(t *StreamTransport) closeConn() error {
+    err := t.conn.Close()
+    t.conn, t.broken = nil, nil
+    return err
+}
+
+// function used to frame packet sent over stream connection.
+// JSON packets are newline delimited, while binary packets are
+// framed by the magic byte followed by the uvarint encoded
+// length of the remainder of the packet
+func framePacket(packet []byte) []byte {
+    if !protocol.IsBinary(packet) {
+        return append(append([]byte{}, packet...), '\n')
+    }
+    frame := []byte{protocol.BinaryMagic}
+    frame = append(frame, make([]byte, binary.MaxVarintLen64)...)
+    n := binary.PutUvarint(frame[1:], uint64(len(packet) - 1))
+    return append(frame[:n + 1], packet[1:]...)
+}
+
+// function used to close stream connection
+func(t *StreamTransport) Close() error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.conn == nil {
+        return nil
+    }
+    return t.closeConn()
+}
+
+// struct used to send each packet as a single datagram
+type DatagramTransport struct {
+    Network string
+    Address string
+}
+
+// function used to send packet as a single datagram
+func(t *DatagramTransport) Send(packet []byte) error {
+    conn, err := net.Dial(t.Network, t.Address)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to connect to hermes server: %v", err))
+        return ErrHermesConnection
+    }
+    defer conn.Close()
+    _, err = conn.Write(packet)
+    return err
+}
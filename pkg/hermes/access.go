@@ -0,0 +1,101 @@
+package hermes
+
+import (
+    "net"
+    "fmt"
+    "errors"
+    "strings"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+)
+
+var (
+    // define map used to store access rules for each metric
+    AccessRules = map[string]*AccessRule{}
+
+    ErrInvalidAccessControl = errors.New("Invalid access control configuration")
+)
+
+// struct used to define parsed access control of a metric
+type AccessRule struct {
+    AllowedKeys    []string
+    AllowedSources []*net.IPNet
+}
+
+// function used to create new access rule from access control
+// config. single IP addresses are treated as /32 (or /128) ranges
+func NewAccessRule(config AccessControl) (*AccessRule, error) {
+    rule := &AccessRule{AllowedKeys: config.AllowedKeys}
+    for _, source := range(config.AllowedSources) {
+        if !strings.Contains(source, "/") {
+            if ip := net.ParseIP(source); ip != nil && ip.To4() != nil {
+                source = source + "/32"
+            } else {
+                source = source + "/128"
+            }
+        }
+        _, network, err := net.ParseCIDR(source)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidAccessControl, err)
+        }
+        rule.AllowedSources = append(rule.AllowedSources, network)
+    }
+    return rule, nil
+}
+
+// function used to register access rule for a metric. no rule
+// is stored if neither keys nor sources are restricted
+func RegisterAccessRule(metricName string, config AccessControl) error {
+    if len(config.AllowedKeys) == 0 && len(config.AllowedSources) == 0 {
+        delete(AccessRules, metricName)
+        return nil
+    }
+    rule, err := NewAccessRule(config)
+    if err != nil {
+        return err
+    }
+    AccessRules[metricName] = rule
+    return nil
+}
+
+// function used to determine if a client is allowed to update a
+// metric. clients are allowed if either the key ID or the source
+// address of the packet matches the access rule of the metric
+func IsAllowed(metricName, keyID string, remoteAddr net.Addr) bool {
+    rule, ok := AccessRules[metricName]
+    if !ok {
+        return true
+    }
+    return rule.Allows(keyID, remoteAddr)
+}
+
+// function used to determine if a client is allowed by an access
+// rule, based on the key ID and source address of the packet
+func(rule *AccessRule) Allows(keyID string, remoteAddr net.Addr) bool {
+    if len(keyID) > 0 && utils.SliceContains(rule.AllowedKeys, keyID) {
+        return true
+    }
+    for _, ip := range(addrIPs(remoteAddr)) {
+        for _, network := range(rule.AllowedSources) {
+            if network.Contains(ip) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// function used to extract IP addresses from network address. note
+// that clients of unix sockets are local, and are therefore matched
+// against both the IPv4 and IPv6 loopback addresses
+func addrIPs(addr net.Addr) []net.IP {
+    switch a := addr.(type) {
+    case *net.UDPAddr:
+        return []net.IP{a.IP}
+    case *net.TCPAddr:
+        return []net.IP{a.IP}
+    case *net.UnixAddr:
+        return []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+    }
+    return nil
+}
@@ -0,0 +1,69 @@
+package hermes
+
+import (
+    "net"
+    "errors"
+    "testing"
+)
+
+// test that clients are allowed if either the key ID or the
+// source address matches the access rule
+func TestAccessRuleAllows(t *testing.T) {
+    udp := func(ip string) net.Addr {
+        return &net.UDPAddr{IP: net.ParseIP(ip), Port: 7789}
+    }
+    tests := []struct {
+        name     string
+        config   AccessControl
+        keyID    string
+        addr     net.Addr
+        expected bool
+    }{
+        {"key match", AccessControl{AllowedKeys: []string{"team-a"}}, "team-a", udp("10.0.0.1"), true},
+        {"key miss", AccessControl{AllowedKeys: []string{"team-a"}}, "team-b", udp("10.0.0.1"), false},
+        {"empty key", AccessControl{AllowedKeys: []string{""}}, "", udp("10.0.0.1"), false},
+        {"key miss with source match", AccessControl{AllowedKeys: []string{"team-a"},
+            AllowedSources: []string{"10.0.0.0/8"}}, "team-b", udp("10.0.0.1"), true},
+        {"IPv4 CIDR match", AccessControl{AllowedSources: []string{"10.1.0.0/16"}}, "", udp("10.1.2.3"), true},
+        {"IPv4 CIDR miss", AccessControl{AllowedSources: []string{"10.1.0.0/16"}}, "", udp("10.2.0.1"), false},
+        {"IPv4 address match", AccessControl{AllowedSources: []string{"10.1.2.3"}}, "", udp("10.1.2.3"), true},
+        {"IPv4 address miss", AccessControl{AllowedSources: []string{"10.1.2.3"}}, "", udp("10.1.2.4"), false},
+        {"IPv6 CIDR match", AccessControl{AllowedSources: []string{"fd00::/8"}}, "", udp("fd00::1"), true},
+        {"IPv6 CIDR miss", AccessControl{AllowedSources: []string{"fd00::/8"}}, "", udp("fe80::1"), false},
+        {"IPv6 address match", AccessControl{AllowedSources: []string{"fd00::1"}}, "", udp("fd00::1"), true},
+        {"IPv4 client of IPv6 range", AccessControl{AllowedSources: []string{"fd00::/8"}}, "", udp("10.0.0.1"), false},
+        {"TCP client", AccessControl{AllowedSources: []string{"10.1.0.0/16"}}, "",
+            &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 7790}, true},
+        {"nil address", AccessControl{AllowedSources: []string{"0.0.0.0/0"}}, "", nil, false},
+        {"nil address with key match", AccessControl{AllowedKeys: []string{"team-a"}}, "team-a", nil, true},
+        {"unix client with IPv4 loopback", AccessControl{AllowedSources: []string{"127.0.0.1"}}, "",
+            &net.UnixAddr{Name: "/var/run/hermes.sock", Net: "unix"}, true},
+        {"unix client with IPv6 loopback", AccessControl{AllowedSources: []string{"::1"}}, "",
+            &net.UnixAddr{Name: "", Net: "unixgram"}, true},
+        {"unix client without loopback", AccessControl{AllowedSources: []string{"10.0.0.0/8"}}, "",
+            &net.UnixAddr{Name: "/var/run/hermes.sock", Net: "unix"}, false},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            rule, err := NewAccessRule(test.config)
+            if err != nil {
+                t.Fatalf("unable to create access rule: %v", err)
+            }
+            if allowed := rule.Allows(test.keyID, test.addr); allowed != test.expected {
+                t.Fatalf("expected allowed to be %t but got %t", test.expected, allowed)
+            }
+        })
+    }
+}
+
+// test that invalid source addresses are rejected
+func TestNewAccessRuleInvalid(t *testing.T) {
+    for _, source := range([]string{"10.0.0", "10.0.0.0/33", "hermes.local", "fd00::/129"}) {
+        t.Run(source, func(t *testing.T) {
+            _, err := NewAccessRule(AccessControl{AllowedSources: []string{source}})
+            if !errors.Is(err, ErrInvalidAccessControl) {
+                t.Fatalf("expected ErrInvalidAccessControl but got %v", err)
+            }
+        })
+    }
+}
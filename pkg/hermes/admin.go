@@ -0,0 +1,296 @@
+package hermes
+
+import (
+    "fmt"
+    "sort"
+    "errors"
+    "strings"
+    "net/http"
+    "crypto/subtle"
+    "encoding/json"
+
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define prefix of all admin API routes
+    AdminAPIPrefix = "/api/v1/metrics"
+
+    ErrMissingLabels = errors.New("Missing label values")
+)
+
+// struct used to define metric returned by the admin API
+type MetricInfo struct {
+    MetricName  string    `json:"metric_name"`
+    Type        string    `json:"type"`
+    Description string    `json:"description"`
+    Labels      []string  `json:"labels"`
+    Buckets     []float64 `json:"buckets,omitempty"`
+    Dynamic     bool      `json:"dynamic"`
+}
+
+// struct used to define current value of a single series returned
+// by the admin API. counters and gauges have a value, while
+// histograms and summaries have a sample count and sum
+type SeriesInfo struct {
+    Labels map[string]string `json:"labels"`
+    Value  *float64          `json:"value,omitempty"`
+    Count  *uint64           `json:"count,omitempty"`
+    Sum    *float64          `json:"sum,omitempty"`
+}
+
+// struct used to define request used to delete series
+type DeleteSeriesRequest struct {
+    Labels map[string]string `json:"labels"`
+}
+
+// interface implemented by all prometheus metric vectors
+type metricVec interface {
+    DeleteLabelValues(values ...string) bool
+    Reset()
+}
+
+// function used to protect HTTP handler with an admin token, which
+// must be sent as a bearer token in the authorization header.
+// requests without the bearer prefix are rejected
+func AdminTokenHandler(token string, handler http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        header := r.Header.Get("Authorization")
+        received := strings.TrimPrefix(header, "Bearer ")
+        // compare tokens in constant time
+        if len(token) == 0 || len(received) == len(header) ||
+            subtle.ConstantTimeCompare([]byte(received), []byte(token)) != 1 {
+            writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+            return
+        }
+        handler.ServeHTTP(w, r)
+    })
+}
+
+// function used to generate HTTP handler serving the admin API,
+// which is used to list, inspect, reset and delete metrics. the
+// following routes are served
+//
+//   GET    /api/v1/metrics               list all registered metrics
+//   GET    /api/v1/metrics/<name>        show metric and current series
+//   DELETE /api/v1/metrics/<name>/series delete series with given labels
+//   POST   /api/v1/metrics/<name>/reset  delete all series of metric
+func AdminHandler(token string) http.Handler {
+    return AdminTokenHandler(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        path := strings.Trim(strings.TrimPrefix(r.URL.Path, AdminAPIPrefix), "/")
+        if len(path) == 0 {
+            if r.Method != http.MethodGet {
+                writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+                return
+            }
+            writeJSON(w, http.StatusOK, ListMetrics())
+            return
+        }
+        parts := strings.Split(path, "/")
+        switch {
+        case len(parts) == 1 && r.Method == http.MethodGet:
+            handleGetMetric(w, parts[0])
+        case len(parts) == 2 && parts[1] == "series" && r.Method == http.MethodDelete:
+            handleDeleteSeries(w, r, parts[0])
+        case len(parts) == 2 && parts[1] == "reset" && r.Method == http.MethodPost:
+            handleResetMetric(w, parts[0])
+        default:
+            writeJSONError(w, http.StatusNotFound, "Not found")
+        }
+    }))
+}
+
+// function used to list all registered metrics sorted by name
+func ListMetrics() []MetricInfo {
+    metricsLock.RLock()
+    defer metricsLock.RUnlock()
+    metrics := []MetricInfo{}
+    if Config == nil {
+        return metrics
+    }
+    for _, counter := range(Config.Counters) {
+        metrics = append(metrics, MetricInfo{MetricName: counter.MetricName, Type: "counter",
+            Description: counter.MetricDescription, Labels: counter.Labels})
+    }
+    for _, gauge := range(Config.Gauges) {
+        metrics = append(metrics, MetricInfo{MetricName: gauge.MetricName, Type: "gauge",
+            Description: gauge.MetricDescription, Labels: gauge.Labels})
+    }
+    for _, histogram := range(Config.Histograms) {
+        metrics = append(metrics, MetricInfo{MetricName: histogram.MetricName, Type: "histogram",
+            Description: histogram.MetricDescription, Labels: histogram.Labels, Buckets: histogram.Buckets})
+    }
+    for _, summary := range(Config.Summaries) {
+        metrics = append(metrics, MetricInfo{MetricName: summary.MetricName, Type: "summary",
+            Description: summary.MetricDescription, Labels: summary.Labels})
+    }
+    for _, set := range(Config.Sets) {
+        metrics = append(metrics, MetricInfo{MetricName: set.MetricName, Type: "set",
+            Description: set.MetricDescription, Labels: set.Labels})
+    }
+    for i := range(metrics) {
+        _, metrics[i].Dynamic = DynamicMetrics[metrics[i].MetricName]
+    }
+    sort.Slice(metrics, func(i, j int) bool { return metrics[i].MetricName < metrics[j].MetricName })
+    return metrics
+}
+
+// function used to retrieve a single registered metric
+func GetMetric(name string) (MetricInfo, error) {
+    for _, metric := range(ListMetrics()) {
+        if metric.MetricName == name {
+            return metric, nil
+        }
+    }
+    return MetricInfo{}, ErrUnregisteredMetric
+}
+
+// function used to retrieve current value of all series of a metric
+func GetSeries(name string) ([]SeriesInfo, error) {
+    series := []SeriesInfo{}
+    families, err := Gatherer.Gather()
+    if err != nil {
+        return series, err
+    }
+    for _, family := range(families) {
+        if family.GetName() != name {
+            continue
+        }
+        for _, metric := range(family.GetMetric()) {
+            s := SeriesInfo{Labels: map[string]string{}}
+            for _, pair := range(metric.GetLabel()) {
+                s.Labels[pair.GetName()] = pair.GetValue()
+            }
+            switch {
+            case metric.Counter != nil:
+                s.Value = metric.Counter.Value
+            case metric.Gauge != nil:
+                s.Value = metric.Gauge.Value
+            case metric.Histogram != nil:
+                s.Count, s.Sum = metric.Histogram.SampleCount, metric.Histogram.SampleSum
+            case metric.Summary != nil:
+                s.Count, s.Sum = metric.Summary.SampleCount, metric.Summary.SampleSum
+            }
+            series = append(series, s)
+        }
+    }
+    return series, nil
+}
+
+// function used to delete series of metric with the given labels.
+// all labels of the metric must be given. returns false if no
+// series with the given labels exists
+func DeleteSeries(name string, labels map[string]string) (bool, error) {
+    metric, err := GetMetric(name)
+    if err != nil {
+        return false, err
+    }
+    // order label values in the order that labels are defined
+    values := make([]string, 0, len(metric.Labels))
+    for _, label := range(metric.Labels) {
+        value, ok := labels[label]
+        if !ok {
+            return false, fmt.Errorf("%w: missing label '%s'", ErrMissingLabels, label)
+        }
+        values = append(values, value)
+    }
+    if len(labels) != len(values) {
+        return false, ErrInvalidLabels
+    }
+    vec, _ := lookupVec(name)
+    return vec.DeleteLabelValues(values...), nil
+}
+
+// function used to delete all series of a metric
+func ResetMetric(name string) error {
+    vec, ok := lookupVec(name)
+    if !ok {
+        return ErrUnregisteredMetric
+    }
+    vec.Reset()
+    return nil
+}
+
+// function used to retrieve metric vector of a metric
+func lookupVec(name string) (metricVec, bool) {
+    metricsLock.RLock()
+    defer metricsLock.RUnlock()
+    if counter, ok := Counters[name]; ok {
+        return counter, true
+    }
+    if gauge, ok := Gauges[name]; ok {
+        return gauge, true
+    }
+    if histogram, ok := Histograms[name]; ok {
+        return histogram, true
+    }
+    if summary, ok := Summaries[name]; ok {
+        return summary, true
+    }
+    if set, ok := Sets[name]; ok {
+        return set, true
+    }
+    return nil, false
+}
+
+// function used to handle request for a single metric
+func handleGetMetric(w http.ResponseWriter, name string) {
+    metric, err := GetMetric(name)
+    if err != nil {
+        writeJSONError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    series, err := GetSeries(name)
+    if err != nil {
+        writeJSONError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]interface{}{"metric": metric, "series": series})
+}
+
+// function used to handle request to delete series
+func handleDeleteSeries(w http.ResponseWriter, r *http.Request, name string) {
+    var request DeleteSeriesRequest
+    if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+        writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+        return
+    }
+    deleted, err := DeleteSeries(name, request.Labels)
+    switch {
+    case errors.Is(err, ErrUnregisteredMetric):
+        writeJSONError(w, http.StatusNotFound, err.Error())
+        return
+    case err != nil:
+        writeJSONError(w, http.StatusBadRequest, err.Error())
+        return
+    case !deleted:
+        writeJSONError(w, http.StatusNotFound, "Series not found")
+        return
+    }
+    log.Info(fmt.Sprintf("deleted series %v of metric '%s' over admin API", request.Labels, name))
+    writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": true})
+}
+
+// function used to handle request to reset metric
+func handleResetMetric(w http.ResponseWriter, name string) {
+    if err := ResetMetric(name); err != nil {
+        writeJSONError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    log.Info(fmt.Sprintf("reset metric '%s' over admin API", name))
+    writeJSON(w, http.StatusOK, map[string]interface{}{"reset": true})
+}
+
+// function used to write JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    if err := json.NewEncoder(w).Encode(body); err != nil {
+        log.Error(fmt.Errorf("unable to write JSON response: %v", err))
+    }
+}
+
+// function used to write JSON error response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+    writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,204 @@
+package hermes
+
+import (
+    "strings"
+    "testing"
+    "net/http"
+    "encoding/json"
+    "net/http/httptest"
+)
+
+// define config of metrics used to test the admin API
+var adminTestConfig = HermesConfig{
+    Counters: []HermesCounter{
+        {MetricName: "requests_total", MetricDescription: "requests", Labels: []string{"app"}},
+    },
+    Histograms: []HermesHistogram{
+        {MetricName: "request_duration", Labels: []string{"app"}, Buckets: []float64{0.1, 1}},
+    },
+    DynamicMetrics: &DynamicMetricsConfig{},
+}
+
+// function used to initialize metrics used to test the admin API.
+// two series of the configured counter and one dynamic metric are
+// created before the admin API is served
+func newAdminTestServer(t *testing.T) *httptest.Server {
+    initTestMetrics(t, adminTestConfig)
+    server := &HermesServer{}
+    for _, packet := range([]string{
+        `{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}`,
+        `{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}`,
+        `{"metric_name": "requests_total", "payload": {"labels": {"app": "api"}}}`,
+        `{"metric_name": "jobs_total", "payload": {}, "definition": {"type": "counter"}}`,
+    }) {
+        server.ProcessPayload([]byte(packet), nil)
+    }
+    admin := httptest.NewServer(AdminHandler("secret"))
+    t.Cleanup(admin.Close)
+    return admin
+}
+
+// function used to send request to the admin API with the admin token
+func adminRequest(t *testing.T, admin *httptest.Server, method, path, body string) (int, map[string]interface{}) {
+    request, err := http.NewRequest(method, admin.URL + path, strings.NewReader(body))
+    if err != nil {
+        t.Fatalf("unable to create request: %v", err)
+    }
+    request.Header.Set("Authorization", "Bearer secret")
+    response, err := http.DefaultClient.Do(request)
+    if err != nil {
+        t.Fatalf("unable to send request: %v", err)
+    }
+    defer response.Body.Close()
+    var decoded interface{}
+    if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+        t.Fatalf("unable to decode response: %v", err)
+    }
+    // wrap list responses so that all responses are objects
+    if object, ok := decoded.(map[string]interface{}); ok {
+        return response.StatusCode, object
+    }
+    return response.StatusCode, map[string]interface{}{"items": decoded}
+}
+
+// test that the admin API is only served with a valid bearer token
+func TestAdminTokenHandler(t *testing.T) {
+    tests := []struct {
+        name          string
+        token         string
+        authorization string
+        expected      int
+    }{
+        {"valid token", "secret", "Bearer secret", http.StatusOK},
+        {"invalid token", "secret", "Bearer wrong", http.StatusUnauthorized},
+        {"missing header", "secret", "", http.StatusUnauthorized},
+        {"missing bearer prefix", "secret", "secret", http.StatusUnauthorized},
+        {"basic auth", "secret", "Basic c2VjcmV0", http.StatusUnauthorized},
+        {"lowercase bearer prefix", "secret", "bearer secret", http.StatusUnauthorized},
+        {"empty bearer token", "secret", "Bearer ", http.StatusUnauthorized},
+        {"no token configured", "", "Bearer ", http.StatusUnauthorized},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            handler := AdminTokenHandler(test.token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.WriteHeader(http.StatusOK)
+            }))
+            request := httptest.NewRequest(http.MethodGet, AdminAPIPrefix, nil)
+            if len(test.authorization) > 0 {
+                request.Header.Set("Authorization", test.authorization)
+            }
+            recorder := httptest.NewRecorder()
+            handler.ServeHTTP(recorder, request)
+            if recorder.Code != test.expected {
+                t.Fatalf("expected status %d but got %d", test.expected, recorder.Code)
+            }
+        })
+    }
+}
+
+// test that registered metrics are listed with the dynamic flag
+func TestAdminListMetrics(t *testing.T) {
+    admin := newAdminTestServer(t)
+    status, body := adminRequest(t, admin, http.MethodGet, AdminAPIPrefix, "")
+    if status != http.StatusOK {
+        t.Fatalf("expected status 200 but got %d", status)
+    }
+    expected := []struct {
+        name    string
+        dynamic bool
+    }{{"jobs_total", true}, {"request_duration", false}, {"requests_total", false}}
+    items := body["items"].([]interface{})
+    if len(items) != len(expected) {
+        t.Fatalf("expected %d metrics but got %v", len(expected), items)
+    }
+    for i, item := range(items) {
+        metric := item.(map[string]interface{})
+        if metric["metric_name"] != expected[i].name || metric["dynamic"] != expected[i].dynamic {
+            t.Fatalf("expected metric %s (dynamic %t) but got %v", expected[i].name, expected[i].dynamic, metric)
+        }
+    }
+    if status, _ := adminRequest(t, admin, http.MethodPost, AdminAPIPrefix, ""); status != http.StatusMethodNotAllowed {
+        t.Fatalf("expected status 405 but got %d", status)
+    }
+}
+
+// test that metrics are returned with the current value of all series
+func TestAdminGetMetric(t *testing.T) {
+    admin := newAdminTestServer(t)
+    status, body := adminRequest(t, admin, http.MethodGet, AdminAPIPrefix + "/requests_total", "")
+    if status != http.StatusOK {
+        t.Fatalf("expected status 200 but got %d", status)
+    }
+    if metric := body["metric"].(map[string]interface{}); metric["type"] != "counter" || metric["dynamic"] != false {
+        t.Fatalf("expected static counter but got %v", metric)
+    }
+    values := map[string]float64{}
+    for _, item := range(body["series"].([]interface{})) {
+        series := item.(map[string]interface{})
+        app := series["labels"].(map[string]interface{})["app"].(string)
+        values[app] = series["value"].(float64)
+    }
+    if len(values) != 2 || values["web"] != 2 || values["api"] != 1 {
+        t.Fatalf("expected series web=2 and api=1 but got %v", values)
+    }
+
+    status, body = adminRequest(t, admin, http.MethodGet, AdminAPIPrefix + "/jobs_total", "")
+    if metric := body["metric"].(map[string]interface{}); status != http.StatusOK || metric["dynamic"] != true {
+        t.Fatalf("expected dynamic counter but got %d %v", status, body)
+    }
+    if status, _ := adminRequest(t, admin, http.MethodGet, AdminAPIPrefix + "/unknown_total", ""); status != http.StatusNotFound {
+        t.Fatalf("expected status 404 but got %d", status)
+    }
+}
+
+// test that series are deleted by their labels
+func TestAdminDeleteSeries(t *testing.T) {
+    tests := []struct {
+        name     string
+        metric   string
+        body     string
+        expected int
+        series   int
+    }{
+        {"existing series", "requests_total", `{"labels": {"app": "web"}}`, http.StatusOK, 1},
+        {"unknown series", "requests_total", `{"labels": {"app": "worker"}}`, http.StatusNotFound, 2},
+        {"missing label", "requests_total", `{"labels": {}}`, http.StatusBadRequest, 2},
+        {"unknown label", "requests_total", `{"labels": {"app": "web", "region": "eu"}}`,
+            http.StatusBadRequest, 2},
+        {"invalid body", "requests_total", `{"labels":`, http.StatusBadRequest, 2},
+        {"unknown metric", "unknown_total", `{"labels": {}}`, http.StatusNotFound, 2},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            admin := newAdminTestServer(t)
+            status, _ := adminRequest(t, admin, http.MethodDelete,
+                AdminAPIPrefix + "/" + test.metric + "/series", test.body)
+            if status != test.expected {
+                t.Fatalf("expected status %d but got %d", test.expected, status)
+            }
+            _, body := adminRequest(t, admin, http.MethodGet, AdminAPIPrefix + "/requests_total", "")
+            if series := body["series"].([]interface{}); len(series) != test.series {
+                t.Fatalf("expected %d series but got %v", test.series, series)
+            }
+        })
+    }
+}
+
+// test that all series of a metric are deleted on reset
+func TestAdminResetMetric(t *testing.T) {
+    admin := newAdminTestServer(t)
+    status, _ := adminRequest(t, admin, http.MethodPost, AdminAPIPrefix + "/requests_total/reset", "")
+    if status != http.StatusOK {
+        t.Fatalf("expected status 200 but got %d", status)
+    }
+    _, body := adminRequest(t, admin, http.MethodGet, AdminAPIPrefix + "/requests_total", "")
+    if series := body["series"].([]interface{}); len(series) != 0 {
+        t.Fatalf("expected no series after reset but got %v", series)
+    }
+    if status, _ := adminRequest(t, admin, http.MethodPost, AdminAPIPrefix + "/unknown_total/reset", ""); status != http.StatusNotFound {
+        t.Fatalf("expected status 404 but got %d", status)
+    }
+    if status, _ := adminRequest(t, admin, http.MethodGet, AdminAPIPrefix + "/requests_total/reset", ""); status != http.StatusNotFound {
+        t.Fatalf("expected status 404 for invalid method but got %d", status)
+    }
+}
@@ -0,0 +1,433 @@
+package hermes
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "sync"
+    "time"
+    "errors"
+    "strconv"
+    "math/rand"
+
+    "github.com/prometheus/client_golang/prometheus"
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+)
+
+var (
+    // define defaults used to aggregate updates. the flush
+    // interval is given in seconds
+    DefaultAggregationInterval = 10
+    DefaultPercentiles         = []float64{50, 90, 99}
+    DefaultMaxSamples          = 10000
+
+    ErrInvalidAggregation = errors.New("Invalid aggregation configuration")
+)
+
+// define label used to distinguish the statistics of the
+// window gauge of aggregated histograms and summaries
+const AggregationStatLabel = "stat"
+
+// struct used to count increments of a counter series
+type windowCounter struct {
+    labels prometheus.Labels
+    count  float64
+}
+
+// struct used to track observations of a histogram or summary
+// series. count, min and max are exact, while percentiles are
+// computed over a uniform sample of the observations
+type windowTimer struct {
+    labels  prometheus.Labels
+    count   int
+    min     float64
+    max     float64
+    samples []float64
+}
+
+// struct used to track unique values of a set series. values are
+// counted exactly up to the max number of samples, after which the
+// values are moved to a hyperloglog sketch and the count is estimated
+type windowSet struct {
+    labels prometheus.Labels
+    values map[string]struct{}
+    sketch *hyperLogLog
+}
+
+// function used to add value to set, switching to the sketch once
+// the set holds more than the given max number of values
+func(s *windowSet) add(value string, maxValues int) {
+    if s.sketch != nil {
+        s.sketch.add(value)
+        return
+    }
+    s.values[value] = struct{}{}
+    if len(s.values) > maxValues {
+        s.sketch = newHyperLogLog()
+        for value := range(s.values) {
+            s.sketch.add(value)
+        }
+        s.values = nil
+    }
+}
+
+// function used to retrieve (estimated) number of unique values
+func(s *windowSet) count() float64 {
+    if s.sketch != nil {
+        return math.Round(s.sketch.count())
+    }
+    return float64(len(s.values))
+}
+
+// struct used to aggregate updates over flush windows, in the style
+// of statsd. counters are exposed as per-second rates in <name>_rate,
+// histograms and summaries as percentiles, min, max and count in
+// <name>_window (with the statistic in the stat label), and sets as
+// the number of unique values. note that the raw metrics are still
+// updated as usual
+type Aggregator struct {
+    Config  AggregationConfig
+    // optional function called after every flush
+    OnFlush func()
+
+    // determines if counters, histograms and summaries are aggregated
+    enabled   bool
+    mu        sync.Mutex
+    last      time.Time
+    counters  map[string]map[string]*windowCounter
+    timers    map[string]map[string]*windowTimer
+    sets      map[string]map[string]*windowSet
+
+    rates     map[string]*prometheus.GaugeVec
+    windows   map[string]*prometheus.GaugeVec
+    setVecs   map[string]*prometheus.GaugeVec
+    // series of windows and sets exposed by the last flush
+    exposed   map[string]map[string]prometheus.Labels
+}
+
+// function used to create new aggregator for the metrics of the
+// given config, which must already have been initialized. the rate
+// and window gauges are registered with the hermes registry
+func NewAggregator(config HermesConfig) (*Aggregator, error) {
+    var aggregation AggregationConfig
+    if config.Aggregation != nil {
+        aggregation = *config.Aggregation
+    }
+    if aggregation.FlushInterval <= 0 {
+        aggregation.FlushInterval = DefaultAggregationInterval
+    }
+    if len(aggregation.Percentiles) == 0 {
+        aggregation.Percentiles = DefaultPercentiles
+    }
+    if aggregation.MaxSamples <= 0 {
+        aggregation.MaxSamples = DefaultMaxSamples
+    }
+    for _, percentile := range(aggregation.Percentiles) {
+        if percentile <= 0 || percentile > 100 {
+            return nil, fmt.Errorf("%w: invalid percentile %g", ErrInvalidAggregation, percentile)
+        }
+    }
+    a := &Aggregator{Config: aggregation, enabled: config.Aggregation != nil, last: time.Now(),
+        counters: map[string]map[string]*windowCounter{}, timers: map[string]map[string]*windowTimer{},
+        sets: map[string]map[string]*windowSet{}, rates: map[string]*prometheus.GaugeVec{},
+        windows: map[string]*prometheus.GaugeVec{}, setVecs: map[string]*prometheus.GaugeVec{},
+        exposed: map[string]map[string]prometheus.Labels{}}
+
+    for _, counter := range(config.Counters) {
+        if !a.aggregates(counter.MetricName) {
+            continue
+        }
+        if err := a.addCounter(counter.MetricName, counter.Labels); err != nil {
+            return nil, err
+        }
+    }
+    for _, histogram := range(config.Histograms) {
+        if !a.aggregates(histogram.MetricName) {
+            continue
+        }
+        if err := a.addTimer(histogram.MetricName, histogram.Labels); err != nil {
+            return nil, err
+        }
+    }
+    for _, summary := range(config.Summaries) {
+        if !a.aggregates(summary.MetricName) {
+            continue
+        }
+        if err := a.addTimer(summary.MetricName, summary.Labels); err != nil {
+            return nil, err
+        }
+    }
+    // sets are always aggregated
+    metricsLock.RLock()
+    defer metricsLock.RUnlock()
+    for _, set := range(config.Sets) {
+        a.sets[set.MetricName] = map[string]*windowSet{}
+        a.setVecs[set.MetricName] = Sets[set.MetricName]
+    }
+    // aggregate dynamic metrics loaded from the dynamic metrics file
+    for name, definition := range(DynamicMetrics) {
+        if err := a.AddDynamicMetric(name, definition); err != nil {
+            return nil, err
+        }
+    }
+    return a, nil
+}
+
+// function used to determine if a counter, histogram or summary
+// is aggregated. only the given metrics are aggregated if set
+func(a *Aggregator) aggregates(name string) bool {
+    return a.enabled && (len(a.Config.Metrics) == 0 || utils.SliceContains(a.Config.Metrics, name))
+}
+
+// function used to aggregate metric that has been registered
+// dynamically. metrics that are already aggregated are skipped.
+// note that the metrics lock must be held, since the gauges of
+// sets are retrieved from the set map
+func(a *Aggregator) AddDynamicMetric(name string, definition MetricDefinition) error {
+    if a == nil {
+        return nil
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    // sets are always aggregated
+    if definition.Type == "set" {
+        if _, ok := a.sets[name]; !ok {
+            a.sets[name], a.setVecs[name] = map[string]*windowSet{}, Sets[name]
+        }
+        return nil
+    }
+    if !a.aggregates(name) {
+        return nil
+    }
+    if _, ok := a.counters[name]; ok {
+        return nil
+    }
+    if _, ok := a.timers[name]; ok {
+        return nil
+    }
+    switch definition.Type {
+    case "counter":
+        return a.addCounter(name, definition.Labels)
+    case "histogram", "summary":
+        return a.addTimer(name, definition.Labels)
+    }
+    return nil
+}
+
+// function used to create rate gauge of an aggregated counter
+func(a *Aggregator) addCounter(name string, labels []string) error {
+    opts := prometheus.GaugeOpts{Name: name + "_rate",
+        Help: fmt.Sprintf("Per-second rate of %s over the last aggregation window", name)}
+    vec := prometheus.NewGaugeVec(opts, labels)
+    if err := Registerer.Register(vec); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidAggregation, err)
+    }
+    a.counters[name], a.rates[name] = map[string]*windowCounter{}, vec
+    return nil
+}
+
+// function used to create window gauge of an aggregated histogram or summary
+func(a *Aggregator) addTimer(name string, labels []string) error {
+    if utils.SliceContains(labels, AggregationStatLabel) {
+        return fmt.Errorf("%w: metric %s uses reserved label '%s'", ErrInvalidAggregation,
+            name, AggregationStatLabel)
+    }
+    opts := prometheus.GaugeOpts{Name: name + "_window",
+        Help: fmt.Sprintf("Statistics of %s over the last aggregation window", name)}
+    vec := prometheus.NewGaugeVec(opts, append(append([]string{}, labels...), AggregationStatLabel))
+    if err := Registerer.Register(vec); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidAggregation, err)
+    }
+    a.timers[name], a.windows[name] = map[string]*windowTimer{}, vec
+    return nil
+}
+
+// function used to record increment of counter by the given value
+func(a *Aggregator) Increment(name string, labels map[string]string, value float64) {
+    if a == nil {
+        return
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    series, ok := a.counters[name]
+    if !ok {
+        return
+    }
+    key := seriesKey(name, labels)
+    counter, ok := series[key]
+    if !ok {
+        counter = &windowCounter{labels: copyLabels(labels)}
+        series[key] = counter
+    }
+    counter.count += value
+}
+
+// function used to record observation of histogram or summary.
+// once the max number of samples is reached, samples are replaced
+// at random so that a uniform sample of the window is kept
+func(a *Aggregator) Observe(name string, labels map[string]string, observation float64) {
+    if a == nil {
+        return
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    series, ok := a.timers[name]
+    if !ok {
+        return
+    }
+    key := seriesKey(name, labels)
+    timer, ok := series[key]
+    if !ok {
+        timer = &windowTimer{labels: copyLabels(labels), min: observation, max: observation}
+        series[key] = timer
+    }
+    timer.count++
+    timer.min = math.Min(timer.min, observation)
+    timer.max = math.Max(timer.max, observation)
+    if len(timer.samples) < a.Config.MaxSamples {
+        timer.samples = append(timer.samples, observation)
+    } else if i := rand.Intn(timer.count); i < a.Config.MaxSamples {
+        timer.samples[i] = observation
+    }
+}
+
+// function used to add value to set. up to max samples unique
+// values are counted exactly per series and window, while larger
+// sets are estimated with a hyperloglog sketch
+func(a *Aggregator) AddToSet(name string, set SetJSON) error {
+    if a == nil {
+        return ErrUnregisteredMetric
+    }
+    labels, err := GenerateLabels(set.Labels, "set", name)
+    if err != nil {
+        return err
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    series, ok := a.sets[name]
+    if !ok {
+        return ErrUnregisteredMetric
+    }
+    key := seriesKey(name, labels)
+    entry, ok := series[key]
+    if !ok {
+        entry = &windowSet{labels: labels, values: map[string]struct{}{}}
+        series[key] = entry
+    }
+    entry.add(set.Value, a.Config.MaxSamples)
+    return nil
+}
+
+// function used to expose aggregates of the current window and start
+// a new window. counter rates are computed over the actual time since
+// the last flush, and are set to 0 for series without increments.
+// windows and sets without updates are removed
+func(a *Aggregator) Flush() {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    now := time.Now()
+    interval := now.Sub(a.last).Seconds()
+    a.last = now
+    if interval <= 0 {
+        return
+    }
+    for name, series := range(a.counters) {
+        for _, counter := range(series) {
+            a.rates[name].With(counter.labels).Set(counter.count / interval)
+            counter.count = 0
+        }
+    }
+    for name, series := range(a.timers) {
+        current := map[string]prometheus.Labels{}
+        for key, timer := range(series) {
+            for stat, value := range(timer.stats(a.Config.Percentiles)) {
+                labels := copyLabels(timer.labels)
+                labels[AggregationStatLabel] = stat
+                a.windows[name].With(labels).Set(value)
+                current[key + "\xff" + stat] = labels
+            }
+        }
+        a.expose(name, a.windows[name], current)
+        a.timers[name] = map[string]*windowTimer{}
+    }
+    for name, series := range(a.sets) {
+        current := map[string]prometheus.Labels{}
+        for key, set := range(series) {
+            a.setVecs[name].With(set.labels).Set(set.count())
+            current[key] = set.labels
+        }
+        a.expose(name, a.setVecs[name], current)
+        a.sets[name] = map[string]*windowSet{}
+    }
+}
+
+// function used to remove series exposed by the previous flush
+// that are not part of the current flush
+func(a *Aggregator) expose(name string, vec *prometheus.GaugeVec, current map[string]prometheus.Labels) {
+    for key, labels := range(a.exposed[name]) {
+        if _, ok := current[key]; !ok {
+            vec.Delete(labels)
+        }
+    }
+    a.exposed[name] = current
+}
+
+// function used to flush aggregates on every flush interval
+// until the done channel is closed
+func(a *Aggregator) Run(done <-chan struct{}) {
+    log.Info(fmt.Sprintf("aggregating updates over %d second windows", a.Config.FlushInterval))
+    ticker := time.NewTicker(time.Second * time.Duration(a.Config.FlushInterval))
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            a.Flush()
+            if a.OnFlush != nil {
+                a.OnFlush()
+            }
+        case <-done:
+            return
+        }
+    }
+}
+
+// function used to compute statistics of window. percentiles
+// use the nearest rank of the sorted samples
+func(t *windowTimer) stats(percentiles []float64) map[string]float64 {
+    sort.Float64s(t.samples)
+    stats := map[string]float64{"count": float64(t.count), "min": t.min, "max": t.max}
+    for _, percentile := range(percentiles) {
+        rank := int(math.Ceil(percentile / 100 * float64(len(t.samples)))) - 1
+        if rank < 0 {
+            rank = 0
+        }
+        stats["p" + strconv.FormatFloat(percentile, 'f', -1, 64)] = t.samples[rank]
+    }
+    return stats
+}
+
+// function used to push aggregates to the pushgateway and
+// write them to the textfile after a flush if configured
+func(server *HermesServer) pushAggregates() {
+    if server.pushgateway != nil {
+        if err := server.pushgateway.Push(); err != nil {
+            log.Error(fmt.Errorf("unable to push metrics to pushgateway: %v", err))
+        }
+    }
+    if server.Config.Textfile != nil {
+        if err := WriteTextfile(server.Config.Textfile.Path); err != nil {
+            log.Error(fmt.Errorf("unable to write textfile: %v", err))
+        }
+    }
+}
+
+// function used to copy labels
+func copyLabels(labels map[string]string) prometheus.Labels {
+    copied := make(prometheus.Labels, len(labels))
+    for key, value := range(labels) {
+        copied[key] = value
+    }
+    return copied
+}
@@ -0,0 +1,222 @@
+package hermes
+
+import (
+    "fmt"
+    "math"
+    "errors"
+    "testing"
+)
+
+// test statistics of aggregation windows. percentiles use the
+// nearest rank of the sorted samples
+func TestTimerStats(t *testing.T) {
+    tests := []struct {
+        name        string
+        samples     []float64
+        percentiles []float64
+        expected    map[string]float64
+    }{
+        {"ten samples", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []float64{50, 90, 99},
+            map[string]float64{"p50": 5, "p90": 9, "p99": 10, "min": 1, "max": 10, "count": 10}},
+        {"single sample", []float64{3}, []float64{50, 99},
+            map[string]float64{"p50": 3, "p99": 3, "min": 3, "max": 3, "count": 1}},
+        {"unsorted samples", []float64{5, 1, 3, 2, 4}, []float64{0.1, 50, 100},
+            map[string]float64{"p0.1": 1, "p50": 3, "p100": 5, "min": 1, "max": 5, "count": 5}},
+        {"quartiles", []float64{40, 10, 30, 20}, []float64{25, 75},
+            map[string]float64{"p25": 10, "p75": 30, "min": 10, "max": 40, "count": 4}},
+        {"negative samples", []float64{-2, -1, 0, 1}, []float64{50},
+            map[string]float64{"p50": -1, "min": -2, "max": 1, "count": 4}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            timer := &windowTimer{count: len(test.samples), min: test.samples[0], max: test.samples[0]}
+            for _, sample := range(test.samples) {
+                if sample < timer.min {
+                    timer.min = sample
+                }
+                if sample > timer.max {
+                    timer.max = sample
+                }
+                timer.samples = append(timer.samples, sample)
+            }
+            stats := timer.stats(test.percentiles)
+            if len(stats) != len(test.expected) {
+                t.Fatalf("expected %d statistics but got %v", len(test.expected), stats)
+            }
+            for stat, value := range(test.expected) {
+                if stats[stat] != value {
+                    t.Fatalf("expected %s to be %g but got %g", stat, value, stats[stat])
+                }
+            }
+        })
+    }
+}
+
+// define config of metrics used to test aggregation
+var aggregationTestConfig = HermesConfig{
+    Counters: []HermesCounter{
+        {MetricName: "requests_total", Labels: []string{"app"}},
+    },
+    Histograms: []HermesHistogram{
+        {MetricName: "request_duration", Labels: []string{"app"}},
+    },
+    Sets: []HermesSet{
+        {MetricName: "unique_users", Labels: []string{"app"}},
+    },
+}
+
+// test that aggregates are exposed on flush, and that series
+// without updates are removed on the next flush
+func TestAggregatorFlush(t *testing.T) {
+    config := aggregationTestConfig
+    config.Aggregation = &AggregationConfig{Percentiles: []float64{50, 99}}
+    registry := initTestMetrics(t, config)
+    aggregator, err := NewAggregator(config)
+    if err != nil {
+        t.Fatalf("unable to create aggregator: %v", err)
+    }
+    web := map[string]string{"app": "web"}
+    for i := 1; i <= 100; i++ {
+        aggregator.Increment("requests_total", web, 1)
+        aggregator.Observe("request_duration", web, float64(i))
+    }
+    for _, user := range([]string{"alice", "bob", "alice", "carol"}) {
+        if err := aggregator.AddToSet("unique_users", SetJSON{Labels: web, Value: user}); err != nil {
+            t.Fatalf("unable to add value to set: %v", err)
+        }
+    }
+    aggregator.Flush()
+
+    window := func(stat string) map[string]string {
+        return map[string]string{"app": "web", AggregationStatLabel: stat}
+    }
+    tests := []struct {
+        name     string
+        metric   string
+        labels   map[string]string
+        expected float64
+    }{
+        {"p50", "request_duration_window", window("p50"), 50},
+        {"p99", "request_duration_window", window("p99"), 99},
+        {"min", "request_duration_window", window("min"), 1},
+        {"max", "request_duration_window", window("max"), 100},
+        {"count", "request_duration_window", window("count"), 100},
+        {"unique values", "unique_users", web, 3},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            value, ok := seriesValue(t, registry, test.metric, test.labels)
+            if !ok || value != test.expected {
+                t.Fatalf("expected %s %v to be %g but got %g", test.metric, test.labels, test.expected, value)
+            }
+        })
+    }
+    if rate, _ := seriesValue(t, registry, "requests_total_rate", web); rate <= 0 {
+        t.Fatalf("expected positive rate but got %g", rate)
+    }
+
+    // series without updates are removed, while rates drop to 0
+    aggregator.Flush()
+    if _, ok := seriesValue(t, registry, "request_duration_window", window("p50")); ok {
+        t.Fatalf("expected stale window to be removed")
+    }
+    if _, ok := seriesValue(t, registry, "unique_users", web); ok {
+        t.Fatalf("expected stale set to be removed")
+    }
+    if rate, ok := seriesValue(t, registry, "requests_total_rate", web); !ok || rate != 0 {
+        t.Fatalf("expected rate without increments to be 0 but got %g", rate)
+    }
+}
+
+// test that only the configured metrics are aggregated and that
+// samples are capped at the max number of samples
+func TestAggregatorConfig(t *testing.T) {
+    config := aggregationTestConfig
+    config.Aggregation = &AggregationConfig{Metrics: []string{"request_duration"}, MaxSamples: 10}
+    registry := initTestMetrics(t, config)
+    aggregator, err := NewAggregator(config)
+    if err != nil {
+        t.Fatalf("unable to create aggregator: %v", err)
+    }
+    web := map[string]string{"app": "web"}
+    for i := 1; i <= 1000; i++ {
+        aggregator.Increment("requests_total", web, 1)
+        aggregator.Observe("request_duration", web, float64(i))
+    }
+    timer := aggregator.timers["request_duration"][seriesKey("request_duration", web)]
+    if len(timer.samples) != 10 || timer.count != 1000 {
+        t.Fatalf("expected 10 of 1000 samples but got %d of %d", len(timer.samples), timer.count)
+    }
+    aggregator.Flush()
+    if _, ok := seriesValue(t, registry, "requests_total_rate", web); ok {
+        t.Fatalf("expected counter not to be aggregated")
+    }
+    stats := map[string]float64{"min": 1, "max": 1000, "count": 1000}
+    for stat, expected := range(stats) {
+        labels := map[string]string{"app": "web", AggregationStatLabel: stat}
+        if value, _ := seriesValue(t, registry, "request_duration_window", labels); value != expected {
+            t.Fatalf("expected %s to be %g but got %g", stat, expected, value)
+        }
+    }
+}
+
+// test that sets are counted exactly up to the max number of
+// samples, and estimated within the error of the sketch beyond
+func TestAggregatorSetCount(t *testing.T) {
+    tests := []struct {
+        name      string
+        values    int
+        tolerance float64
+    }{
+        {"below max samples", 5, 0},
+        {"at max samples", 10, 0},
+        {"estimated", 1000, 0.03},
+        {"estimated large set", 100000, 0.03},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            config := aggregationTestConfig
+            config.Aggregation = &AggregationConfig{MaxSamples: 10}
+            registry := initTestMetrics(t, config)
+            aggregator, err := NewAggregator(config)
+            if err != nil {
+                t.Fatalf("unable to create aggregator: %v", err)
+            }
+            web := map[string]string{"app": "web"}
+            // add every value twice so that duplicates are ignored
+            for i := 0; i < 2 * test.values; i++ {
+                set := SetJSON{Labels: web, Value: fmt.Sprintf("user-%d", i % test.values)}
+                if err := aggregator.AddToSet("unique_users", set); err != nil {
+                    t.Fatalf("unable to add value to set: %v", err)
+                }
+            }
+            aggregator.Flush()
+            value, _ := seriesValue(t, registry, "unique_users", web)
+            if math.Abs(value - float64(test.values)) > test.tolerance * float64(test.values) {
+                t.Fatalf("expected %d unique values (tolerance %g) but got %g", test.values,
+                    test.tolerance, value)
+            }
+        })
+    }
+}
+
+// test that invalid aggregation configs are rejected
+func TestNewAggregatorInvalid(t *testing.T) {
+    tests := []struct {
+        name   string
+        config HermesConfig
+    }{
+        {"invalid percentile", HermesConfig{Aggregation: &AggregationConfig{Percentiles: []float64{0}}}},
+        {"percentile above 100", HermesConfig{Aggregation: &AggregationConfig{Percentiles: []float64{101}}}},
+        {"reserved stat label", HermesConfig{Aggregation: &AggregationConfig{},
+            Summaries: []HermesSummary{{MetricName: "latency", Labels: []string{AggregationStatLabel}}}}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            initTestMetrics(t, test.config)
+            if _, err := NewAggregator(test.config); !errors.Is(err, ErrInvalidAggregation) {
+                t.Fatalf("expected ErrInvalidAggregation but got %v", err)
+            }
+        })
+    }
+}
@@ -0,0 +1,107 @@
+package hermes
+
+import (
+    "sync"
+    "time"
+    "errors"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+)
+
+var (
+    // define default max age (in seconds) of signed packets
+    DefaultAuthMaxSkew = 30
+
+    ErrMissingSignature = errors.New("Packet signature missing")
+    ErrUnknownKey       = errors.New("Unknown packet signing key")
+    ErrInvalidSignature = errors.New("Invalid packet signature")
+    ErrStalePacket      = errors.New("Stale packet timestamp")
+    ErrReplayedPacket   = errors.New("Replayed packet")
+)
+
+// struct used to authenticate packets with HMAC signatures.
+// signatures of all accepted packets are cached until they
+// expire to reject replayed packets
+type Authenticator struct {
+    Config  AuthConfig
+
+    keys    map[string]string
+    mu      sync.Mutex
+    seen    map[string]time.Time
+    pruned  time.Time
+}
+
+// function used to create new authenticator from auth config
+func NewAuthenticator(config AuthConfig) *Authenticator {
+    if config.MaxSkew <= 0 {
+        config.MaxSkew = DefaultAuthMaxSkew
+    }
+    keys := map[string]string{}
+    for _, key := range(config.Keys) {
+        keys[key.KeyID] = key.Secret
+    }
+    return &Authenticator{Config: config, keys: keys, seen: map[string]time.Time{}}
+}
+
+// function used to authenticate a decoded packet. the ID of the key
+// used to sign the packet is returned if the signature is valid.
+// an empty key ID is returned for unsigned packets if signatures
+// are not required. all failures are counted in the self metrics
+func(a *Authenticator) Authenticate(signed HermesPayload) (string, error) {
+    keyID, reason, err := a.authenticate(signed)
+    if err != nil {
+        AuthFailures.WithLabelValues(reason).Inc()
+    }
+    return keyID, err
+}
+
+// function used to verify packet signature. the reason for
+// any failures is returned for the self metrics
+func(a *Authenticator) authenticate(signed HermesPayload) (string, string, error) {
+    if len(signed.Signature) == 0 {
+        if a.Config.Required {
+            return "", "missing_signature", ErrMissingSignature
+        }
+        return "", "", nil
+    }
+    secret, ok := a.keys[signed.KeyID]
+    if !ok {
+        return "", "unknown_key", ErrUnknownKey
+    }
+    if !utils.VerifyPayload(secret, signed.Signature, signed.MetricName, signed.Timestamp,
+        signed.Nonce, EncodeDefinition(signed.Definition), signed.Payload) {
+        return "", "invalid_signature", ErrInvalidSignature
+    }
+    // reject packets that are older (or newer) than the max skew
+    now := time.Now()
+    sent := time.Unix(0, signed.Timestamp * int64(time.Millisecond))
+    maxSkew := time.Second * time.Duration(a.Config.MaxSkew)
+    if sent.Before(now.Add(-maxSkew)) || sent.After(now.Add(maxSkew)) {
+        return "", "stale", ErrStalePacket
+    }
+    if !a.markSeen(signed.Signature, sent.Add(maxSkew), now) {
+        return "", "replayed", ErrReplayedPacket
+    }
+    return signed.KeyID, "", nil
+}
+
+// function used to mark a signature as seen until it expires.
+// false is returned if the signature has already been seen
+func(a *Authenticator) markSeen(signature string, expires, now time.Time) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    // prune expired signatures at most once a second
+    if now.Sub(a.pruned) > time.Second {
+        for seen, expiry := range(a.seen) {
+            if expiry.Before(now) {
+                delete(a.seen, seen)
+            }
+        }
+        a.pruned = now
+    }
+    if _, ok := a.seen[signature]; ok {
+        return false
+    }
+    a.seen[signature] = expires
+    return true
+}
@@ -0,0 +1,117 @@
+package hermes
+
+import (
+    "time"
+    "testing"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+)
+
+// function used to generate packet signed with the given secret
+func signedPayload(secret, keyID, nonce string, sent time.Time) HermesPayload {
+    payload := HermesPayload{MetricName: "requests_total", Payload: []byte(`{"labels": {}}`),
+        KeyID: keyID, Timestamp: sent.UnixNano() / int64(time.Millisecond), Nonce: nonce}
+    payload.Signature = utils.SignPayload(secret, payload.MetricName, payload.Timestamp,
+        payload.Nonce, nil, payload.Payload)
+    return payload
+}
+
+// test verification of packet signatures
+func TestAuthenticate(t *testing.T) {
+    initTestMetrics(t, HermesConfig{})
+    config := AuthConfig{Keys: []AuthKey{{KeyID: "ci", Secret: "s3cr3t"}}, MaxSkew: 30}
+    now := time.Now()
+    tests := []struct {
+        name     string
+        required bool
+        payload  func() HermesPayload
+        keyID    string
+        err      error
+    }{
+        {"valid signature", true, func() HermesPayload {
+            return signedPayload("s3cr3t", "ci", "a", now)
+        }, "ci", nil},
+        {"unsigned packet", false, func() HermesPayload {
+            return HermesPayload{MetricName: "requests_total"}
+        }, "", nil},
+        {"missing signature", true, func() HermesPayload {
+            return HermesPayload{MetricName: "requests_total"}
+        }, "", ErrMissingSignature},
+        {"unknown key", true, func() HermesPayload {
+            return signedPayload("s3cr3t", "other", "a", now)
+        }, "", ErrUnknownKey},
+        {"wrong secret", true, func() HermesPayload {
+            return signedPayload("wrong", "ci", "a", now)
+        }, "", ErrInvalidSignature},
+        {"tampered payload", true, func() HermesPayload {
+            payload := signedPayload("s3cr3t", "ci", "a", now)
+            payload.Payload = []byte(`{"labels": {"app": "web"}}`)
+            return payload
+        }, "", ErrInvalidSignature},
+        {"tampered metric name", true, func() HermesPayload {
+            payload := signedPayload("s3cr3t", "ci", "a", now)
+            payload.MetricName = "errors_total"
+            return payload
+        }, "", ErrInvalidSignature},
+        {"signed definition", true, func() HermesPayload {
+            payload := signedPayload("s3cr3t", "ci", "a", now)
+            payload.Definition = &MetricDefinition{Type: "counter", Labels: []string{"app"}}
+            payload.Signature = utils.SignPayload("s3cr3t", payload.MetricName, payload.Timestamp,
+                payload.Nonce, EncodeDefinition(payload.Definition), payload.Payload)
+            return payload
+        }, "ci", nil},
+        {"added definition", true, func() HermesPayload {
+            payload := signedPayload("s3cr3t", "ci", "a", now)
+            payload.Definition = &MetricDefinition{Type: "counter", Labels: []string{"app"}}
+            return payload
+        }, "", ErrInvalidSignature},
+        {"tampered definition", true, func() HermesPayload {
+            payload := signedPayload("s3cr3t", "ci", "a", now)
+            payload.Definition = &MetricDefinition{Type: "counter", Labels: []string{"app"}}
+            payload.Signature = utils.SignPayload("s3cr3t", payload.MetricName, payload.Timestamp,
+                payload.Nonce, EncodeDefinition(payload.Definition), payload.Payload)
+            payload.Definition.Labels = []string{"app", "user_id"}
+            return payload
+        }, "", ErrInvalidSignature},
+        {"stale timestamp", true, func() HermesPayload {
+            return signedPayload("s3cr3t", "ci", "a", now.Add(-time.Minute))
+        }, "", ErrStalePacket},
+        {"future timestamp", true, func() HermesPayload {
+            return signedPayload("s3cr3t", "ci", "a", now.Add(time.Minute))
+        }, "", ErrStalePacket},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            config.Required = test.required
+            keyID, err := NewAuthenticator(config).Authenticate(test.payload())
+            if err != test.err {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+            if keyID != test.keyID {
+                t.Fatalf("expected key ID '%s' but got '%s'", test.keyID, keyID)
+            }
+        })
+    }
+}
+
+// test that replayed packets are rejected while packets
+// with a different nonce are accepted
+func TestAuthenticateReplay(t *testing.T) {
+    initTestMetrics(t, HermesConfig{})
+    authenticator := NewAuthenticator(AuthConfig{Keys: []AuthKey{{KeyID: "ci", Secret: "s3cr3t"}}})
+    now := time.Now()
+    tests := []struct {
+        name    string
+        payload HermesPayload
+        err     error
+    }{
+        {"first packet", signedPayload("s3cr3t", "ci", "a", now), nil},
+        {"replayed packet", signedPayload("s3cr3t", "ci", "a", now), ErrReplayedPacket},
+        {"new nonce", signedPayload("s3cr3t", "ci", "b", now), nil},
+    }
+    for _, test := range(tests) {
+        if _, err := authenticator.Authenticate(test.payload); err != test.err {
+            t.Fatalf("%s: expected error %v but got %v", test.name, test.err, err)
+        }
+    }
+}
@@ -0,0 +1,311 @@
+package hermes
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "bufio"
+    "bytes"
+    "errors"
+    "encoding/binary"
+
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define header written at the start of all capture files. the
+    // last byte of the header is the version of the capture format
+    CaptureHeader = []byte("HCAP\x01")
+    // define interval used to flush captured packets to disk
+    CaptureFlushInterval = time.Second
+    // define default max size (in bytes) and max number of rotated
+    // files of capture files of the server
+    DefaultCaptureMaxSize  = 100 * 1024 * 1024
+    DefaultCaptureMaxFiles = 3
+
+    ErrInvalidCapture = errors.New("Invalid hermes capture file")
+    ErrCaptureClosed  = errors.New("Capture file closed")
+)
+
+// struct used to define a single captured datagram
+type CapturedPacket struct {
+    Timestamp time.Time
+    Source    string
+    Packet    []byte
+}
+
+// struct used to record incoming datagrams to a capture file. each
+// record consists of the timestamp (unix nanoseconds, big endian),
+// followed by the uvarint length prefixed source address and packet.
+// records are buffered, and flushed periodically and on close. if a
+// max size is set, the capture file is rotated once it exceeds the
+// max size, keeping up to MaxFiles rotated files
+type CaptureWriter struct {
+    Path     string
+    MaxSize  int64
+    MaxFiles int
+
+    mu     sync.Mutex
+    file   *os.File
+    writer *bufio.Writer
+    size   int64
+}
+
+// function used to create new capture file. any existing
+// file at the given path is overwritten
+func NewCaptureWriter(path string) (*CaptureWriter, error) {
+    c := &CaptureWriter{Path: path}
+    if err := c.open(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// function used to open capture file for appending. the file is
+// created if it does not exist, and must be a valid capture file
+// otherwise, so that existing records are never overwritten
+func AppendCaptureWriter(path string) (*CaptureWriter, error) {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    writer := bufio.NewWriterSize(file, 64 * 1024)
+    if info.Size() == 0 {
+        if _, err := writer.Write(CaptureHeader); err != nil {
+            file.Close()
+            return nil, err
+        }
+        return &CaptureWriter{Path: path, file: file, writer: writer, size: int64(len(CaptureHeader))}, nil
+    }
+    header := make([]byte, len(CaptureHeader))
+    if _, err := file.ReadAt(header, 0); err != nil || !bytes.Equal(header, CaptureHeader) {
+        file.Close()
+        return nil, ErrInvalidCapture
+    }
+    return &CaptureWriter{Path: path, file: file, writer: writer, size: info.Size()}, nil
+}
+
+// function used to create new capture file that is rotated once
+// it exceeds the given max size (in bytes). any existing file at
+// the given path is rotated to <path>.1, so that captures of
+// previous runs are kept
+func NewRotatingCaptureWriter(path string, maxSize, maxFiles int) (*CaptureWriter, error) {
+    if maxSize <= 0 {
+        maxSize = DefaultCaptureMaxSize
+    }
+    if maxFiles <= 0 {
+        maxFiles = DefaultCaptureMaxFiles
+    }
+    c := &CaptureWriter{Path: path, MaxSize: int64(maxSize), MaxFiles: maxFiles}
+    // note that capture files without any records are overwritten
+    if info, err := os.Stat(path); err == nil && info.Size() > int64(len(CaptureHeader)) {
+        if err := c.shift(); err != nil {
+            return nil, err
+        }
+    }
+    if err := c.open(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// function used to create capture file and write the header
+func(c *CaptureWriter) open() error {
+    file, err := os.Create(c.Path)
+    if err != nil {
+        return err
+    }
+    writer := bufio.NewWriterSize(file, 64 * 1024)
+    if _, err := writer.Write(CaptureHeader); err != nil {
+        file.Close()
+        return err
+    }
+    c.file, c.writer, c.size = file, writer, int64(len(CaptureHeader))
+    return nil
+}
+
+// function used to rotate capture files. the current file is only
+// closed once the new file has been opened, so that packets are
+// still captured to the current file if the rotation fails
+func(c *CaptureWriter) rotate() error {
+    if err := c.writer.Flush(); err != nil {
+        return err
+    }
+    if err := c.shift(); err != nil {
+        return err
+    }
+    current := c.file
+    if err := c.open(); err != nil {
+        return err
+    }
+    return current.Close()
+}
+
+// function used to shift capture files by one. the oldest file
+// is removed and the capture file is moved to <path>.1
+func(c *CaptureWriter) shift() error {
+    os.Remove(fmt.Sprintf("%s.%d", c.Path, c.MaxFiles))
+    for i := c.MaxFiles - 1; i > 0; i-- {
+        os.Rename(fmt.Sprintf("%s.%d", c.Path, i), fmt.Sprintf("%s.%d", c.Path, i + 1))
+    }
+    return os.Rename(c.Path, c.Path + ".1")
+}
+
+// function used to record datagram received from the given source
+func(c *CaptureWriter) Write(timestamp time.Time, source net.Addr, packet []byte) error {
+    var address string
+    if source != nil {
+        address = source.String()
+    }
+    record := make([]byte, 8, 8 + 2 * binary.MaxVarintLen64 + len(address) + len(packet))
+    binary.BigEndian.PutUint64(record, uint64(timestamp.UnixNano()))
+    record = appendUvarint(record, uint64(len(address)))
+    record = append(record, address...)
+    record = appendUvarint(record, uint64(len(packet)))
+    record = append(record, packet...)
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.writer == nil {
+        return ErrCaptureClosed
+    }
+    // note that packets are still captured to the current file if
+    // the file cannot be rotated, so that capturing is never disabled
+    if c.MaxSize > 0 && c.size > int64(len(CaptureHeader)) && c.size + int64(len(record)) > c.MaxSize {
+        if err := c.rotate(); err != nil {
+            log.Error(fmt.Errorf("unable to rotate capture file %s: %v", c.Path, err))
+        }
+    }
+    n, err := c.writer.Write(record)
+    c.size += int64(n)
+    return err
+}
+
+// function used to flush buffered records to disk
+func(c *CaptureWriter) Flush() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.writer == nil {
+        return ErrCaptureClosed
+    }
+    return c.writer.Flush()
+}
+
+// function used to periodically flush buffered records to
+// disk until the done channel is closed
+func(c *CaptureWriter) FlushPeriodically(done chan struct{}) {
+    ticker := time.NewTicker(CaptureFlushInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-done:
+            return
+        case <-ticker.C:
+            if err := c.Flush(); err != nil && err != ErrCaptureClosed {
+                log.Error(fmt.Errorf("unable to flush capture file: %v", err))
+            }
+        }
+    }
+}
+
+// function used to flush and close capture file
+func(c *CaptureWriter) Close() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.writer == nil {
+        return nil
+    }
+    err := c.writer.Flush()
+    if closeErr := c.file.Close(); err == nil {
+        err = closeErr
+    }
+    c.writer = nil
+    return err
+}
+
+// function used to record datagram to the capture file if configured
+func(server *HermesServer) capturePacket(packet []byte, remoteAddr net.Addr) {
+    if server.capture == nil {
+        return
+    }
+    if err := server.capture.Write(time.Now(), remoteAddr, packet); err != nil && err != ErrCaptureClosed {
+        log.Error(fmt.Errorf("unable to capture packet: %v", err))
+    }
+}
+
+// struct used to read datagrams from a capture file
+type CaptureReader struct {
+    reader *bufio.Reader
+}
+
+// function used to create new capture reader. the header
+// of the capture file is validated before returning
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+    reader := bufio.NewReader(r)
+    header := make([]byte, len(CaptureHeader))
+    if _, err := io.ReadFull(reader, header); err != nil || !bytes.Equal(header, CaptureHeader) {
+        return nil, ErrInvalidCapture
+    }
+    return &CaptureReader{reader: reader}, nil
+}
+
+// function used to read next datagram from capture file.
+// io.EOF is returned once all datagrams have been read
+func(c *CaptureReader) Next() (CapturedPacket, error) {
+    var captured CapturedPacket
+    timestamp := make([]byte, 8)
+    if _, err := io.ReadFull(c.reader, timestamp); err != nil {
+        if err == io.ErrUnexpectedEOF {
+            return captured, ErrInvalidCapture
+        }
+        return captured, err
+    }
+    captured.Timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(timestamp)))
+    source, err := c.readField(MaxPacketSizeLimit)
+    if err != nil {
+        return captured, err
+    }
+    captured.Source = string(source)
+    if captured.Packet, err = c.readField(maxCapturedPacketSize()); err != nil {
+        return captured, err
+    }
+    return captured, nil
+}
+
+// function used to retrieve max size of captured packets. note
+// that packets received over stream listeners may exceed the
+// max size of datagrams
+func maxCapturedPacketSize() int {
+    if MaxStreamPacketSize > MaxPacketSizeLimit + 1 {
+        return MaxStreamPacketSize
+    }
+    return MaxPacketSizeLimit + 1
+}
+
+// function used to read length prefixed field of a record
+func(c *CaptureReader) readField(maxLength int) ([]byte, error) {
+    length, err := binary.ReadUvarint(c.reader)
+    if err != nil || length > uint64(maxLength) {
+        return nil, ErrInvalidCapture
+    }
+    field := make([]byte, length)
+    if _, err := io.ReadFull(c.reader, field); err != nil {
+        return nil, ErrInvalidCapture
+    }
+    return field, nil
+}
+
+// function used to append uvarint to byte slice
+func appendUvarint(b []byte, value uint64) []byte {
+    var buffer [binary.MaxVarintLen64]byte
+    n := binary.PutUvarint(buffer[:], value)
+    return append(b, buffer[:n]...)
+}
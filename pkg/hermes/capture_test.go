@@ -0,0 +1,160 @@
+package hermes
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "time"
+    "bytes"
+    "testing"
+    "path/filepath"
+)
+
+// function used to read all packets of a capture file
+func readCapture(t *testing.T, path string) [][]byte {
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatalf("unable to open capture file: %v", err)
+    }
+    defer file.Close()
+    reader, err := NewCaptureReader(file)
+    if err != nil {
+        t.Fatalf("unable to read capture file: %v", err)
+    }
+    var packets [][]byte
+    for {
+        captured, err := reader.Next()
+        if err == io.EOF {
+            return packets
+        }
+        if err != nil {
+            t.Fatalf("unable to read captured packet: %v", err)
+        }
+        packets = append(packets, captured.Packet)
+    }
+}
+
+// test that capture files are rotated once they exceed the max size
+func TestCaptureRotation(t *testing.T) {
+    packet := bytes.Repeat([]byte("a"), 100)
+    tests := []struct {
+        name     string
+        maxSize  int
+        maxFiles int
+        packets  int
+        files    []int
+    }{
+        {"below max size", 10000, 2, 5, []int{5}},
+        {"rotated", 500, 2, 8, []int{4, 4}},
+        {"oldest files removed", 250, 2, 5, []int{1, 2, 2}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            path := filepath.Join(tempDir(t), "capture.bin")
+            capture, err := NewRotatingCaptureWriter(path, test.maxSize, test.maxFiles)
+            if err != nil {
+                t.Fatalf("unable to create capture file: %v", err)
+            }
+            for i := 0; i < test.packets; i++ {
+                if err := capture.Write(time.Now(), nil, packet); err != nil {
+                    t.Fatalf("unable to capture packet: %v", err)
+                }
+            }
+            if err := capture.Close(); err != nil {
+                t.Fatalf("unable to close capture file: %v", err)
+            }
+            for i, expected := range(test.files) {
+                name := path
+                if i > 0 {
+                    name = fmt.Sprintf("%s.%d", path, i)
+                }
+                if packets := readCapture(t, name); len(packets) != expected {
+                    t.Fatalf("expected %d packets in %s but got %d", expected, name, len(packets))
+                }
+            }
+            if _, err := os.Stat(fmt.Sprintf("%s.%d", path, len(test.files))); !os.IsNotExist(err) {
+                t.Fatalf("expected at most %d rotated files", len(test.files) - 1)
+            }
+        })
+    }
+}
+
+// function used to capture the given number of packets to a new
+// rotating capture writer
+func capturePackets(t *testing.T, path string, maxFiles, packets int) {
+    capture, err := NewRotatingCaptureWriter(path, 0, maxFiles)
+    if err != nil {
+        t.Fatalf("unable to create capture file: %v", err)
+    }
+    for i := 0; i < packets; i++ {
+        if err := capture.Write(time.Now(), nil, []byte("packet")); err != nil {
+            t.Fatalf("unable to capture packet: %v", err)
+        }
+    }
+    if err := capture.Close(); err != nil {
+        t.Fatalf("unable to close capture file: %v", err)
+    }
+}
+
+// test that existing capture files are rotated on startup instead
+// of being overwritten
+func TestCaptureRestart(t *testing.T) {
+    tests := []struct {
+        name     string
+        maxFiles int
+        runs     []int
+        files    []int
+    }{
+        {"single run", 2, []int{3}, []int{3}},
+        {"restarted", 2, []int{3, 1}, []int{1, 3}},
+        {"restarted twice", 3, []int{3, 2, 1}, []int{1, 2, 3}},
+        {"oldest files removed", 1, []int{3, 2, 1}, []int{1, 2}},
+        {"empty run not rotated", 2, []int{3, 0, 1}, []int{1, 3}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            path := filepath.Join(tempDir(t), "capture.bin")
+            for _, packets := range(test.runs) {
+                capturePackets(t, path, test.maxFiles, packets)
+            }
+            for i, expected := range(test.files) {
+                name := path
+                if i > 0 {
+                    name = fmt.Sprintf("%s.%d", path, i)
+                }
+                if packets := readCapture(t, name); len(packets) != expected {
+                    t.Fatalf("expected %d packets in %s but got %d", expected, name, len(packets))
+                }
+            }
+            if _, err := os.Stat(fmt.Sprintf("%s.%d", path, len(test.files))); !os.IsNotExist(err) {
+                t.Fatalf("expected at most %d rotated files", len(test.files) - 1)
+            }
+        })
+    }
+}
+
+// test that packets are still captured to the current file
+// if the file cannot be rotated
+func TestCaptureRotationFailure(t *testing.T) {
+    path := filepath.Join(tempDir(t), "capture.bin")
+    capture, err := NewRotatingCaptureWriter(path, 100, 1)
+    if err != nil {
+        t.Fatalf("unable to create capture file: %v", err)
+    }
+    // block rotation with a non-empty directory at the rotated path
+    if err := os.MkdirAll(filepath.Join(path + ".1", "blocked"), 0755); err != nil {
+        t.Fatalf("unable to create directory: %v", err)
+    }
+    packet := bytes.Repeat([]byte("a"), 60)
+    for i := 0; i < 3; i++ {
+        if err := capture.Write(time.Now(), nil, packet); err != nil {
+            t.Fatalf("expected packet to be captured despite failed rotation: %v", err)
+        }
+    }
+    if err := capture.Close(); err != nil {
+        t.Fatalf("unable to close capture file: %v", err)
+    }
+    if packets := readCapture(t, path); len(packets) != 3 {
+        t.Fatalf("expected 3 packets in current file but got %d", len(packets))
+    }
+}
@@ -0,0 +1,150 @@
+package hermes
+
+import (
+    "encoding/json"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+// function used to decode raw packet into hermes payload. packets
+// are decoded from the binary protocol if they start with the binary
+// magic byte, and from JSON otherwise. the payload itself is kept in
+// its encoded form until the metric type is known
+func DecodePacket(packet []byte) (HermesPayload, error) {
+    var payload HermesPayload
+    if !protocol.IsBinary(packet) {
+        err := json.Unmarshal(packet, &payload)
+        return payload, err
+    }
+    binary, err := protocol.UnmarshalPacket(packet)
+    if err != nil {
+        return payload, err
+    }
+    return HermesPayload{
+        MetricName: binary.MetricName,
+        Payload: binary.Payload,
+        KeyID: binary.KeyID,
+        Timestamp: binary.Timestamp,
+        Nonce: binary.Nonce,
+        Signature: binary.Signature,
+        Definition: decodeDefinition(binary.Definition),
+        Binary: true,
+    }, nil
+}
+
+// function used to convert binary metric definition
+func decodeDefinition(definition *protocol.Definition) *MetricDefinition {
+    if definition == nil {
+        return nil
+    }
+    return &MetricDefinition{
+        Type: definition.Type,
+        Description: definition.Description,
+        Labels: definition.Labels,
+        Buckets: definition.Buckets,
+    }
+}
+
+// function used to retrieve raw JSON payload of a packet. packets
+// without a payload are decoded as an empty payload, i.e. as
+// a counter increment without labels
+func jsonPayload(payload HermesPayload) []byte {
+    if len(payload.Payload) == 0 {
+        return []byte("{}")
+    }
+    return payload.Payload
+}
+
+// function used to encode metric definition with the binary
+// protocol. the encoded definition is covered by the signature
+// of signed packets, regardless of the encoding of the packet
+func EncodeDefinition(definition *MetricDefinition) []byte {
+    if definition == nil {
+        return nil
+    }
+    return protocol.Definition{
+        Type: definition.Type,
+        Description: definition.Description,
+        Labels: definition.Labels,
+        Buckets: definition.Buckets,
+    }.Marshal()
+}
+
+// function used to decode counter payload
+func DecodeCounter(payload HermesPayload) (CounterJSON, error) {
+    var counter CounterJSON
+    if !payload.Binary {
+        err := json.Unmarshal(jsonPayload(payload), &counter)
+        return counter, err
+    }
+    binary, err := protocol.UnmarshalCounter(payload.Payload)
+    if err != nil {
+        return counter, err
+    }
+    return CounterJSON{Labels: binary.Labels, Exemplar: decodeExemplar(binary.Exemplar)}, nil
+}
+
+// function used to decode gauge payload
+func DecodeGauge(payload HermesPayload) (GaugeJSON, error) {
+    var gauge GaugeJSON
+    if !payload.Binary {
+        err := json.Unmarshal(jsonPayload(payload), &gauge)
+        return gauge, err
+    }
+    binary, err := protocol.UnmarshalGauge(payload.Payload)
+    if err != nil {
+        return gauge, err
+    }
+    return GaugeJSON{Labels: binary.Labels, Value: binary.Value, Operation: binary.Operation}, nil
+}
+
+// function used to decode histogram payload
+func DecodeHistogram(payload HermesPayload) (HistogramJSON, error) {
+    var histogram HistogramJSON
+    if !payload.Binary {
+        err := json.Unmarshal(jsonPayload(payload), &histogram)
+        return histogram, err
+    }
+    binary, err := protocol.UnmarshalHistogram(payload.Payload)
+    if err != nil {
+        return histogram, err
+    }
+    return HistogramJSON{Labels: binary.Labels, Observation: binary.Observation,
+        Exemplar: decodeExemplar(binary.Exemplar)}, nil
+}
+
+// function used to decode summary payload
+func DecodeSummary(payload HermesPayload) (SummaryJSON, error) {
+    var summary SummaryJSON
+    if !payload.Binary {
+        err := json.Unmarshal(jsonPayload(payload), &summary)
+        return summary, err
+    }
+    binary, err := protocol.UnmarshalSummary(payload.Payload)
+    if err != nil {
+        return summary, err
+    }
+    return SummaryJSON{Labels: binary.Labels, Observation: binary.Observation}, nil
+}
+
+// function used to decode set payload
+func DecodeSet(payload HermesPayload) (SetJSON, error) {
+    var set SetJSON
+    if !payload.Binary {
+        err := json.Unmarshal(jsonPayload(payload), &set)
+        return set, err
+    }
+    binary, err := protocol.UnmarshalSet(payload.Payload)
+    if err != nil {
+        return set, err
+    }
+    return SetJSON{Labels: binary.Labels, Value: binary.Value}, nil
+}
+
+// function used to convert binary exemplar
+func decodeExemplar(exemplar *protocol.Exemplar) *ExemplarJSON {
+    if exemplar == nil {
+        return nil
+    }
+    return &ExemplarJSON{TraceID: exemplar.TraceID, SpanID: exemplar.SpanID, Value: exemplar.Value}
+}
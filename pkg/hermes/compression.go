@@ -0,0 +1,165 @@
+package hermes
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "sync"
+    "bytes"
+    "bufio"
+    "errors"
+    "io/ioutil"
+    "compress/gzip"
+
+    "github.com/golang/snappy"
+    "github.com/klauspost/compress/zstd"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define default max size (in bytes) of decompressed datagrams.
+    // datagrams that decompress to larger sizes are dropped
+    DefaultMaxDecompressedSize = 1024 * 1024
+
+    ErrDecompressedTooLarge = errors.New("Decompressed packet exceeds max decompressed size")
+
+    // define magic bytes used to detect compressed datagrams
+    gzipMagic   = []byte{0x1f, 0x8b}
+    zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+    snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+    // define pools of zstd decoders, keyed by the max decompressed size
+    zstdDecoders sync.Map
+)
+
+// define compression algorithms supported for datagrams
+const (
+    CompressionGzip   = "gzip"
+    CompressionZstd   = "zstd"
+    CompressionSnappy = "snappy"
+)
+
+// function used to detect the compression algorithm of a packet
+// from its magic bytes. an empty string is returned if the packet
+// is not compressed
+func CompressionAlgorithm(packet []byte) string {
+    switch {
+    case bytes.HasPrefix(packet, gzipMagic):
+        return CompressionGzip
+    case bytes.HasPrefix(packet, zstdMagic):
+        return CompressionZstd
+    case bytes.HasPrefix(packet, snappyMagic):
+        return CompressionSnappy
+    }
+    return ""
+}
+
+// function used to decompress packet with the given algorithm.
+// at most limit bytes are decompressed, and ErrDecompressedTooLarge
+// is returned if the packet decompresses to a larger size
+func Decompress(packet []byte, algorithm string, limit int) ([]byte, error) {
+    var reader io.Reader
+    switch algorithm {
+    case CompressionGzip:
+        gz, err := gzip.NewReader(bytes.NewReader(packet))
+        if err != nil {
+            return nil, err
+        }
+        defer gz.Close()
+        reader = gz
+    case CompressionZstd:
+        decoder, pool, err := zstdDecoder(limit)
+        if err != nil {
+            return nil, fmt.Errorf("unable to create zstd decoder: %v", err)
+        }
+        defer pool.Put(decoder)
+        if err := decoder.Reset(bytes.NewReader(packet)); err != nil {
+            return nil, err
+        }
+        reader = decoder
+    case CompressionSnappy:
+        reader = snappy.NewReader(bytes.NewReader(packet))
+    default:
+        return nil, fmt.Errorf("unsupported compression algorithm '%s'", algorithm)
+    }
+    // read one more byte than allowed to detect oversized packets
+    data, err := ioutil.ReadAll(io.LimitReader(reader, int64(limit) + 1))
+    if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+        return nil, ErrDecompressedTooLarge
+    }
+    if err != nil {
+        return nil, err
+    }
+    if len(data) > limit {
+        return nil, ErrDecompressedTooLarge
+    }
+    return data, nil
+}
+
+// function used to retrieve zstd decoder from the pool of decoders
+// of the given max decompressed size. the memory of decoders is
+// limited to the max size (plus the byte used to detect oversized
+// packets), which also caps the window size of frames, so that
+// frames declaring larger windows are rejected before decoding
+func zstdDecoder(limit int) (*zstd.Decoder, *sync.Pool, error) {
+    value, ok := zstdDecoders.Load(limit)
+    if !ok {
+        value, _ = zstdDecoders.LoadOrStore(limit, &sync.Pool{})
+    }
+    pool := value.(*sync.Pool)
+    if decoder, ok := pool.Get().(*zstd.Decoder); ok {
+        return decoder, pool, nil
+    }
+    decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1),
+        zstd.WithDecoderMaxMemory(uint64(limit) + 1))
+    return decoder, pool, err
+}
+
+// function used to retrieve max size of decompressed datagrams
+func(server *HermesServer) maxDecompressedSize() int {
+    if server.MaxDecompressedSize <= 0 {
+        return DefaultMaxDecompressedSize
+    }
+    return server.MaxDecompressedSize
+}
+
+// function used to process compressed datagram. compressed datagrams
+// contain a batch of packets, framed in the same way as packets sent
+// over stream listeners (i.e. newline delimited JSON packets and/or
+// length prefixed binary packets). each packet in the batch is then
+// run through the regular processing pipeline
+func(server *HermesServer) processCompressed(packet []byte, algorithm string, remoteAddr net.Addr) {
+    data, err := Decompress(packet, algorithm, server.maxDecompressedSize())
+    if err != nil {
+        log.Warn(fmt.Sprintf("dropping %s compressed packet from %v: %v", algorithm, remoteAddr, err))
+        DecompressionFailures.WithLabelValues(algorithm).Inc()
+        server.reject(packet, nil, remoteAddr, ReasonDecompression, err)
+        return
+    }
+    packets, err := SplitBatch(data)
+    for _, batched := range(packets) {
+        server.processPacket(batched, remoteAddr)
+    }
+    if err != nil {
+        log.Error(fmt.Errorf("unable to read batched packet from %v: %v", remoteAddr, err))
+    }
+}
+
+// function used to split decompressed batch into packets. packets
+// read before any framing error are returned along with the error
+func SplitBatch(data []byte) ([][]byte, error) {
+    packets := [][]byte{}
+    reader := bufio.NewReader(bytes.NewReader(data))
+    for {
+        packet, err := readStreamPacket(reader)
+        if err != nil {
+            if err == io.EOF {
+                return packets, nil
+            }
+            return packets, err
+        }
+        if len(packet) > 0 {
+            packets = append(packets, packet)
+        }
+    }
+}
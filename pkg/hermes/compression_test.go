@@ -0,0 +1,164 @@
+package hermes
+
+import (
+    "bytes"
+    "testing"
+    "compress/gzip"
+
+    "github.com/golang/snappy"
+    "github.com/klauspost/compress/zstd"
+)
+
+// function used to compress data with the given algorithm
+func compressTestData(t *testing.T, data []byte, algorithm string) []byte {
+    var buffer bytes.Buffer
+    switch algorithm {
+    case CompressionGzip:
+        writer := gzip.NewWriter(&buffer)
+        writer.Write(data)
+        writer.Close()
+    case CompressionZstd:
+        writer, err := zstd.NewWriter(&buffer)
+        if err != nil {
+            t.Fatal(err)
+        }
+        writer.Write(data)
+        writer.Close()
+    case CompressionSnappy:
+        writer := snappy.NewBufferedWriter(&buffer)
+        writer.Write(data)
+        writer.Close()
+    }
+    return buffer.Bytes()
+}
+
+// test that decompressed packets are capped at the size limit
+func TestDecompress(t *testing.T) {
+    data := bytes.Repeat([]byte(`{"metric_name": "requests_total"}` + "\n"), 1000)
+    tests := []struct {
+        name  string
+        limit int
+        err   error
+    }{
+        {"below limit", len(data) + 1, nil},
+        {"at limit", len(data), nil},
+        {"above limit", len(data) - 1, ErrDecompressedTooLarge},
+        {"far above limit", 64, ErrDecompressedTooLarge},
+    }
+    for _, algorithm := range([]string{CompressionGzip, CompressionZstd, CompressionSnappy}) {
+        compressed := compressTestData(t, data, algorithm)
+        if detected := CompressionAlgorithm(compressed); detected != algorithm {
+            t.Fatalf("expected %s packet to be detected but got '%s'", algorithm, detected)
+        }
+        for _, test := range(tests) {
+            t.Run(algorithm + "/" + test.name, func(t *testing.T) {
+                decompressed, err := Decompress(compressed, algorithm, test.limit)
+                if err != test.err {
+                    t.Fatalf("expected error %v but got %v", test.err, err)
+                }
+                if err == nil && !bytes.Equal(decompressed, data) {
+                    t.Fatalf("decompressed data does not match original data")
+                }
+            })
+        }
+    }
+}
+
+// test that corrupted and uncompressed packets are handled
+func TestDecompressInvalid(t *testing.T) {
+    if algorithm := CompressionAlgorithm([]byte(`{"metric_name": "requests_total"}`)); algorithm != "" {
+        t.Fatalf("expected JSON packet to be uncompressed but got '%s'", algorithm)
+    }
+    for _, algorithm := range([]string{CompressionGzip, CompressionZstd, CompressionSnappy}) {
+        compressed := compressTestData(t, bytes.Repeat([]byte("a"), 4096), algorithm)
+        corrupted := append(append([]byte{}, compressed[:len(compressed) / 2]...), 0xff, 0xff, 0xff)
+        if _, err := Decompress(corrupted, algorithm, DefaultMaxDecompressedSize); err == nil {
+            t.Errorf("expected corrupted %s packet to be rejected", algorithm)
+        }
+    }
+    if _, err := Decompress([]byte("data"), "lz4", DefaultMaxDecompressedSize); err == nil {
+        t.Errorf("expected unsupported algorithm to be rejected")
+    }
+}
+
+// test that zstd frames are rejected before decoding if their
+// window exceeds the limit, even if the data is within the limit
+func TestDecompressZstdWindow(t *testing.T) {
+    // encode frame declaring a 1MB window (window descriptor 0x50)
+    // with a single raw block that is also the last block
+    data := []byte(`{"metric_name": "requests_total"}`)
+    header := uint32(len(data)) << 3 | 1
+    frame := append([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x50, byte(header), byte(header >> 8),
+        byte(header >> 16)}, data...)
+
+    tests := []struct {
+        name  string
+        limit int
+        err   error
+    }{
+        {"window within limit", 1 << 20, nil},
+        {"window above limit", 64 * 1024, ErrDecompressedTooLarge},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            decompressed, err := Decompress(frame, CompressionZstd, test.limit)
+            if err != test.err {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+            if err == nil && !bytes.Equal(decompressed, data) {
+                t.Fatalf("decompressed data does not match original data")
+            }
+        })
+    }
+}
+
+// test that compressed batches are split into packets
+func TestSplitBatch(t *testing.T) {
+    binary := []byte{0x01, 0x0a, 0x01, 'a'}
+    tests := []struct {
+        name    string
+        data    []byte
+        packets int
+        err     bool
+    }{
+        {"json packets", []byte("{\"a\": 1}\n\n{\"b\": 2}\n{\"c\": 3}"), 3, false},
+        {"mixed packets", append(append([]byte("{\"a\": 1}\n"), 0x01, byte(len(binary) - 1)),
+            binary[1:]...), 2, false},
+        {"truncated binary packet", append([]byte("{\"a\": 1}\n"), 0x01, 0x05, 0x0a), 1, true},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            packets, err := SplitBatch(test.data)
+            if (err != nil) != test.err {
+                t.Fatalf("unexpected error %v", err)
+            }
+            if len(packets) != test.packets {
+                t.Fatalf("expected %d packets but got %d", test.packets, len(packets))
+            }
+        })
+    }
+}
+
+// test that compressed batches are only processed within
+// the max decompressed size of the server
+func TestProcessCompressed(t *testing.T) {
+    batch := bytes.Repeat([]byte(`{"metric_name": "events_total"}` + "\n"), 10)
+    tests := []struct {
+        name  string
+        limit int
+        value float64
+    }{
+        {"within limit", len(batch), 10},
+        {"above limit", len(batch) - 1, 0},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            registry := initTestMetrics(t, processTestConfig)
+            server := &HermesServer{MaxDecompressedSize: test.limit}
+            server.ProcessPayload(compressTestData(t, batch, CompressionGzip), nil)
+            if got, _ := seriesValue(t, registry, "events_total", map[string]string{}); got != test.value {
+                t.Fatalf("expected counter to be %g but got %g", test.value, got)
+            }
+        })
+    }
+}
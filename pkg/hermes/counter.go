@@ -2,6 +2,7 @@ package hermes
 
 import (
     "fmt"
+    "math"
 
     "github.com/prometheus/client_golang/prometheus"
     log "github.com/sirupsen/logrus"
@@ -10,18 +11,42 @@ import (
 // function used to increment a particular counter
 func IncrementCounter(name string, counterJson CounterJSON) error {
     if counter, ok := Counters[name]; ok {
-        log.Info(fmt.Sprintf("incrementing counter '%s' %v", name, counter))
+        log.Debug(fmt.Sprintf("incrementing counter '%s' %v", name, counter))
         // generate labels for prometheus metric and check for errors
         labels, err := GenerateLabels(counterJson.Labels, "counter", name)
         if err != nil {
             return err
         }
+        // attach exemplar to counter increment if provided
+        if counterJson.Exemplar != nil {
+            exemplar, err := GenerateExemplarLabels(*counterJson.Exemplar)
+            if err != nil {
+                return err
+            }
+            // note that the prometheus counter panics on negative increments
+            value := counterJson.Increment()
+            if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+                return ErrInvalidExemplar
+            }
+            counter.With(labels).(prometheus.ExemplarAdder).AddWithExemplar(value, exemplar)
+            return nil
+        }
         counter.With(labels).Inc()
         return nil
     }
     return ErrUnregisteredMetric
 }
 
+// function used to determine the amount that a counter is incremented
+// by. counters are incremented by 1, unless an exemplar with a value
+// is attached, where the value of the exemplar is used instead
+func(c CounterJSON) Increment() float64 {
+    if c.Exemplar != nil && c.Exemplar.Value != nil {
+        return *c.Exemplar.Value
+    }
+    return 1
+}
+
 // function used to create new counter instance. Pointers to the
 // prometheus counters are stored in the global Gauges map, which
 // maps the name of the counter/metric to the prometheus pointer
@@ -31,7 +56,9 @@ func NewCounter(counter HermesCounter) error {
     // create new counter
     promCounter := prometheus.NewCounterVec(opts, counter.Labels)
     // register counter and insert into maps
-    prometheus.MustRegister(promCounter)
+    if err := Registerer.Register(promCounter); err != nil {
+        return err
+    }
     Counters[counter.MetricName] = promCounter
     return nil
 }
\ No newline at end of file
@@ -0,0 +1,80 @@
+package hermes
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+// test that counters are incremented by the value of the
+// exemplar, and by 1 if no value is given
+func TestIncrementCounterWithExemplar(t *testing.T) {
+    value, negative := 5.0, -1.0
+    tests := []struct {
+        name     string
+        packet   []byte
+        expected float64
+        err      error
+    }{
+        {"no exemplar", []byte(`{"metric_name": "requests_total", "payload": {}}`), 1, nil},
+        {"exemplar without value", []byte(`{"metric_name": "requests_total",
+            "payload": {"exemplar": {"trace_id": "abc"}}}`), 1, nil},
+        {"exemplar with value", []byte(`{"metric_name": "requests_total",
+            "payload": {"exemplar": {"trace_id": "abc", "value": 5}}}`), 5, nil},
+        {"exemplar with zero value", []byte(`{"metric_name": "requests_total",
+            "payload": {"exemplar": {"trace_id": "abc", "value": 0}}}`), 0, nil},
+        {"exemplar with negative value", []byte(`{"metric_name": "requests_total",
+            "payload": {"exemplar": {"trace_id": "abc", "value": -1}}}`), 0, ErrInvalidExemplar},
+        {"binary exemplar with value", protocol.Packet{MetricName: "requests_total",
+            Payload: protocol.Counter{Exemplar: &protocol.Exemplar{TraceID: "abc", Value: &value}}.Marshal(),
+        }.Marshal(), 5, nil},
+        {"binary exemplar with negative value", protocol.Packet{MetricName: "requests_total",
+            Payload: protocol.Counter{Exemplar: &protocol.Exemplar{TraceID: "abc", Value: &negative}}.Marshal(),
+        }.Marshal(), 0, ErrInvalidExemplar},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            registry := initTestMetrics(t, HermesConfig{Counters: []HermesCounter{{MetricName: "requests_total"}}})
+            payload, err := DecodePacket(test.packet)
+            if err != nil {
+                t.Fatalf("unable to decode packet: %v", err)
+            }
+            counter, err := DecodeCounter(payload)
+            if err != nil {
+                t.Fatalf("unable to decode counter: %v", err)
+            }
+            if err := IncrementCounter("requests_total", counter); !errors.Is(err, test.err) {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+            if got, _ := seriesValue(t, registry, "requests_total", map[string]string{}); got != test.expected {
+                t.Fatalf("expected counter to be %g but got %g", test.expected, got)
+            }
+        })
+    }
+}
+
+// test that histogram exemplars are rejected if the
+// value differs from the observation
+func TestObserveHistogramWithExemplar(t *testing.T) {
+    observation, other := 0.25, 1.0
+    tests := []struct {
+        name  string
+        value *float64
+        err   error
+    }{
+        {"exemplar without value", nil, nil},
+        {"exemplar with observation", &observation, nil},
+        {"exemplar with other value", &other, ErrInvalidExemplar},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            initTestMetrics(t, HermesConfig{Histograms: []HermesHistogram{{MetricName: "request_duration"}}})
+            histogram := HistogramJSON{Observation: observation,
+                Exemplar: &ExemplarJSON{TraceID: "abc", Value: test.value}}
+            if err := ObserveHistogram("request_duration", histogram); !errors.Is(err, test.err) {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+        })
+    }
+}
@@ -0,0 +1,274 @@
+package hermes
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "errors"
+    "net/http"
+    "unicode/utf8"
+    "encoding/json"
+    "encoding/base64"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+var (
+    // define defaults of dead-letter sinks. files are rotated once
+    // they exceed the max size (in bytes)
+    DefaultDeadLetterMaxSize    = 10 * 1024 * 1024
+    DefaultDeadLetterMaxFiles   = 3
+    DefaultDeadLetterBufferSize = 1000
+
+    ErrDeadLetterSinkClosed = errors.New("Dead-letter sink closed")
+)
+
+// define reasons that packets are rejected for
+const (
+    ReasonInvalidPacket       = "invalid_packet"
+    ReasonDecompression       = "decompression_failed"
+    ReasonUnauthenticated     = "unauthenticated"
+    ReasonUnregisteredMetric  = "unregistered_metric"
+    ReasonAccessDenied        = "access_denied"
+    ReasonInvalidPayload      = "invalid_payload"
+    ReasonInvalidLabels       = "invalid_labels"
+    ReasonInvalidOperation    = "invalid_operation"
+    ReasonUpdateFailed        = "update_failed"
+)
+
+// struct used to define packet rejected by hermes. packets that
+// are valid UTF-8 text (i.e. JSON packets) are stored as text,
+// while all other packets are stored base64 encoded
+type DeadLetter struct {
+    Timestamp time.Time `json:"timestamp"`
+    Source    string    `json:"source"`
+    Reason    string    `json:"reason"`
+    Error     string    `json:"error,omitempty"`
+    Encoding  string    `json:"encoding"`
+    Packet    string    `json:"packet"`
+}
+
+// interface used to define sinks that rejected packets are written to
+type DeadLetterSink interface {
+    Write(letter DeadLetter) error
+    Close() error
+}
+
+// function used to create new dead letter. note that the packet
+// is copied, since packet buffers are re-used once processed
+func NewDeadLetter(packet []byte, remoteAddr net.Addr, reason string, err error) DeadLetter {
+    letter := DeadLetter{Timestamp: time.Now().UTC(), Reason: reason}
+    if remoteAddr != nil {
+        letter.Source = remoteAddr.String()
+    }
+    if err != nil {
+        letter.Error = err.Error()
+    }
+    if utf8.Valid(packet) && !protocol.IsBinary(packet) {
+        letter.Encoding, letter.Packet = "text", string(packet)
+    } else {
+        letter.Encoding, letter.Packet = "base64", base64.StdEncoding.EncodeToString(packet)
+    }
+    return letter
+}
+
+// function used to create dead-letter sink from config. packets are
+// written to a rotating JSONL file if a path is set, and are kept
+// in an in-memory ring buffer otherwise
+func NewDeadLetterSink(config DeadLetterConfig) (DeadLetterSink, error) {
+    if len(config.Path) > 0 {
+        return NewDeadLetterFile(config.Path, config.MaxSize, config.MaxFiles)
+    }
+    return NewDeadLetterBuffer(config.BufferSize), nil
+}
+
+// function used to retrieve dead-letter sink of the server
+func(server *HermesServer) DeadLetters() DeadLetterSink {
+    return server.deadLetters
+}
+
+// function used to record rejected packet. rejected packets are
+// counted in the self metrics, published to tail subscribers and
+// written to the dead-letter sink if configured. the decoded packet
+// is nil if the packet was rejected before it could be decoded
+func(server *HermesServer) reject(packet []byte, decoded *HermesPayload, remoteAddr net.Addr,
+    reason string, err error) {
+    RejectedPackets.WithLabelValues(reason).Inc()
+    server.publishTail(packet, decoded, remoteAddr, reason, err, nil)
+    if server.deadLetters == nil {
+        return
+    }
+    err = server.deadLetters.Write(NewDeadLetter(packet, remoteAddr, reason, err))
+    if err != nil && !errors.Is(err, ErrDeadLetterSinkClosed) {
+        log.Error(fmt.Errorf("unable to write dead letter: %v", err))
+    }
+}
+
+// function used to record outcome of the update of a metric. the
+// packet is rejected if the update failed
+func(server *HermesServer) completeUpdate(packet []byte, decoded *HermesPayload, remoteAddr net.Addr,
+    payload interface{}, err error) {
+    switch {
+    case err == nil:
+        server.publishTail(packet, decoded, remoteAddr, OutcomeAccepted, nil, payload)
+    case errors.Is(err, ErrInvalidLabels):
+        server.reject(packet, decoded, remoteAddr, ReasonInvalidLabels, err)
+    case errors.Is(err, ErrInvalidGaugeOperation):
+        server.reject(packet, decoded, remoteAddr, ReasonInvalidOperation, err)
+    default:
+        server.reject(packet, decoded, remoteAddr, ReasonUpdateFailed, err)
+    }
+}
+
+// struct used to store the most recent dead letters in memory
+type DeadLetterBuffer struct {
+    mu      sync.Mutex
+    entries []DeadLetter
+    next    int
+    full    bool
+}
+
+// function used to create new ring buffer of dead letters
+func NewDeadLetterBuffer(size int) *DeadLetterBuffer {
+    if size <= 0 {
+        size = DefaultDeadLetterBufferSize
+    }
+    return &DeadLetterBuffer{entries: make([]DeadLetter, size)}
+}
+
+// function used to add dead letter to buffer. the oldest
+// dead letter is overwritten once the buffer is full
+func(b *DeadLetterBuffer) Write(letter DeadLetter) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.entries[b.next] = letter
+    b.next = (b.next + 1) % len(b.entries)
+    if b.next == 0 {
+        b.full = true
+    }
+    return nil
+}
+
+// function used to close buffer
+func(b *DeadLetterBuffer) Close() error {
+    return nil
+}
+
+// function used to retrieve all dead letters in the
+// buffer, ordered from oldest to newest
+func(b *DeadLetterBuffer) Entries() []DeadLetter {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if !b.full {
+        return append([]DeadLetter{}, b.entries[:b.next]...)
+    }
+    return append(append([]DeadLetter{}, b.entries[b.next:]...), b.entries[:b.next]...)
+}
+
+// function used to serve all dead letters in the buffer
+func(b *DeadLetterBuffer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, b.Entries())
+}
+
+// struct used to write dead letters to a JSONL file. the file is
+// rotated once it exceeds the max size, where rotated files are
+// suffixed with .1 (newest) up to .<max files> (oldest)
+type DeadLetterFile struct {
+    Path     string
+    MaxSize  int64
+    MaxFiles int
+
+    mu       sync.Mutex
+    file     *os.File
+    size     int64
+}
+
+// function used to create new dead-letter file
+func NewDeadLetterFile(path string, maxSize, maxFiles int) (*DeadLetterFile, error) {
+    if maxSize <= 0 {
+        maxSize = DefaultDeadLetterMaxSize
+    }
+    if maxFiles <= 0 {
+        maxFiles = DefaultDeadLetterMaxFiles
+    }
+    f := &DeadLetterFile{Path: path, MaxSize: int64(maxSize), MaxFiles: maxFiles}
+    if err := f.open(); err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+// function used to open dead-letter file for appending
+func(f *DeadLetterFile) open() error {
+    file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return err
+    }
+    f.file, f.size = file, info.Size()
+    return nil
+}
+
+// function used to append dead letter to file
+func(f *DeadLetterFile) Write(letter DeadLetter) error {
+    line, err := json.Marshal(letter)
+    if err != nil {
+        return err
+    }
+    line = append(line, '\n')
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.file == nil {
+        return ErrDeadLetterSinkClosed
+    }
+    // note that dead letters are still written to the current file if
+    // the file cannot be rotated, so that the sink is never disabled
+    if f.size > 0 && f.size + int64(len(line)) > f.MaxSize {
+        if err := f.rotate(); err != nil {
+            log.Error(fmt.Errorf("unable to rotate dead-letter file %s: %v", f.Path, err))
+        }
+    }
+    n, err := f.file.Write(line)
+    f.size += int64(n)
+    return err
+}
+
+// function used to rotate dead-letter files. the oldest file
+// is removed and all other files are shifted by one. the current
+// file is only closed once the new file has been opened, so that
+// the current file is kept if the rotation fails
+func(f *DeadLetterFile) rotate() error {
+    os.Remove(fmt.Sprintf("%s.%d", f.Path, f.MaxFiles))
+    for i := f.MaxFiles - 1; i > 0; i-- {
+        os.Rename(fmt.Sprintf("%s.%d", f.Path, i), fmt.Sprintf("%s.%d", f.Path, i + 1))
+    }
+    if err := os.Rename(f.Path, f.Path + ".1"); err != nil {
+        return err
+    }
+    current := f.file
+    if err := f.open(); err != nil {
+        return err
+    }
+    return current.Close()
+}
+
+// function used to close dead-letter file
+func(f *DeadLetterFile) Close() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.file == nil {
+        return nil
+    }
+    err := f.file.Close()
+    f.file = nil
+    return err
+}
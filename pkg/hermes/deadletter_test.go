@@ -0,0 +1,137 @@
+package hermes
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bufio"
+    "reflect"
+    "testing"
+    "encoding/json"
+    "path/filepath"
+)
+
+// function used to create dead letter with the given reason. the
+// timestamp is fixed so that all dead letters have the same size
+func testDeadLetter(reason string) DeadLetter {
+    letter := NewDeadLetter([]byte(`{"metric_name": "requests_total"}`), nil, reason, nil)
+    letter.Timestamp = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    return letter
+}
+
+// function used to count the lines of a file
+func countLines(t *testing.T, path string) int {
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatalf("unable to open %s: %v", path, err)
+    }
+    defer file.Close()
+    lines := 0
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lines++
+    }
+    return lines
+}
+
+// test that dead letters are returned from oldest to newest
+// once the ring buffer wraps around
+func TestDeadLetterBuffer(t *testing.T) {
+    tests := []struct {
+        name     string
+        writes   int
+        expected []string
+    }{
+        {"empty", 0, []string{}},
+        {"partially filled", 2, []string{"0", "1"}},
+        {"full", 3, []string{"0", "1", "2"}},
+        {"wrapped", 4, []string{"1", "2", "3"}},
+        {"wrapped twice", 7, []string{"4", "5", "6"}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            buffer := NewDeadLetterBuffer(3)
+            for i := 0; i < test.writes; i++ {
+                buffer.Write(testDeadLetter(fmt.Sprint(i)))
+            }
+            reasons := []string{}
+            for _, letter := range(buffer.Entries()) {
+                reasons = append(reasons, letter.Reason)
+            }
+            if !reflect.DeepEqual(reasons, test.expected) {
+                t.Fatalf("expected dead letters %v but got %v", test.expected, reasons)
+            }
+        })
+    }
+}
+
+// test that dead-letter files are rotated once they exceed the max
+// size, keeping at most the max number of rotated files
+func TestDeadLetterFileRotation(t *testing.T) {
+    line, err := json.Marshal(testDeadLetter("0"))
+    if err != nil {
+        t.Fatalf("unable to marshal dead letter: %v", err)
+    }
+    size := len(line) + 1
+    tests := []struct {
+        name     string
+        maxSize  int
+        maxFiles int
+        writes   int
+        files    []int
+    }{
+        {"below max size", 10 * size, 2, 5, []int{5}},
+        {"rotated", 3 * size, 2, 5, []int{2, 3}},
+        {"oldest files removed", 2 * size, 2, 7, []int{1, 2, 2}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            path := filepath.Join(tempDir(t), "dead_letters.jsonl")
+            sink, err := NewDeadLetterFile(path, test.maxSize, test.maxFiles)
+            if err != nil {
+                t.Fatalf("unable to create dead-letter file: %v", err)
+            }
+            for i := 0; i < test.writes; i++ {
+                if err := sink.Write(testDeadLetter(fmt.Sprint(i))); err != nil {
+                    t.Fatalf("unable to write dead letter: %v", err)
+                }
+            }
+            sink.Close()
+            for i, expected := range(test.files) {
+                name := path
+                if i > 0 {
+                    name = fmt.Sprintf("%s.%d", path, i)
+                }
+                if lines := countLines(t, name); lines != expected {
+                    t.Fatalf("expected %d dead letters in %s but got %d", expected, name, lines)
+                }
+            }
+            if _, err := os.Stat(fmt.Sprintf("%s.%d", path, len(test.files))); !os.IsNotExist(err) {
+                t.Fatalf("expected at most %d rotated files", len(test.files) - 1)
+            }
+        })
+    }
+}
+
+// test that dead letters are still written to the current file
+// if the file cannot be rotated
+func TestDeadLetterFileRotationFailure(t *testing.T) {
+    path := filepath.Join(tempDir(t), "dead_letters.jsonl")
+    // block rotation with a non-empty directory at the rotated path
+    if err := os.MkdirAll(filepath.Join(path + ".1", "blocked"), 0755); err != nil {
+        t.Fatalf("unable to create directory: %v", err)
+    }
+    sink, err := NewDeadLetterFile(path, 1, 1)
+    if err != nil {
+        t.Fatalf("unable to create dead-letter file: %v", err)
+    }
+    for i := 0; i < 3; i++ {
+        if err := sink.Write(testDeadLetter(fmt.Sprint(i))); err != nil {
+            t.Fatalf("expected dead letter to be written despite failed rotation: %v", err)
+        }
+    }
+    sink.Close()
+    if lines := countLines(t, path); lines != 3 {
+        t.Fatalf("expected 3 dead letters in current file but got %d", lines)
+    }
+}
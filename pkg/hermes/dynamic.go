@@ -0,0 +1,324 @@
+package hermes
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "sort"
+    "sync"
+    "errors"
+    "strings"
+    "io/ioutil"
+    "encoding/json"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+)
+
+var (
+    // define default max number of dynamic metrics
+    DefaultMaxDynamicMetrics = 100
+
+    // define map used to store definitions of all metrics
+    // that have been registered dynamically
+    DynamicMetrics = map[string]MetricDefinition{}
+
+    // define lock used to guard the maps of metrics and access rules,
+    // which are modified when metrics are registered dynamically
+    metricsLock sync.RWMutex
+
+    ErrDynamicMetricsDisabled = errors.New("Dynamic metrics are disabled")
+    ErrInvalidDefinition      = errors.New("Invalid metric definition")
+    ErrPrefixNotAllowed       = errors.New("Metric name prefix not allowed")
+    ErrTooManyDynamicMetrics  = errors.New("Max number of dynamic metrics reached")
+)
+
+// function used to validate metric definition
+func ValidateDefinition(definition MetricDefinition) error {
+    switch definition.Type {
+    case "counter", "gauge", "summary", "set":
+        if len(definition.Buckets) > 0 {
+            return fmt.Errorf("%w: buckets are only supported for histograms", ErrInvalidDefinition)
+        }
+    case "histogram":
+    default:
+        return fmt.Errorf("%w: invalid metric type '%s'", ErrInvalidDefinition, definition.Type)
+    }
+    if err := ValidateReservedLabels(definition.Type, definition.Labels); err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+    }
+    return nil
+}
+
+// function used to register metric from definition sent with a
+// packet. the metric is only registered if it is allowed by the
+// dynamic metrics policy of the hermes config. metrics that are
+// already registered are skipped, and the definition is persisted
+// to the dynamic metrics file if configured
+func RegisterDynamicMetric(name string, definition MetricDefinition) error {
+    if Config == nil || Config.DynamicMetrics == nil {
+        return ErrDynamicMetricsDisabled
+    }
+    policy := Config.DynamicMetrics
+
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    if _, err := GetMetricType(name); err == nil {
+        return nil
+    }
+    if err := registerDynamicMetric(name, definition, policy); err != nil {
+        return err
+    }
+    log.Info(fmt.Sprintf("registered dynamic %s '%s'", definition.Type, name))
+    if len(policy.Path) > 0 {
+        if err := saveDynamicMetrics(policy.Path); err != nil {
+            log.Error(fmt.Errorf("unable to persist dynamic metrics: %v", err))
+        }
+    }
+    return nil
+}
+
+// function used to validate definition against the dynamic metrics
+// policy and to create the metric. note that the metrics lock must
+// be held when registering dynamic metrics
+func registerDynamicMetric(name string, definition MetricDefinition, policy *DynamicMetricsConfig) error {
+    if err := ValidateDefinition(definition); err != nil {
+        return err
+    }
+    if !hasAllowedPrefix(name, policy.AllowedPrefixes) {
+        return ErrPrefixNotAllowed
+    }
+    maxMetrics := policy.MaxMetrics
+    if maxMetrics <= 0 {
+        maxMetrics = DefaultMaxDynamicMetrics
+    }
+    if len(DynamicMetrics) >= maxMetrics {
+        return ErrTooManyDynamicMetrics
+    }
+    // create metric and add to config so that labels are resolved
+    var err error
+    switch definition.Type {
+    case "counter":
+        counter := HermesCounter{MetricName: name, MetricDescription: definition.Description,
+            Labels: definition.Labels, AccessControl: policy.AccessControl}
+        if err = NewCounter(counter); err == nil {
+            Config.Counters = append(Config.Counters, counter)
+        }
+    case "gauge":
+        gauge := HermesGauge{MetricName: name, MetricDescription: definition.Description,
+            Labels: definition.Labels, AccessControl: policy.AccessControl}
+        if err = NewGauge(gauge); err == nil {
+            Config.Gauges = append(Config.Gauges, gauge)
+        }
+    case "histogram":
+        histogram := HermesHistogram{MetricName: name, MetricDescription: definition.Description,
+            Labels: definition.Labels, Buckets: definition.Buckets, AccessControl: policy.AccessControl}
+        if err = NewHistogram(histogram); err == nil {
+            Config.Histograms = append(Config.Histograms, histogram)
+        }
+    case "summary":
+        summary := HermesSummary{MetricName: name, MetricDescription: definition.Description,
+            Labels: definition.Labels, AccessControl: policy.AccessControl}
+        if err = NewSummary(summary); err == nil {
+            Config.Summaries = append(Config.Summaries, summary)
+        }
+    case "set":
+        set := HermesSet{MetricName: name, MetricDescription: definition.Description,
+            Labels: definition.Labels, AccessControl: policy.AccessControl}
+        if err = NewSet(set); err == nil {
+            Config.Sets = append(Config.Sets, set)
+        }
+    }
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+    }
+    if err := RegisterAccessRule(name, policy.AccessControl); err != nil {
+        return err
+    }
+    DynamicMetrics[name] = definition
+    DynamicMetricsCount.Set(float64(len(DynamicMetrics)))
+    return nil
+}
+
+// function used to determine if metric name starts with one
+// of the allowed prefixes. all names are allowed if no
+// prefixes are set
+func hasAllowedPrefix(name string, prefixes []string) bool {
+    if len(prefixes) == 0 {
+        return true
+    }
+    for _, prefix := range(prefixes) {
+        if strings.HasPrefix(name, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// function used to retrieve definitions of all metrics
+// that have been registered dynamically
+func ListDynamicMetrics() map[string]MetricDefinition {
+    metricsLock.RLock()
+    defer metricsLock.RUnlock()
+    definitions := map[string]MetricDefinition{}
+    for name, definition := range(DynamicMetrics) {
+        definitions[name] = definition
+    }
+    return definitions
+}
+
+// function used to convert dynamic metrics into a hermes config
+// containing only the definitions of the dynamic metrics. note
+// that the metrics lock must be held
+func dynamicMetricsConfig() HermesConfig {
+    names := make([]string, 0, len(DynamicMetrics))
+    for name := range(DynamicMetrics) {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    config := HermesConfig{}
+    for _, name := range(names) {
+        definition := DynamicMetrics[name]
+        switch definition.Type {
+        case "counter":
+            config.Counters = append(config.Counters, HermesCounter{MetricName: name,
+                MetricDescription: definition.Description, Labels: definition.Labels})
+        case "gauge":
+            config.Gauges = append(config.Gauges, HermesGauge{MetricName: name,
+                MetricDescription: definition.Description, Labels: definition.Labels})
+        case "histogram":
+            config.Histograms = append(config.Histograms, HermesHistogram{MetricName: name,
+                MetricDescription: definition.Description, Labels: definition.Labels,
+                Buckets: definition.Buckets})
+        case "summary":
+            config.Summaries = append(config.Summaries, HermesSummary{MetricName: name,
+                MetricDescription: definition.Description, Labels: definition.Labels})
+        case "set":
+            config.Sets = append(config.Sets, HermesSet{MetricName: name,
+                MetricDescription: definition.Description, Labels: definition.Labels})
+        }
+    }
+    return config
+}
+
+// function used to persist dynamic metrics to a config file. the
+// file uses the same format as the hermes config, and can be merged
+// into the hermes config to make dynamic metrics permanent
+func saveDynamicMetrics(path string) error {
+    bytesJson, err := json.MarshalIndent(dynamicMetricsConfig(), "", "    ")
+    if err != nil {
+        return err
+    }
+    return utils.WriteFileAtomic(path, bytesJson)
+}
+
+// function used to register dynamic metrics persisted to a config
+// file. definitions that are no longer allowed by the policy of the
+// hermes config are skipped
+func LoadDynamicMetrics(path string) error {
+    if Config == nil || Config.DynamicMetrics == nil {
+        return ErrDynamicMetricsDisabled
+    }
+    bytesJson, err := ioutil.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return err
+    }
+    var config HermesConfig
+    if err := json.Unmarshal(bytesJson, &config); err != nil {
+        return err
+    }
+    definitions := map[string]MetricDefinition{}
+    for _, counter := range(config.Counters) {
+        definitions[counter.MetricName] = MetricDefinition{Type: "counter",
+            Description: counter.MetricDescription, Labels: counter.Labels}
+    }
+    for _, gauge := range(config.Gauges) {
+        definitions[gauge.MetricName] = MetricDefinition{Type: "gauge",
+            Description: gauge.MetricDescription, Labels: gauge.Labels}
+    }
+    for _, histogram := range(config.Histograms) {
+        definitions[histogram.MetricName] = MetricDefinition{Type: "histogram",
+            Description: histogram.MetricDescription, Labels: histogram.Labels, Buckets: histogram.Buckets}
+    }
+    for _, summary := range(config.Summaries) {
+        definitions[summary.MetricName] = MetricDefinition{Type: "summary",
+            Description: summary.MetricDescription, Labels: summary.Labels}
+    }
+    for _, set := range(config.Sets) {
+        definitions[set.MetricName] = MetricDefinition{Type: "set",
+            Description: set.MetricDescription, Labels: set.Labels}
+    }
+
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    for name, definition := range(definitions) {
+        if _, err := GetMetricType(name); err == nil {
+            log.Warn(fmt.Sprintf("skipping dynamic metric '%s': metric already registered", name))
+            continue
+        }
+        if err := registerDynamicMetric(name, definition, Config.DynamicMetrics); err != nil {
+            log.Warn(fmt.Sprintf("skipping dynamic metric '%s': %v", name, err))
+        }
+    }
+    log.Info(fmt.Sprintf("loaded %d dynamic metrics from %s", len(DynamicMetrics), path))
+    return nil
+}
+
+// function used to register metric from the definition sent with
+// a packet. the sender of the packet must be allowed to register
+// metrics by the access control of the dynamic metrics policy
+func(server *HermesServer) registerDefinition(payload HermesPayload, keyID string, remoteAddr net.Addr) {
+    // skip metrics that are already registered
+    metricsLock.RLock()
+    _, err := GetMetricType(payload.MetricName)
+    metricsLock.RUnlock()
+    if err == nil {
+        return
+    }
+    rule, err := NewAccessRule(Config.DynamicMetrics.AccessControl)
+    if err != nil {
+        log.Error(fmt.Errorf("invalid access control for dynamic metrics: %v", err))
+        return
+    }
+    restricted := len(rule.AllowedKeys) > 0 || len(rule.AllowedSources) > 0
+    if restricted && !rule.Allows(keyID, remoteAddr) {
+        log.Warn(fmt.Sprintf("rejecting definition of metric %s from %v (key '%s'): access denied",
+            payload.MetricName, remoteAddr, keyID))
+        DynamicMetricRejections.WithLabelValues("access_denied").Inc()
+        return
+    }
+    if err := RegisterDynamicMetric(payload.MetricName, *payload.Definition); err != nil {
+        log.Warn(fmt.Sprintf("rejecting definition of metric %s from %v: %v", payload.MetricName, remoteAddr, err))
+        DynamicMetricRejections.WithLabelValues(rejectionReason(err)).Inc()
+        return
+    }
+    // aggregate metric using the registered definition, since the
+    // metric may have been registered concurrently by another packet
+    metricsLock.RLock()
+    defer metricsLock.RUnlock()
+    definition, ok := DynamicMetrics[payload.MetricName]
+    if !ok {
+        return
+    }
+    if err := server.aggregator.AddDynamicMetric(payload.MetricName, definition); err != nil {
+        log.Error(fmt.Errorf("unable to aggregate dynamic metric %s: %v", payload.MetricName, err))
+    }
+}
+
+// function used to determine reason that definition was rejected
+func rejectionReason(err error) string {
+    switch {
+    case errors.Is(err, ErrPrefixNotAllowed):
+        return "prefix_not_allowed"
+    case errors.Is(err, ErrTooManyDynamicMetrics):
+        return "limit_reached"
+    case errors.Is(err, ErrInvalidDefinition):
+        return "invalid_definition"
+    }
+    return "registration_failed"
+}
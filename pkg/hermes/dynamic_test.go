@@ -0,0 +1,97 @@
+package hermes
+
+import (
+    "fmt"
+    "errors"
+    "testing"
+)
+
+// test validation of dynamic metric definitions
+func TestValidateDefinition(t *testing.T) {
+    tests := []struct {
+        name       string
+        definition MetricDefinition
+        err        error
+    }{
+        {"counter", MetricDefinition{Type: "counter", Labels: []string{"app"}}, nil},
+        {"histogram with buckets", MetricDefinition{Type: "histogram", Buckets: []float64{1, 10}}, nil},
+        {"gauge with buckets", MetricDefinition{Type: "gauge", Buckets: []float64{1}}, ErrInvalidDefinition},
+        {"set", MetricDefinition{Type: "set", Labels: []string{"app"}}, nil},
+        {"set with buckets", MetricDefinition{Type: "set", Buckets: []float64{1}}, ErrInvalidDefinition},
+        {"invalid type", MetricDefinition{Type: "timer"}, ErrInvalidDefinition},
+        {"histogram with le label", MetricDefinition{Type: "histogram", Labels: []string{"app", "le"}},
+            ErrInvalidDefinition},
+        {"summary with quantile label", MetricDefinition{Type: "summary", Labels: []string{"quantile"}},
+            ErrInvalidDefinition},
+        {"counter with le label", MetricDefinition{Type: "counter", Labels: []string{"le"}}, nil},
+        {"gauge with quantile label", MetricDefinition{Type: "gauge", Labels: []string{"quantile"}}, nil},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            if err := ValidateDefinition(test.definition); !errors.Is(err, test.err) {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+        })
+    }
+}
+
+// test that reserved labels are rejected for configured metrics
+func TestReservedLabels(t *testing.T) {
+    initTestMetrics(t, HermesConfig{})
+    if err := NewHistogram(HermesHistogram{MetricName: "duration", Labels: []string{"le"}}); !errors.Is(err, ErrInvalidLabels) {
+        t.Fatalf("expected ErrInvalidLabels for histogram but got %v", err)
+    }
+    if err := NewSummary(HermesSummary{MetricName: "latency", Labels: []string{"quantile"}}); !errors.Is(err, ErrInvalidLabels) {
+        t.Fatalf("expected ErrInvalidLabels for summary but got %v", err)
+    }
+}
+
+// test that dynamic metrics are aggregated once registered
+func TestRegisterDefinitionAggregated(t *testing.T) {
+    config := HermesConfig{DynamicMetrics: &DynamicMetricsConfig{}, Aggregation: &AggregationConfig{}}
+    registry := initTestMetrics(t, config)
+    aggregator, err := NewAggregator(config)
+    if err != nil {
+        t.Fatalf("unable to create aggregator: %v", err)
+    }
+    server := &HermesServer{aggregator: aggregator}
+    packet := []byte(`{"metric_name": "jobs_total", "payload": {"labels": {"app": "web"}},
+        "definition": {"type": "counter", "labels": ["app"]}}`)
+    for i := 0; i < 2; i++ {
+        server.ProcessPayload(packet, nil)
+    }
+    aggregator.Flush()
+    if value, _ := seriesValue(t, registry, "jobs_total", map[string]string{"app": "web"}); value != 2 {
+        t.Fatalf("expected counter to be 2 but got %g", value)
+    }
+    if value, ok := seriesValue(t, registry, "jobs_total_rate", map[string]string{"app": "web"}); !ok || value <= 0 {
+        t.Fatalf("expected rate of dynamic counter to be exposed but got %g", value)
+    }
+}
+
+// test that dynamic sets are aggregated once registered, even
+// if aggregation of other metrics is disabled
+func TestRegisterDefinitionSet(t *testing.T) {
+    config := HermesConfig{DynamicMetrics: &DynamicMetricsConfig{}}
+    registry := initTestMetrics(t, config)
+    aggregator, err := NewAggregator(config)
+    if err != nil {
+        t.Fatalf("unable to create aggregator: %v", err)
+    }
+    server := &HermesServer{aggregator: aggregator}
+    for _, user := range([]string{"alice", "bob", "alice"}) {
+        packet := []byte(fmt.Sprintf(`{"metric_name": "unique_users", "payload": {"labels": {"app": "web"},
+            "value": "%s"}, "definition": {"type": "set", "labels": ["app"]}}`, user))
+        server.ProcessPayload(packet, nil)
+    }
+    aggregator.Flush()
+    if value, ok := seriesValue(t, registry, "unique_users", map[string]string{"app": "web"}); !ok || value != 2 {
+        t.Fatalf("expected 2 unique values but got %g", value)
+    }
+    metricsLock.RLock()
+    persisted := dynamicMetricsConfig()
+    metricsLock.RUnlock()
+    if len(persisted.Sets) != 1 || persisted.Sets[0].MetricName != "unique_users" {
+        t.Fatalf("expected dynamic set to be persisted but got %+v", persisted.Sets)
+    }
+}
@@ -0,0 +1,40 @@
+package hermes
+
+import (
+    "errors"
+    "unicode/utf8"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    ErrInvalidExemplar = errors.New("Invalid exemplar configuration")
+)
+
+// function used to convert exemplar JSON into prometheus labels.
+// the labels are validated before being returned, since the
+// prometheus exemplar APIs panic on invalid exemplars
+func GenerateExemplarLabels(exemplar ExemplarJSON) (prometheus.Labels, error) {
+    labels := prometheus.Labels{}
+    if len(exemplar.TraceID) > 0 {
+        labels["trace_id"] = exemplar.TraceID
+    }
+    if len(exemplar.SpanID) > 0 {
+        labels["span_id"] = exemplar.SpanID
+    }
+    if len(labels) == 0 {
+        return labels, ErrInvalidExemplar
+    }
+    // ensure that labels are valid and within rune limits
+    runes := 0
+    for name, value := range(labels) {
+        if !utf8.ValidString(value) {
+            return labels, ErrInvalidExemplar
+        }
+        runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+    }
+    if runes > prometheus.ExemplarMaxRunes {
+        return labels, ErrInvalidExemplar
+    }
+    return labels, nil
+}
@@ -9,7 +9,7 @@ import (
 // function used to set the value on a particular gauge
 func SetGauge(name string, gaugeJson GaugeJSON) error {
     if gauge, ok := Gauges[name]; ok {
-        log.Info(fmt.Sprintf("setting gauge '%s' %v", name, gauge))
+        log.Debug(fmt.Sprintf("setting gauge '%s' %v", name, gauge))
         // generate labels for prometheus metric and check for errors
         labels, err := GenerateLabels(gaugeJson.Labels, "gauge", name)
         if err != nil {
@@ -24,7 +24,7 @@ func SetGauge(name string, gaugeJson GaugeJSON) error {
 // function used to increment gauge a particular gauge value
 func IncrementGauge(name string, gaugeJson GaugeJSON) error {
     if gauge, ok := Gauges[name]; ok {
-        log.Info(fmt.Sprintf("incrementing gauge '%s' %v", name, gauge))
+        log.Debug(fmt.Sprintf("incrementing gauge '%s' %v", name, gauge))
         // generate labels for prometheus metric and check for errors
         labels, err := GenerateLabels(gaugeJson.Labels, "gauge", name)
         if err != nil {
@@ -39,7 +39,7 @@ func IncrementGauge(name string, gaugeJson GaugeJSON) error {
 // function used to decrement a particular gauge value
 func DecrementGauge(name string, gaugeJson GaugeJSON) error {
     if gauge, ok := Gauges[name]; ok {
-        log.Info(fmt.Sprintf("decrementing gauge '%s' %v", name, gauge))
+        log.Debug(fmt.Sprintf("decrementing gauge '%s' %v", name, gauge))
         // generate labels for prometheus metric and check for errors
         labels, err := GenerateLabels(gaugeJson.Labels, "gauge", name)
         if err != nil {
@@ -85,7 +85,9 @@ func NewGauge(gauge HermesGauge) error {
     // create new prometheus gauge
     promGauge := prometheus.NewGaugeVec(opts, gauge.Labels)
     // register gauge and insert into maps
-    prometheus.MustRegister(promGauge)
+    if err := Registerer.Register(promGauge); err != nil {
+        return err
+    }
     Gauges[gauge.MetricName] = promGauge
     return nil
 }
\ No newline at end of file
@@ -0,0 +1,127 @@
+package hermes
+
+import (
+    "time"
+    "runtime"
+    "net/http"
+    "sync/atomic"
+)
+
+var (
+    // define version and commit of hermes build. both are
+    // set at build time with -ldflags, i.e.
+    // -X github.com/PSauerborn/hermes/pkg/hermes.Version=v1.2.0
+    Version = "dev"
+    Commit  = "unknown"
+
+    // define interval used by the UDP reader to report that it is
+    // alive. the reader is considered dead if no heartbeat has been
+    // reported for three intervals
+    HeartbeatInterval = 5 * time.Second
+    // define duration after which the UDP reader is reported as idle
+    // if no packets have been received
+    IdleThreshold = 5 * time.Minute
+)
+
+// struct used to define health of the UDP reader
+type HealthStatus struct {
+    Status     string     `json:"status"`
+    Idle       bool       `json:"idle"`
+    Heartbeat  *time.Time `json:"heartbeat,omitempty"`
+    LastPacket *time.Time `json:"last_packet,omitempty"`
+}
+
+// struct used to define readiness of the hermes server
+type ReadinessStatus struct {
+    Status      string `json:"status"`
+    Initialized bool   `json:"initialized"`
+    SocketBound bool   `json:"socket_bound"`
+}
+
+// struct used to define version of hermes build
+type VersionInfo struct {
+    Version   string `json:"version"`
+    Commit    string `json:"commit"`
+    GoVersion string `json:"go_version"`
+}
+
+// function used to record heartbeat of UDP reader
+func(server *HermesServer) beat() {
+    atomic.StoreInt64(&server.heartbeat, time.Now().UnixNano())
+}
+
+// function used to determine health of the UDP reader. the reader
+// is healthy if it has reported a heartbeat recently, and is
+// reported as idle (but still healthy) if no packets have been
+// received recently
+func(server *HermesServer) Health() HealthStatus {
+    status := HealthStatus{Status: "ok"}
+    heartbeat := atomic.LoadInt64(&server.heartbeat)
+    if heartbeat > 0 {
+        t := time.Unix(0, heartbeat).UTC()
+        status.Heartbeat = &t
+    }
+    if lastPacket := atomic.LoadInt64(&server.lastPacket); lastPacket > 0 {
+        t := time.Unix(0, lastPacket).UTC()
+        status.LastPacket = &t
+    }
+    status.Idle = status.LastPacket == nil || time.Since(*status.LastPacket) > IdleThreshold
+    if server.IsClosed() || heartbeat == 0 || time.Since(*status.Heartbeat) > 3 * HeartbeatInterval {
+        status.Status = "unhealthy"
+    }
+    return status
+}
+
+// function used to determine readiness of the hermes server. the
+// server is ready once metrics have been initialized and the UDP
+// socket is bound
+func(server *HermesServer) Readiness() ReadinessStatus {
+    status := ReadinessStatus{
+        Initialized: atomic.LoadInt32(&server.ready) == 1,
+        SocketBound: server.Socket != nil && server.Socket.LocalAddr() != nil && !server.IsClosed(),
+    }
+    status.Status = "not ready"
+    if status.Initialized && status.SocketBound {
+        status.Status = "ok"
+    }
+    return status
+}
+
+// function used to retrieve version of hermes build
+func GetVersion() VersionInfo {
+    return VersionInfo{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+}
+
+// function used to generate HTTP handler used to report the health
+// of the UDP reader. returns 503 if the reader is unhealthy
+func(server *HermesServer) HealthHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        status := server.Health()
+        if status.Status != "ok" {
+            writeJSON(w, http.StatusServiceUnavailable, status)
+            return
+        }
+        writeJSON(w, http.StatusOK, status)
+    })
+}
+
+// function used to generate HTTP handler used to report the
+// readiness of the server. returns 503 if the server is not ready
+func(server *HermesServer) ReadinessHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        status := server.Readiness()
+        if status.Status != "ok" {
+            writeJSON(w, http.StatusServiceUnavailable, status)
+            return
+        }
+        writeJSON(w, http.StatusOK, status)
+    })
+}
+
+// function used to generate HTTP handler serving the version
+// of the hermes build
+func VersionHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, GetVersion())
+    })
+}
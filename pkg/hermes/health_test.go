@@ -0,0 +1,164 @@
+package hermes
+
+import (
+    "net"
+    "time"
+    "runtime"
+    "testing"
+    "net/http"
+    "encoding/json"
+    "net/http/httptest"
+)
+
+// function used to send request to handler and decode the JSON
+// response into the given body. the status code is returned
+func probe(t *testing.T, handler http.Handler, body interface{}) int {
+    recorder := httptest.NewRecorder()
+    handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+    if err := json.NewDecoder(recorder.Body).Decode(body); err != nil {
+        t.Fatalf("unable to decode response: %v", err)
+    }
+    return recorder.Code
+}
+
+// function used to wait until the health of the server matches
+func waitForHealth(t *testing.T, server *HermesServer, expected int, idle bool) {
+    deadline := time.Now().Add(2 * time.Second)
+    var status HealthStatus
+    code := 0
+    for time.Now().Before(deadline) {
+        if code = probe(t, server.HealthHandler(), &status); code == expected && status.Idle == idle {
+            return
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    t.Fatalf("expected health %d (idle %v) but got %d %+v", expected, idle, code, status)
+}
+
+// test that the server is only ready once metrics have been
+// initialized and while the UDP socket is bound
+func TestReadinessHandler(t *testing.T) {
+    tests := []struct {
+        name     string
+        setup    bool
+        close    bool
+        expected ReadinessStatus
+        code     int
+    }{
+        {"not initialized", false, false, ReadinessStatus{Status: "not ready", SocketBound: true},
+            http.StatusServiceUnavailable},
+        {"ready", true, false, ReadinessStatus{Status: "ok", Initialized: true, SocketBound: true},
+            http.StatusOK},
+        {"closed", true, true, ReadinessStatus{Status: "not ready", Initialized: true},
+            http.StatusServiceUnavailable},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            initTestMetrics(t, HermesConfig{})
+            server, err := NewWithConfig(HermesConfig{}, "127.0.0.1", 0)
+            if err != nil {
+                t.Fatalf("unable to create server: %v", err)
+            }
+            server.PrometheusPort = 0
+            t.Cleanup(func() { server.Close() })
+            if test.setup {
+                server.Setup()
+            }
+            if test.close {
+                server.Close()
+            }
+            var status ReadinessStatus
+            if code := probe(t, server.ReadinessHandler(), &status); code != test.code || status != test.expected {
+                t.Fatalf("expected readiness %d %+v but got %d %+v", test.code, test.expected, code, status)
+            }
+        })
+    }
+}
+
+// test health of the UDP reader depending on the age of the
+// last heartbeat and the last packet
+func TestHealth(t *testing.T) {
+    ago := func(d time.Duration) int64 {
+        return time.Now().Add(-d).UnixNano()
+    }
+    tests := []struct {
+        name       string
+        heartbeat  int64
+        lastPacket int64
+        code       int
+        idle       bool
+    }{
+        {"no heartbeat", 0, 0, http.StatusServiceUnavailable, true},
+        {"recent heartbeat without packets", ago(time.Second), 0, http.StatusOK, true},
+        {"recent heartbeat and packet", ago(time.Second), ago(time.Second), http.StatusOK, false},
+        {"heartbeat within three intervals", ago(2 * HeartbeatInterval), ago(time.Second), http.StatusOK, false},
+        {"stale heartbeat", ago(4 * HeartbeatInterval), ago(time.Second), http.StatusServiceUnavailable, false},
+        {"idle reader", ago(time.Second), ago(2 * IdleThreshold), http.StatusOK, true},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            server := &HermesServer{heartbeat: test.heartbeat, lastPacket: test.lastPacket}
+            var status HealthStatus
+            code := probe(t, server.HealthHandler(), &status)
+            if code != test.code || status.Idle != test.idle {
+                t.Fatalf("expected health %d (idle %v) but got %d %+v", test.code, test.idle, code, status)
+            }
+            if (status.Heartbeat != nil) != (test.heartbeat > 0) {
+                t.Fatalf("expected heartbeat to be reported if set but got %v", status.Heartbeat)
+            }
+        })
+    }
+}
+
+// test that the UDP reader keeps reporting heartbeats while idle,
+// leaves the idle state once a packet is received and is reported
+// as unhealthy once closed
+func TestHealthHeartbeat(t *testing.T) {
+    interval := HeartbeatInterval
+    HeartbeatInterval = 20 * time.Millisecond
+    server, _ := newStreamTestServer(t)
+    exited := make(chan struct{})
+    go func() {
+        server.Listen()
+        close(exited)
+    }()
+    t.Cleanup(func() {
+        server.Close()
+        <-exited
+        HeartbeatInterval = interval
+    })
+
+    waitForHealth(t, server, http.StatusOK, true)
+    // reader is still healthy after more than three intervals without packets
+    time.Sleep(5 * HeartbeatInterval)
+    waitForHealth(t, server, http.StatusOK, true)
+
+    conn, err := net.Dial("udp", server.Socket.LocalAddr().String())
+    if err != nil {
+        t.Fatalf("unable to connect: %v", err)
+    }
+    defer conn.Close()
+    if _, err := conn.Write([]byte(`{"metric_name": "events_total", "payload": {}}`)); err != nil {
+        t.Fatalf("unable to send packet: %v", err)
+    }
+    waitForHealth(t, server, http.StatusOK, false)
+
+    server.Close()
+    waitForHealth(t, server, http.StatusServiceUnavailable, false)
+}
+
+// test that the version of the build is served
+func TestVersionHandler(t *testing.T) {
+    version, commit := Version, Commit
+    Version, Commit = "v1.2.0", "abc123"
+    t.Cleanup(func() { Version, Commit = version, commit })
+
+    var info VersionInfo
+    if code := probe(t, VersionHandler(), &info); code != http.StatusOK {
+        t.Fatalf("expected status 200 but got %d", code)
+    }
+    expected := VersionInfo{Version: "v1.2.0", Commit: "abc123", GoVersion: runtime.Version()}
+    if info != expected {
+        t.Fatalf("expected version %+v but got %+v", expected, info)
+    }
+}
@@ -3,18 +3,111 @@ package hermes
 import (
     "fmt"
     "net"
+    "sync"
+    "sync/atomic"
     "time"
-    "encoding/json"
     log "github.com/sirupsen/logrus"
 )
 
+var (
+    // define default port used to serve prometheus metrics
+    DefaultPrometheusPort = 8080
+
+    // define default and max size (in bytes) of datagrams. larger
+    // datagrams are detected as truncated and dropped
+    DefaultMaxPacketSize = 8192
+    MaxPacketSizeLimit   = 65535
+)
+
 type HermesServer struct {
+    // unix timestamps (in nanoseconds) of the last heartbeat of the
+    // UDP reader and the last packet received. note that both are
+    // accessed atomically, and must be kept at the start of the
+    // struct to ensure 64-bit alignment on 32-bit platforms
+    heartbeat  int64
+    lastPacket int64
+
     // UDP socket to listen for packets
     Socket		  *net.UDPConn
     ListenAddress *net.UDPAddr
 
     // hermes config containing data about metrics
     Config 		  HermesConfig
+
+    // port used to serve prometheus metrics. the
+    // prometheus interface is disabled if set to 0
+    PrometheusPort int
+    // max size of datagrams (up to 64KB) and size of the kernel
+    // receive buffer of the UDP socket. the kernel default is
+    // used if the read buffer size is not set
+    MaxPacketSize  int
+    ReadBufferSize int
+    // max size of compressed datagrams once decompressed
+    MaxDecompressedSize int
+
+    // number of workers used to process packets, along with the
+    // depth of the packet queue and the policy applied when the
+    // queue is full (drop_newest, drop_oldest or block)
+    Workers    int
+    QueueSize  int
+    DropPolicy string
+
+    // optional address used to receive newline delimited
+    // JSON packets over TCP, and optional path and type
+    // (unix or unixgram) of unix domain socket listener
+    TCPAddress     string
+    UnixSocketPath string
+    UnixSocketType string
+    // stream connections are closed once idle for longer than the
+    // idle timeout, and connections exceeding the max number of
+    // connections are rejected. defaults are used if not set
+    StreamIdleTimeout    time.Duration
+    MaxStreamConnections int
+
+    // optional path of file used to capture all incoming
+    // datagrams, which can be replayed with hermes replay. the
+    // capture file is rotated once it exceeds the max size
+    CapturePath     string
+    CaptureMaxSize  int
+    CaptureMaxFiles int
+
+    // optional TLS and basic auth settings used to
+    // protect the prometheus interface
+    PrometheusTLS       *TLSConfig
+    PrometheusBasicAuth *BasicAuthConfig
+    // optional token used to protect the admin API, which is
+    // served on the prometheus interface. the admin API is
+    // disabled if no token is set
+    AdminToken          string
+
+    setup  sync.Once
+    closed int32
+    ready  int32
+    done   chan struct{}
+    // closed once the server has been closed completely
+    finished chan struct{}
+
+    queue  chan queuedPacket
+
+    // number of open stream connections, and paths of unix
+    // sockets that are removed once the server is closed
+    streamConns int32
+    socketsLock sync.Mutex
+    sockets     []string
+
+    pushgateway *PushgatewayExporter
+    // authenticator used to verify packet signatures
+    authenticator *Authenticator
+    // optional sink used to record rejected packets
+    deadLetters   DeadLetterSink
+    // broker used to stream processed packets to tail subscribers
+    tail          *TailBroker
+    // optional writer used to capture incoming datagrams
+    capture       *CaptureWriter
+    // optional relay used to forward packets to an upstream server
+    relay         *Relay
+    // optional aggregator used to aggregate updates over flush windows
+    aggregator    *Aggregator
 }
 
 // function used to create new hermes service instance
@@ -25,48 +118,274 @@ func New(configPath, listenAddress string, listenPort int) *HermesServer {
         panic(fmt.Errorf("unable to load hermes config from path: %s: %+v", configPath,
             err))
     }
+    server, err := NewWithConfig(cfg, listenAddress, listenPort)
+    if err != nil {
+        log.Fatal(fmt.Errorf("unable to start new hermes server: %v", err))
+    }
+    return server
+}
+
+// function used to create new hermes service instance from
+// an existing hermes configuration. Note that the listen port
+// can be set to 0 to listen on an ephemeral port, in which
+// case the bound address can be retrieved from the socket
+func NewWithConfig(cfg HermesConfig, listenAddress string, listenPort int) (*HermesServer, error) {
     // generate new UDP address instance and socket to listen on
     addr := net.UDPAddr{IP: net.ParseIP(listenAddress), Port: listenPort}
     socket, err := net.ListenUDP("udp", &addr)
     if err != nil {
-        log.Fatal(fmt.Errorf("unable to start new hermes server: %v", err))
+        return nil, err
+    }
+    server := &HermesServer{Socket: socket, ListenAddress: &addr, Config: cfg,
+        PrometheusPort: DefaultPrometheusPort, MaxPacketSize: DefaultMaxPacketSize,
+        done: make(chan struct{}), finished: make(chan struct{})}
+    if cfg.Auth != nil {
+        server.authenticator = NewAuthenticator(*cfg.Auth)
+    }
+    return server, nil
+}
+
+// function used to initialize metrics from hermes config
+// and start prometheus server. Note that the setup is only
+// executed once, even if the server is restarted
+func(server *HermesServer) Setup() {
+    server.setup.Do(func() {
+        // create prometheus metric objects from configuration
+        InitializeMetrics(server.Config)
+        // create sink used to record rejected packets if configured
+        if server.Config.DeadLetter != nil {
+            sink, err := NewDeadLetterSink(*server.Config.DeadLetter)
+            if err != nil {
+                log.Fatal(fmt.Errorf("unable to create dead-letter sink: %v", err))
+            }
+            server.deadLetters = sink
+        }
+        server.tail = NewTailBroker()
+        // forward packets to upstream hermes server if configured
+        if server.Config.Relay != nil {
+            relay, err := NewRelay(*server.Config.Relay)
+            if err != nil {
+                log.Fatal(fmt.Errorf("unable to create relay: %v", err))
+            }
+            server.relay = relay
+            go relay.Run(server.done)
+        }
+        // capture incoming datagrams if configured
+        if len(server.CapturePath) > 0 {
+            capture, err := NewRotatingCaptureWriter(server.CapturePath, server.CaptureMaxSize,
+                server.CaptureMaxFiles)
+            if err != nil {
+                log.Fatal(fmt.Errorf("unable to create capture file: %v", err))
+            }
+            log.Info(fmt.Sprintf("capturing incoming datagrams to %s", server.CapturePath))
+            server.capture = capture
+            go capture.FlushPeriodically(server.done)
+        }
+        // aggregate updates over flush windows if configured. note
+        // that sets (including sets registered dynamically) are only
+        // exposed through the aggregator
+        if server.Config.Aggregation != nil || len(server.Config.Sets) > 0 || server.Config.DynamicMetrics != nil {
+            aggregator, err := NewAggregator(server.Config)
+            if err != nil {
+                log.Fatal(fmt.Errorf("unable to create aggregator: %v", err))
+            }
+            server.aggregator = aggregator
+        }
+        // start workers used to process datagrams
+        server.startWorkers()
+        // start HTTP Prometheus server on goroutine
+        if server.PrometheusPort > 0 {
+            go server.ListenPrometheus()
+        }
+        // start TCP and unix socket listeners if configured
+        if len(server.TCPAddress) > 0 {
+            go func() {
+                if err := server.ListenTCP(server.TCPAddress); err != nil {
+                    log.Fatal(fmt.Errorf("unable to start TCP interface: %v", err))
+                }
+            }()
+        }
+        if len(server.UnixSocketPath) > 0 {
+            go func() {
+                if err := server.ListenUnix(server.UnixSocketPath, server.UnixSocketType); err != nil {
+                    log.Fatal(fmt.Errorf("unable to start unix socket interface: %v", err))
+                }
+            }()
+        }
+        // periodically persist state to state file if configured
+        if server.hasStateFile() {
+            go SnapshotStatePeriodically(*server.Config.State, server.done)
+        }
+        // push metrics to remote write endpoint if configured
+        if server.Config.RemoteWrite != nil {
+            go NewRemoteWriteExporter(*server.Config.RemoteWrite).Run(server.done)
+        }
+        // write metrics to node_exporter textfile if configured
+        if server.Config.Textfile != nil {
+            go WriteTextfilePeriodically(*server.Config.Textfile, server.done)
+        }
+        // push metrics to pushgateway if configured
+        if server.Config.Pushgateway != nil {
+            server.pushgateway = NewPushgatewayExporter(*server.Config.Pushgateway,
+                server.Config.ServiceName)
+            go server.pushgateway.Run(server.done)
+        }
+        if server.aggregator != nil {
+            if server.aggregator.Config.PushOnFlush {
+                server.aggregator.OnFlush = server.pushAggregates
+            }
+            go server.aggregator.Run(server.done)
+        }
+        atomic.StoreInt32(&server.ready, 1)
+    })
+}
+
+// function used to stop hermes server. the UDP socket is
+// closed and the listener returns without being restarted.
+// a final snapshot of the state is written and metrics are
+// pushed to the pushgateway a final time if configured. queued
+// packets are forwarded to the upstream server if relaying
+func(server *HermesServer) Close() error {
+    if !atomic.CompareAndSwapInt32(&server.closed, 0, 1) {
+        return nil
     }
-    return &HermesServer{Socket: socket, ListenAddress: &addr, Config: cfg}
+    // signal listeners waiting for the server to close once
+    // the shutdown has completed
+    defer close(server.finished)
+    close(server.done)
+    // stop receiving packets and flush captured packets before the
+    // remaining shutdown steps, which can take a while when relaying
+    err := server.Socket.Close()
+    server.removeSockets()
+    if server.capture != nil {
+        if err := server.capture.Close(); err != nil {
+            log.Error(fmt.Errorf("unable to close capture file: %v", err))
+        }
+    }
+    // only persist state if metrics have been initialized
+    if server.hasStateFile() && atomic.LoadInt32(&server.ready) == 1 {
+        if err := SaveState(server.Config.State.Path); err != nil {
+            log.Error(fmt.Errorf("unable to save hermes state: %v", err))
+        }
+    }
+    if atomic.LoadInt32(&server.ready) == 1 && server.pushgateway != nil {
+        if err := server.pushgateway.Push(); err != nil {
+            log.Error(fmt.Errorf("unable to push metrics to pushgateway: %v", err))
+        }
+    }
+    // wait for relay to forward (or spool) all queued packets
+    if atomic.LoadInt32(&server.ready) == 1 && server.relay != nil {
+        server.relay.Wait()
+    }
+    if atomic.LoadInt32(&server.ready) == 1 && server.deadLetters != nil {
+        if err := server.deadLetters.Close(); err != nil {
+            log.Error(fmt.Errorf("unable to close dead-letter sink: %v", err))
+        }
+    }
+    return err
+}
+
+// function used to determine if a state file is configured
+func(server *HermesServer) hasStateFile() bool {
+    return server.Config.State != nil && len(server.Config.State.Path) > 0
+}
+
+// function used to determine if server has been closed
+func(server *HermesServer) IsClosed() bool {
+    return atomic.LoadInt32(&server.closed) == 1
 }
 
 // function used to start listening on the specified UDP
 // ports for JSON messages from a Hermes client. All incoming
-// messages are read into a buffer and then converted to
-// JSON format by the handler function
+// messages are read into a buffer and then handed to the
+// packet workers, which convert them to JSON format. Once the
+// server is closed, Listen returns after Close has completed
 func(server *HermesServer) Listen() {
     log.Info(fmt.Sprintf("starting new UDP interface at %+v...", server.ListenAddress))
     // restart hermes socket if any panic issues arise during processing of messages
     defer func() {
-        if r := recover(); r != nil {
+        if r := recover(); r != nil && !server.IsClosed() {
             log.Warn(fmt.Sprintf("recovered paniced UDP interface: %+v", r))
             server.RestartServerGracefully()
         }
     }()
     // defer closing of connection
     defer server.Socket.Close()
-    // start HTTP Prometheus server on goroutine
-    go ListenPrometheus(server.Config, 8080)
+    // initialize metrics and start prometheus server
+    server.Setup()
 
+    // set size of kernel receive buffer to reduce drops during bursts
+    if server.ReadBufferSize > 0 {
+        if err := server.Socket.SetReadBuffer(server.ReadBufferSize); err != nil {
+            log.Error(fmt.Errorf("unable to set UDP read buffer size: %v", err))
+        }
+    }
     // create new buffer and serve messages
-    buffer := make([]byte, 2048)
+    buffer := server.newPacketBuffer()
+    var deadline time.Time
     for {
+        // reads time out after the heartbeat interval, so that the
+        // reader can report that it is alive while no packets arrive.
+        // the deadline is only reset once per heartbeat interval
+        if now := time.Now(); !now.Before(deadline) {
+            server.beat()
+            deadline = now.Add(HeartbeatInterval)
+            server.Socket.SetReadDeadline(deadline)
+        }
         // read UDP packet payload into buffer
         n, remoteAddr, err := server.Socket.ReadFromUDP(buffer)
-        log.Debug(fmt.Sprintf("processing new message from %+v", remoteAddr))
         if err != nil {
+            // stop listening once server has been closed completely
+            if server.IsClosed() {
+                <-server.finished
+                log.Info("hermes server closed. stopping UDP interface")
+                return
+            }
+            if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+                continue
+            }
             log.Error(fmt.Errorf("unable to process UDP message: %v", err))
             continue
         }
-        // handle UDP packet
-        server.ProcessPayload(buffer[0:n])
+        log.Debug(fmt.Sprintf("processing new message from %+v", remoteAddr))
+        if server.isTruncated(n, "udp", remoteAddr) {
+            continue
+        }
+        server.receive(buffer[0:n], remoteAddr)
     }
 }
 
+// function used to retrieve max size of datagrams
+func(server *HermesServer) maxPacketSize() int {
+    size := server.MaxPacketSize
+    if size <= 0 {
+        size = DefaultMaxPacketSize
+    }
+    if size > MaxPacketSizeLimit {
+        size = MaxPacketSizeLimit
+    }
+    return size
+}
+
+// function used to allocate buffer used to read datagrams. the
+// buffer is one byte larger than the max packet size, so that
+// larger (truncated) datagrams can be detected
+func(server *HermesServer) newPacketBuffer() []byte {
+    return make([]byte, server.maxPacketSize() + 1)
+}
+
+// function used to determine if a datagram exceeded the max packet
+// size. truncated datagrams are counted in the self metrics
+func(server *HermesServer) isTruncated(n int, transport string, remoteAddr net.Addr) bool {
+    if n <= server.maxPacketSize() {
+        return false
+    }
+    log.Warn(fmt.Sprintf("dropping truncated %s datagram from %v: datagram exceeds max packet size of %d bytes",
+        transport, remoteAddr, server.maxPacketSize()))
+    TruncatedPackets.WithLabelValues(transport).Inc()
+    return true
+}
+
 // function used to safely restart hermes server. the UDP connection
 // is first closed via the socket connection. The connection is then
 // re-established. If the re-creation of the socket fails, the go-routine
@@ -96,68 +415,156 @@ func(server *HermesServer) RestartServerGracefully() {
 // is sent with all JSON packets, which is then used to determine
 // the type of metric that the JSON packet corresponds to (i.e.
 // counter or gauge) and the payload is then processed depending on
-// the type of metric
-func(server *HermesServer) ProcessPayload(packet []byte) {
-    log.Debug(fmt.Sprintf("processing new hermes payload %s", string(packet)))
-    var payload HermesPayload
-    err := json.Unmarshal(packet, &payload)
+// the type of metric. the remote address of the packet is used to
+// enforce per-metric access control. Note that the payload is kept
+// in its raw form when the packet is decoded, and is then decoded
+// only once into the typed payload of the metric. packets can be
+// sent either in JSON format or with the binary protocol, and
+// batches of packets can be sent as compressed datagrams
+func(server *HermesServer) ProcessPayload(packet []byte, remoteAddr net.Addr) {
+    if algorithm := CompressionAlgorithm(packet); len(algorithm) > 0 {
+        server.processCompressed(packet, algorithm, remoteAddr)
+        return
+    }
+    server.processPacket(packet, remoteAddr)
+}
+
+// function used to process a single uncompressed packet
+func(server *HermesServer) processPacket(packet []byte, remoteAddr net.Addr) {
+    debug := log.IsLevelEnabled(log.DebugLevel)
+    if debug {
+        log.Debug(fmt.Sprintf("processing new hermes payload %s", string(packet)))
+    }
+    payload, err := DecodePacket(packet)
     if err != nil {
         log.Error(fmt.Errorf("unable to parse udp packet to required JSON format: %v", err))
+        server.reject(packet, nil, remoteAddr, ReasonInvalidPacket, err)
         return
     }
+    // verify packet signature if authentication is configured
+    var keyID string
+    if server.authenticator != nil {
+        id, err := server.authenticator.Authenticate(payload)
+        if err != nil {
+            log.Warn(fmt.Sprintf("rejecting unauthenticated packet from %v: %v", remoteAddr, err))
+            server.reject(packet, &payload, remoteAddr, ReasonUnauthenticated, err)
+            return
+        }
+        keyID = id
+    }
+    // register metric from definition if dynamic metrics are enabled
+    if payload.Definition != nil && Config != nil && Config.DynamicMetrics != nil {
+        server.registerDefinition(payload, keyID, remoteAddr)
+    }
+    metricsLock.RLock()
+    defer metricsLock.RUnlock()
     // determine metric type based on metric name from local mappings of metrics
     metricType, err := GetMetricType(payload.MetricName)
     if err != nil {
         log.Error(fmt.Sprintf("cannot process metric %s: metric not registered", payload.MetricName))
+        server.reject(packet, &payload, remoteAddr, ReasonUnregisteredMetric, err)
         return
     }
-
-    bytesPayload, _ := json.Marshal(payload.Payload)
-    // process payload depending on metric type
+    // ensure that client is allowed to update metric
+    if !IsAllowed(payload.MetricName, keyID, remoteAddr) {
+        log.Warn(fmt.Sprintf("rejecting update of metric %s from %v (key '%s'): access denied",
+            payload.MetricName, remoteAddr, keyID))
+        AccessDenied.WithLabelValues(payload.MetricName).Inc()
+        server.reject(packet, &payload, remoteAddr, ReasonAccessDenied, nil)
+        return
+    }
+    if debug && !payload.Binary {
+        log.Debug(fmt.Sprintf("processing '%s' payload %s", metricType, string(payload.Payload)))
+    }
+    // process payload depending on metric type. the decoded payload
+    // is only kept if it is published to tail subscribers
+    var decoded interface{}
     switch metricType {
 
     // process counter metrics
     case "counter":
-        log.Debug(fmt.Sprintf("processing 'counter' payload %+v", payload.Payload))
         var counter CounterJSON
-        err := json.Unmarshal(bytesPayload, &counter)
-        if err != nil {
-            log.Error(fmt.Sprintf("cannot process 'counter' metric. invalid JSON"))
+        if counter, err = DecodeCounter(payload); err != nil {
+            log.Error(fmt.Sprintf("cannot process 'counter' metric. invalid payload"))
+            server.reject(packet, &payload, remoteAddr, ReasonInvalidPayload, err)
             return
         }
-        IncrementCounter(payload.MetricName, counter)
+        if server.relay != nil {
+            err = server.relay.ForwardCounter(payload, counter)
+        } else if err = IncrementCounter(payload.MetricName, counter); err == nil {
+            server.aggregator.Increment(payload.MetricName, counter.Labels, counter.Increment())
+        }
+        if server.tail.Active() {
+            decoded = counter
+        }
 
     // process gauge metrics
     case "gauge":
-        log.Debug(fmt.Sprintf("processing 'gauge' payload %+v", payload.Payload))
         var gauge GaugeJSON
-        err := json.Unmarshal(bytesPayload, &gauge)
-        if err != nil {
-            log.Error(fmt.Sprintf("cannot process 'gauge' metric. invalid JSON"))
+        if gauge, err = DecodeGauge(payload); err != nil {
+            log.Error(fmt.Sprintf("cannot process 'gauge' metric. invalid payload"))
+            server.reject(packet, &payload, remoteAddr, ReasonInvalidPayload, err)
             return
         }
-        ProcessGauge(payload.MetricName, gauge)
+        if server.relay != nil {
+            err = server.relay.ForwardGauge(payload, gauge)
+        } else {
+            err = ProcessGauge(payload.MetricName, gauge)
+        }
+        if server.tail.Active() {
+            decoded = gauge
+        }
 
     // process histogram metrics
     case "histogram":
-        log.Debug(fmt.Sprintf("processing 'histogram' payload %+v", payload.Payload))
         var histogram HistogramJSON
-        err := json.Unmarshal(bytesPayload, &histogram)
-        if err != nil {
-            log.Error(fmt.Sprintf("cannot process 'histogram' metric. invalid JSON"))
+        if histogram, err = DecodeHistogram(payload); err != nil {
+            log.Error(fmt.Sprintf("cannot process 'histogram' metric. invalid payload"))
+            server.reject(packet, &payload, remoteAddr, ReasonInvalidPayload, err)
             return
         }
-        ObserveHistogram(payload.MetricName, histogram)
+        if server.relay != nil {
+            err = server.relay.ForwardHistogram(payload, histogram)
+        } else if err = ObserveHistogram(payload.MetricName, histogram); err == nil {
+            server.aggregator.Observe(payload.MetricName, histogram.Labels, histogram.Observation)
+        }
+        if server.tail.Active() {
+            decoded = histogram
+        }
 
     // process summary metrics
     case "summary":
-        log.Debug(fmt.Sprintf("processing 'summary' payload %+v", payload.Payload))
         var summary SummaryJSON
-        err := json.Unmarshal(bytesPayload, &summary)
-        if err != nil {
-            log.Error(fmt.Sprintf("cannot process 'summary' metric. invalid JSON"))
+        if summary, err = DecodeSummary(payload); err != nil {
+            log.Error(fmt.Sprintf("cannot process 'summary' metric. invalid payload"))
+            server.reject(packet, &payload, remoteAddr, ReasonInvalidPayload, err)
+            return
+        }
+        if server.relay != nil {
+            err = server.relay.ForwardSummary(payload, summary)
+        } else if err = ObserveSummary(payload.MetricName, summary); err == nil {
+            server.aggregator.Observe(payload.MetricName, summary.Labels, summary.Observation)
+        }
+        if server.tail.Active() {
+            decoded = summary
+        }
+
+    // process set metrics
+    case "set":
+        var set SetJSON
+        if set, err = DecodeSet(payload); err != nil {
+            log.Error(fmt.Sprintf("cannot process 'set' metric. invalid payload"))
+            server.reject(packet, &payload, remoteAddr, ReasonInvalidPayload, err)
             return
         }
-        ObserveSummary(payload.MetricName, summary)
+        if server.relay != nil {
+            err = server.relay.ForwardSet(payload, set)
+        } else {
+            err = server.aggregator.AddToSet(payload.MetricName, set)
+        }
+        if server.tail.Active() {
+            decoded = set
+        }
     }
-}
\ No newline at end of file
+    server.completeUpdate(packet, &payload, remoteAddr, decoded, err)
+}
@@ -0,0 +1,143 @@
+package hermes
+
+import (
+    "os"
+    "testing"
+    "io/ioutil"
+
+    "github.com/prometheus/client_golang/prometheus"
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+// function used to initialize metrics of the given config on a new
+// registry. metrics are reset once the test has completed
+func initTestMetrics(t *testing.T, config HermesConfig) *prometheus.Registry {
+    registry := prometheus.NewRegistry()
+    UseRegistry(registry)
+    if err := InitializeMetrics(config); err != nil {
+        t.Fatalf("unable to initialize metrics: %v", err)
+    }
+    t.Cleanup(ResetMetrics)
+    return registry
+}
+
+// function used to create temporary directory that is
+// removed once the test has completed
+func tempDir(t *testing.T) string {
+    dir, err := ioutil.TempDir("", "hermes")
+    if err != nil {
+        t.Fatalf("unable to create temporary directory: %v", err)
+    }
+    t.Cleanup(func() { os.RemoveAll(dir) })
+    return dir
+}
+
+// function used to retrieve value of a counter or gauge series
+func seriesValue(t *testing.T, registry *prometheus.Registry, name string,
+    labels map[string]string) (float64, bool) {
+    families, err := registry.Gather()
+    if err != nil {
+        t.Fatalf("unable to gather metrics: %v", err)
+    }
+    for _, family := range(families) {
+        if family.GetName() != name {
+            continue
+        }
+        for _, metric := range(family.GetMetric()) {
+            matches := len(metric.GetLabel()) == len(labels)
+            for _, pair := range(metric.GetLabel()) {
+                if labels[pair.GetName()] != pair.GetValue() {
+                    matches = false
+                }
+            }
+            if !matches {
+                continue
+            }
+            if metric.GetCounter() != nil {
+                return metric.GetCounter().GetValue(), true
+            }
+            return metric.GetGauge().GetValue(), true
+        }
+    }
+    return 0, false
+}
+
+// define config of metrics used to test packet processing
+var processTestConfig = HermesConfig{
+    Counters: []HermesCounter{
+        {MetricName: "requests_total", Labels: []string{"app"}},
+        {MetricName: "events_total"},
+    },
+    Histograms: []HermesHistogram{
+        {MetricName: "request_duration", Labels: []string{"app"}},
+    },
+}
+
+// test that packets are processed into metric updates
+func TestProcessPayload(t *testing.T) {
+    tests := []struct {
+        name   string
+        packet []byte
+        metric string
+        labels map[string]string
+    }{
+        {"json counter", []byte(`{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}`),
+            "requests_total", map[string]string{"app": "web"}},
+        {"json counter without payload", []byte(`{"metric_name": "events_total"}`),
+            "events_total", map[string]string{}},
+        {"json counter with null payload", []byte(`{"metric_name": "events_total", "payload": null}`),
+            "events_total", map[string]string{}},
+        {"binary counter", protocol.Packet{MetricName: "requests_total",
+            Payload: protocol.Counter{Labels: map[string]string{"app": "api"}}.Marshal()}.Marshal(),
+            "requests_total", map[string]string{"app": "api"}},
+        {"binary counter without payload", protocol.Packet{MetricName: "events_total"}.Marshal(),
+            "events_total", map[string]string{}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            registry := initTestMetrics(t, processTestConfig)
+            server := &HermesServer{}
+            server.ProcessPayload(test.packet, nil)
+            if got, _ := seriesValue(t, registry, test.metric, test.labels); got != 1 {
+                t.Fatalf("expected %s %v to be 1 but got %g", test.metric, test.labels, got)
+            }
+        })
+    }
+}
+
+// benchmark processing of packets, alternating between counter
+// increments and histogram observations. run with
+// go test ./pkg/hermes -run ^$ -bench ProcessPayload -benchmem
+func BenchmarkProcessPayload(b *testing.B) {
+    labels := map[string]string{"app": "web"}
+    packets := map[string][][]byte{
+        "json": {
+            []byte(`{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}`),
+            []byte(`{"metric_name": "request_duration", "payload": {"labels": {"app": "web"}, "observation": 0.25}}`),
+        },
+        "binary": {
+            protocol.Packet{MetricName: "requests_total",
+                Payload: protocol.Counter{Labels: labels}.Marshal()}.Marshal(),
+            protocol.Packet{MetricName: "request_duration",
+                Payload: protocol.Histogram{Labels: labels, Observation: 0.25}.Marshal()}.Marshal(),
+        },
+    }
+    level := log.GetLevel()
+    log.SetLevel(log.WarnLevel)
+    defer log.SetLevel(level)
+    for _, encoding := range([]string{"json", "binary"}) {
+        b.Run(encoding, func(b *testing.B) {
+            UseRegistry(prometheus.NewRegistry())
+            defer ResetMetrics()
+            InitializeMetrics(processTestConfig)
+            server := &HermesServer{}
+            b.ReportAllocs()
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                server.ProcessPayload(packets[encoding][i % 2], nil)
+            }
+        })
+    }
+}
@@ -9,12 +9,26 @@ import (
 // function used to make an observation on a particular histogram
 func ObserveHistogram(name string, histogramJson HistogramJSON) error {
     if histogram, ok := Histograms[name]; ok {
-        log.Info(fmt.Sprintf("making histogram observation %f on '%s' %v", histogramJson.Observation, name, histogram))
+        log.Debug(fmt.Sprintf("making histogram observation %f on '%s' %v", histogramJson.Observation, name, histogram))
         // generate labels for prometheus metric and check for errors
         labels, err := GenerateLabels(histogramJson.Labels, "histogram", name)
         if err != nil {
             return err
         }
+        // attach exemplar to observation if provided
+        if histogramJson.Exemplar != nil {
+            exemplar, err := GenerateExemplarLabels(*histogramJson.Exemplar)
+            if err != nil {
+                return err
+            }
+            // the value of histogram exemplars is always the observation
+            if value := histogramJson.Exemplar.Value; value != nil && *value != histogramJson.Observation {
+                return ErrInvalidExemplar
+            }
+            histogram.With(labels).(prometheus.ExemplarObserver).ObserveWithExemplar(
+                histogramJson.Observation, exemplar)
+            return nil
+        }
         histogram.With(labels).Observe(histogramJson.Observation)
         return nil
     }
@@ -26,11 +40,25 @@ func ObserveHistogram(name string, histogramJson HistogramJSON) error {
 // maps the name of the histogram/metric to the prometheus pointer
 // that stores the metrics themselves
 func NewHistogram(histogram HermesHistogram) error {
+    if err := ValidateReservedLabels("histogram", histogram.Labels); err != nil {
+        return err
+    }
     opts := prometheus.HistogramOpts{Name: histogram.MetricName, Help: histogram.MetricDescription}
+    if len(histogram.Buckets) > 0 {
+        // buckets must be in strictly increasing order
+        for i := 1; i < len(histogram.Buckets); i++ {
+            if histogram.Buckets[i] <= histogram.Buckets[i - 1] {
+                return ErrInvalidBuckets
+            }
+        }
+        opts.Buckets = histogram.Buckets
+    }
     // create new histogram instance
     promHistogram := prometheus.NewHistogramVec(opts, histogram.Labels)
     // register gauge and insert into maps
-    prometheus.MustRegister(promHistogram)
+    if err := Registerer.Register(promHistogram); err != nil {
+        return err
+    }
     Histograms[histogram.MetricName] = promHistogram
     return nil
 }
\ No newline at end of file
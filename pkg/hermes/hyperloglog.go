@@ -0,0 +1,67 @@
+package hermes
+
+import (
+    "math"
+    "hash/fnv"
+    "math/bits"
+)
+
+// define precision of hyperloglog sketches. sketches use 2^precision
+// registers (16KB), with a standard error of 1.04/sqrt(2^precision),
+// i.e. roughly 0.8%
+const hyperLogLogPrecision = 14
+
+// struct used to estimate the number of unique values of a set
+// once the set exceeds the max number of tracked values
+type hyperLogLog struct {
+    registers []uint8
+}
+
+// function used to create new empty hyperloglog sketch
+func newHyperLogLog() *hyperLogLog {
+    return &hyperLogLog{registers: make([]uint8, 1 << hyperLogLogPrecision)}
+}
+
+// function used to add value to sketch. the first bits of the hash
+// select the register, and the register keeps the max position of
+// the first set bit of the remaining bits
+func(h *hyperLogLog) add(value string) {
+    hash := hashValue(value)
+    index := hash >> (64 - hyperLogLogPrecision)
+    // set guard bit so that the rank is bounded by the remaining bits
+    rank := uint8(bits.LeadingZeros64(hash << hyperLogLogPrecision | 1 << (hyperLogLogPrecision - 1))) + 1
+    if rank > h.registers[index] {
+        h.registers[index] = rank
+    }
+}
+
+// function used to estimate number of unique values added to the
+// sketch. linear counting is used for small cardinalities, where
+// the raw hyperloglog estimate is biased
+func(h *hyperLogLog) count() float64 {
+    m := float64(len(h.registers))
+    sum, zeros := 0.0, 0
+    for _, register := range(h.registers) {
+        sum += math.Ldexp(1, -int(register))
+        if register == 0 {
+            zeros++
+        }
+    }
+    estimate := 0.7213 / (1 + 1.079 / m) * m * m / sum
+    if estimate <= 2.5 * m && zeros > 0 {
+        estimate = m * math.Log(m / float64(zeros))
+    }
+    return estimate
+}
+
+// function used to compute 64-bit hash of value. the FNV hash is
+// mixed with the splitmix64 finalizer, since the sketch requires
+// all bits of the hash to be uniformly distributed
+func hashValue(value string) uint64 {
+    hasher := fnv.New64a()
+    hasher.Write([]byte(value))
+    hash := hasher.Sum64()
+    hash = (hash ^ (hash >> 30)) * 0xbf58476d1ce4e5b9
+    hash = (hash ^ (hash >> 27)) * 0x94d049bb133111eb
+    return hash ^ (hash >> 31)
+}
@@ -0,0 +1,263 @@
+package hermes
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "net"
+    "time"
+    "bufio"
+    "errors"
+    "sync/atomic"
+    "encoding/binary"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+var (
+    // define max size of newline delimited packets
+    // received over stream listeners
+    MaxStreamPacketSize = 1024 * 1024
+    // define defaults of stream connections. connections are closed
+    // once no packet has been received within the idle timeout
+    DefaultStreamIdleTimeout    = 5 * time.Minute
+    DefaultMaxStreamConnections = 1024
+
+    ErrStreamPacketTooLarge = errors.New("Stream packet exceeds max packet size")
+)
+
+// function used to start listening for newline delimited JSON
+// (or length prefixed binary) packets over TCP. each connection is handled on a separate
+// goroutine and all packets are handed to the packet workers
+func(server *HermesServer) ListenTCP(address string) error {
+    listener, err := net.Listen("tcp", address)
+    if err != nil {
+        return err
+    }
+    log.Info(fmt.Sprintf("starting new TCP interface at %s...", listener.Addr()))
+    server.serveStream(listener)
+    return nil
+}
+
+// function used to start listening on a unix domain socket. stream
+// sockets ("unix") expect newline delimited JSON packets, while
+// datagram sockets ("unixgram") expect a single packet per datagram.
+// see handleStream for the framing of binary packets on streams.
+// any stale socket file is removed before binding, and the socket
+// file is removed once the server is closed
+func(server *HermesServer) ListenUnix(path, network string) error {
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    log.Info(fmt.Sprintf("starting new %s interface at %s...", network, path))
+    switch network {
+    case "unix":
+        listener, err := net.Listen("unix", path)
+        if err != nil {
+            return err
+        }
+        server.addSocket(path)
+        server.serveStream(listener)
+    case "unixgram":
+        conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+        if err != nil {
+            return err
+        }
+        server.addSocket(path)
+        server.serveDatagrams(conn)
+    default:
+        return fmt.Errorf("invalid unix socket type '%s'", network)
+    }
+    return nil
+}
+
+// function used to register unix socket file that is removed
+// once the server is closed. the file is removed immediately
+// if the server has already been closed
+func(server *HermesServer) addSocket(path string) {
+    server.socketsLock.Lock()
+    server.sockets = append(server.sockets, path)
+    server.socketsLock.Unlock()
+    if server.IsClosed() {
+        server.removeSockets()
+    }
+}
+
+// function used to remove all unix socket files
+func(server *HermesServer) removeSockets() {
+    server.socketsLock.Lock()
+    defer server.socketsLock.Unlock()
+    for _, path := range(server.sockets) {
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            log.Error(fmt.Errorf("unable to remove unix socket %s: %v", path, err))
+        }
+    }
+    server.sockets = nil
+}
+
+// function used to retrieve idle timeout of stream connections
+func(server *HermesServer) streamIdleTimeout() time.Duration {
+    if server.StreamIdleTimeout <= 0 {
+        return DefaultStreamIdleTimeout
+    }
+    return server.StreamIdleTimeout
+}
+
+// function used to retrieve max number of stream connections
+func(server *HermesServer) maxStreamConnections() int32 {
+    if server.MaxStreamConnections <= 0 {
+        return int32(DefaultMaxStreamConnections)
+    }
+    return int32(server.MaxStreamConnections)
+}
+
+// function used to accept connections on a stream listener until
+// the server is closed. connections exceeding the max number of
+// connections (across all stream listeners) are closed immediately
+func(server *HermesServer) serveStream(listener net.Listener) {
+    go func() {
+        <-server.done
+        listener.Close()
+    }()
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            if server.IsClosed() {
+                return
+            }
+            log.Error(fmt.Errorf("unable to accept connection: %v", err))
+            continue
+        }
+        if atomic.AddInt32(&server.streamConns, 1) > server.maxStreamConnections() {
+            atomic.AddInt32(&server.streamConns, -1)
+            log.Warn(fmt.Sprintf("rejecting connection from %v: max number of %d connections reached",
+                conn.RemoteAddr(), server.maxStreamConnections()))
+            conn.Close()
+            continue
+        }
+        go server.handleStream(conn)
+    }
+}
+
+// function used to read packets from a stream connection until
+// the connection is closed. JSON packets are newline delimited,
+// while binary packets are framed by the binary magic byte and
+// the uvarint encoded length of the remainder of the packet.
+// packets are handed to the packet workers in the same way as
+// datagrams, so that the queue policy applies to streams as well.
+// connections are closed if no packet is received within the idle
+// timeout, where the deadline is reset before each packet
+func(server *HermesServer) handleStream(conn net.Conn) {
+    defer atomic.AddInt32(&server.streamConns, -1)
+    defer conn.Close()
+    // connections of unbound unix socket clients may have no
+    // source address, and are attributed to the socket instead
+    remoteAddr := conn.RemoteAddr()
+    if remoteAddr == nil {
+        remoteAddr = conn.LocalAddr()
+    }
+    log.Debug(fmt.Sprintf("accepted new connection from %v", remoteAddr))
+    // close connection when server is closed
+    closed := make(chan struct{})
+    defer close(closed)
+    go func() {
+        select {
+        case <-server.done:
+            conn.Close()
+        case <-closed:
+        }
+    }()
+    reader := bufio.NewReaderSize(conn, 4096)
+    for {
+        conn.SetReadDeadline(time.Now().Add(server.streamIdleTimeout()))
+        packet, err := readStreamPacket(reader)
+        if err != nil {
+            var netErr net.Error
+            if errors.As(err, &netErr) && netErr.Timeout() {
+                log.Debug(fmt.Sprintf("closing idle connection from %v", remoteAddr))
+                return
+            }
+            if err != io.EOF && !server.IsClosed() {
+                log.Error(fmt.Errorf("unable to read from connection %v: %v", remoteAddr, err))
+            }
+            return
+        }
+        if len(packet) > 0 {
+            server.receive(packet, remoteAddr)
+        }
+    }
+}
+
+// function used to read a single packet from a stream. note
+// that io.EOF is only returned if the stream ends between packets,
+// while truncated packets return io.ErrUnexpectedEOF
+func readStreamPacket(reader *bufio.Reader) ([]byte, error) {
+    first, err := reader.Peek(1)
+    if err != nil {
+        return nil, err
+    }
+    // read length prefixed binary packet
+    if protocol.IsBinary(first) {
+        reader.ReadByte()
+        length, err := binary.ReadUvarint(reader)
+        if err == io.EOF {
+            return nil, io.ErrUnexpectedEOF
+        }
+        if err != nil {
+            return nil, err
+        }
+        if length >= uint64(MaxStreamPacketSize) {
+            return nil, ErrStreamPacketTooLarge
+        }
+        packet := make([]byte, length + 1)
+        packet[0] = protocol.BinaryMagic
+        _, err = io.ReadFull(reader, packet[1:])
+        return packet, err
+    }
+    // read newline delimited JSON packet
+    var line []byte
+    for {
+        chunk, isPrefix, err := reader.ReadLine()
+        if err != nil {
+            return nil, err
+        }
+        line = append(line, chunk...)
+        if len(line) > MaxStreamPacketSize {
+            return nil, ErrStreamPacketTooLarge
+        }
+        if !isPrefix {
+            return line, nil
+        }
+    }
+}
+
+// function used to read packets from a datagram connection
+// until the server is closed
+func(server *HermesServer) serveDatagrams(conn *net.UnixConn) {
+    go func() {
+        <-server.done
+        conn.Close()
+    }()
+    buffer := server.newPacketBuffer()
+    for {
+        n, remoteAddr, err := conn.ReadFrom(buffer)
+        if err != nil {
+            if server.IsClosed() {
+                return
+            }
+            log.Error(fmt.Errorf("unable to process unix datagram: %v", err))
+            continue
+        }
+        // datagrams of unbound clients have no source address, and
+        // are attributed to the socket instead
+        if remoteAddr == nil {
+            remoteAddr = conn.LocalAddr()
+        }
+        if server.isTruncated(n, "unixgram", remoteAddr) {
+            continue
+        }
+        server.receive(buffer[0:n], remoteAddr)
+    }
+}
@@ -0,0 +1,232 @@
+package hermes
+
+import (
+    "io"
+    "os"
+    "net"
+    "time"
+    "bytes"
+    "bufio"
+    "testing"
+    "sync/atomic"
+    "path/filepath"
+    "encoding/binary"
+
+    "github.com/prometheus/client_golang/prometheus"
+
+    "github.com/PSauerborn/hermes/pkg/protocol"
+)
+
+// function used to encode binary length prefix of stream packets
+func lengthPrefix(length int) []byte {
+    prefix := make([]byte, binary.MaxVarintLen64 + 1)
+    prefix[0] = protocol.BinaryMagic
+    n := binary.PutUvarint(prefix[1:], uint64(length))
+    return prefix[:n + 1]
+}
+
+// function used to frame binary packet for stream listeners
+func frameBinary(packet []byte) []byte {
+    return append(lengthPrefix(len(packet) - 1), packet[1:]...)
+}
+
+// test that JSON and binary packets are read from streams
+func TestReadStreamPacket(t *testing.T) {
+    binaryPacket := protocol.Packet{MetricName: "requests_total",
+        Payload: protocol.Counter{Labels: map[string]string{"app": "web"}}.Marshal()}.Marshal()
+    framed := frameBinary(binaryPacket)
+    oversized := lengthPrefix(MaxStreamPacketSize)
+
+    tests := []struct {
+        name     string
+        stream   []byte
+        expected [][]byte
+        err      error
+    }{
+        {"newline delimited JSON", []byte("{\"a\": 1}\n{\"b\": 2}\n"),
+            [][]byte{[]byte(`{"a": 1}`), []byte(`{"b": 2}`)}, io.EOF},
+        {"JSON with carriage returns", []byte("{\"a\": 1}\r\n"), [][]byte{[]byte(`{"a": 1}`)}, io.EOF},
+        {"JSON without trailing newline", []byte(`{"a": 1}`), [][]byte{[]byte(`{"a": 1}`)}, io.EOF},
+        {"empty lines", []byte("\n\n"), [][]byte{{}, {}}, io.EOF},
+        {"uvarint framed binary", framed, [][]byte{binaryPacket}, io.EOF},
+        {"binary and JSON", append(append([]byte{}, framed...), "{\"a\": 1}\n"...),
+            [][]byte{binaryPacket, []byte(`{"a": 1}`)}, io.EOF},
+        {"oversized binary length", append(oversized, 0x00), nil, ErrStreamPacketTooLarge},
+        {"oversized JSON line", append(bytes.Repeat([]byte("a"), MaxStreamPacketSize + 1), '\n'),
+            nil, ErrStreamPacketTooLarge},
+        {"truncated binary frame", framed[:len(framed) - 1], nil, io.ErrUnexpectedEOF},
+        {"truncated binary length", []byte{protocol.BinaryMagic}, nil, io.ErrUnexpectedEOF},
+        {"truncated binary length prefix", []byte{protocol.BinaryMagic, 0x80}, nil, io.ErrUnexpectedEOF},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            reader := bufio.NewReaderSize(bytes.NewReader(test.stream), 4096)
+            var packets [][]byte
+            for {
+                packet, err := readStreamPacket(reader)
+                if err != nil {
+                    if err != test.err {
+                        t.Fatalf("expected error %v but got %v", test.err, err)
+                    }
+                    break
+                }
+                packets = append(packets, packet)
+            }
+            if len(packets) != len(test.expected) {
+                t.Fatalf("expected packets %q but got %q", test.expected, packets)
+            }
+            for i, packet := range(packets) {
+                if !bytes.Equal(packet, test.expected[i]) {
+                    t.Fatalf("expected packets %q but got %q", test.expected, packets)
+                }
+            }
+        })
+    }
+}
+
+// function used to start hermes server used to test stream listeners
+func newStreamTestServer(t *testing.T) (*HermesServer, *prometheus.Registry) {
+    server, err := NewWithConfig(processTestConfig, "127.0.0.1", 0)
+    if err != nil {
+        t.Fatalf("unable to create server: %v", err)
+    }
+    server.PrometheusPort = 0
+    registry := prometheus.NewRegistry()
+    UseRegistry(registry)
+    t.Cleanup(ResetMetrics)
+    // wait for stream connections to be closed before metrics are reset
+    t.Cleanup(func() {
+        server.Close()
+        deadline := time.Now().Add(2 * time.Second)
+        for atomic.LoadInt32(&server.streamConns) > 0 && time.Now().Before(deadline) {
+            time.Sleep(5 * time.Millisecond)
+        }
+    })
+    server.Setup()
+    return server, registry
+}
+
+// function used to serve stream listener on an ephemeral TCP port
+func serveTestStream(t *testing.T, server *HermesServer) string {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("unable to listen: %v", err)
+    }
+    go server.serveStream(listener)
+    return listener.Addr().String()
+}
+
+// function used to determine if a connection has been closed by the
+// server. note that the read fails with a timeout if still open
+func isClosedByServer(conn net.Conn, timeout time.Duration) bool {
+    conn.SetReadDeadline(time.Now().Add(timeout))
+    _, err := conn.Read(make([]byte, 1))
+    if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+        return false
+    }
+    return err != nil
+}
+
+// function used to wait for the test counter to reach a value
+func waitForCounter(t *testing.T, registry *prometheus.Registry, expected float64) {
+    deadline := time.Now().Add(2 * time.Second)
+    for time.Now().Before(deadline) {
+        if value, _ := seriesValue(t, registry, "requests_total", map[string]string{"app": "web"}); value == expected {
+            return
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    t.Fatalf("expected counter to reach %g", expected)
+}
+
+// test that idle connections are closed, and that the idle
+// timeout is reset on every packet
+func TestStreamIdleTimeout(t *testing.T) {
+    server, registry := newStreamTestServer(t)
+    server.StreamIdleTimeout = 200 * time.Millisecond
+    conn, err := net.Dial("tcp", serveTestStream(t, server))
+    if err != nil {
+        t.Fatalf("unable to connect: %v", err)
+    }
+    defer conn.Close()
+
+    packet := []byte(`{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}` + "\n")
+    for i := 1; i <= 3; i++ {
+        time.Sleep(100 * time.Millisecond)
+        if _, err := conn.Write(packet); err != nil {
+            t.Fatalf("unable to write packet: %v", err)
+        }
+    }
+    waitForCounter(t, registry, 3)
+    if !isClosedByServer(conn, time.Second) {
+        t.Fatalf("expected idle connection to be closed")
+    }
+}
+
+// test that connections exceeding the max number of
+// connections are closed, while open connections are kept
+func TestMaxStreamConnections(t *testing.T) {
+    server, registry := newStreamTestServer(t)
+    server.MaxStreamConnections = 1
+    addr := serveTestStream(t, server)
+    first, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatalf("unable to connect: %v", err)
+    }
+    defer first.Close()
+    packet := []byte(`{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}` + "\n")
+    first.Write(packet)
+    waitForCounter(t, registry, 1)
+
+    second, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatalf("unable to connect: %v", err)
+    }
+    defer second.Close()
+    if !isClosedByServer(second, time.Second) {
+        t.Fatalf("expected connection exceeding max connections to be closed")
+    }
+    first.Write(packet)
+    waitForCounter(t, registry, 2)
+
+    // connections are accepted again once a connection is closed
+    first.Close()
+    deadline := time.Now().Add(2 * time.Second)
+    for time.Now().Before(deadline) {
+        third, err := net.Dial("tcp", addr)
+        if err != nil {
+            t.Fatalf("unable to connect: %v", err)
+        }
+        if !isClosedByServer(third, 50 * time.Millisecond) {
+            third.Close()
+            return
+        }
+        third.Close()
+    }
+    t.Fatalf("expected connection to be accepted once a connection is closed")
+}
+
+// test that unix socket files are removed once the server is closed
+func TestUnixSocketRemoved(t *testing.T) {
+    for _, network := range([]string{"unix", "unixgram"}) {
+        t.Run(network, func(t *testing.T) {
+            server, _ := newStreamTestServer(t)
+            path := filepath.Join(tempDir(t), "hermes.sock")
+            go server.ListenUnix(path, network)
+            deadline := time.Now().Add(2 * time.Second)
+            for time.Now().Before(deadline) {
+                if _, err := os.Stat(path); err == nil {
+                    break
+                }
+                time.Sleep(5 * time.Millisecond)
+            }
+            if _, err := os.Stat(path); err != nil {
+                t.Fatalf("expected unix socket to be created: %v", err)
+            }
+            server.Close()
+            if _, err := os.Stat(path); !os.IsNotExist(err) {
+                t.Fatalf("expected unix socket to be removed but got %v", err)
+            }
+        })
+    }
+}
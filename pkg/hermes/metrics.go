@@ -4,6 +4,7 @@ import (
     "fmt"
     "errors"
     "net/http"
+    "crypto/subtle"
 
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,11 +16,18 @@ import (
 var (
     Config *HermesConfig
 
+    // define registry used to register and gather hermes metrics.
+    // defaults to the global prometheus registry but can be swapped
+    // out (i.e. for testing) with the UseRegistry function
+    Registerer prometheus.Registerer = prometheus.DefaultRegisterer
+    Gatherer   prometheus.Gatherer   = prometheus.DefaultGatherer
+
     // define maps used to store metrics
     Gauges     = map[string]*prometheus.GaugeVec{}
     Counters   = map[string]*prometheus.CounterVec{}
     Histograms = map[string]*prometheus.HistogramVec{}
     Summaries  = map[string]*prometheus.SummaryVec{}
+    Sets       = map[string]*prometheus.GaugeVec{}
 
     // define custom errors for application
     ErrInvalidGauge          = errors.New("Invalid gauge configuration")
@@ -27,30 +35,145 @@ var (
     ErrUnregisteredMetric    = errors.New("Unregistered metric")
     ErrInvalidGaugeOperation = errors.New("Invalid gauge operation")
     ErrInvalidLabels         = errors.New("Invalid label configuration")
+    ErrInvalidBuckets        = errors.New("Invalid histogram buckets")
 )
 
 // function used to start new prometheus server
-// to scrape metrics from Hermes
+// to scrape metrics from Hermes. note that metrics
+// must be initialized with InitializeMetrics before
+// the prometheus server is started. the interface is
+// served over TLS and protected with basic auth if
+// configured on the server. the admin API is served on
+// the same interface if an admin token is set
+func(server *HermesServer) ListenPrometheus() {
+    // create http interface to listen for prometheus scrape jobs
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", BasicAuthHandler(server.PrometheusBasicAuth, PrometheusHandler()))
+    // serve health, readiness and version endpoints used by probes
+    mux.Handle("/healthz", server.HealthHandler())
+    mux.Handle("/readyz", server.ReadinessHandler())
+    mux.Handle("/version", VersionHandler())
+    // serve admin API, tail stream and rejected packets (if kept in an
+    // in-memory buffer) if an admin token is set. note that all are
+    // protected by the admin token instead of basic auth
+    if len(server.AdminToken) > 0 {
+        admin := AdminHandler(server.AdminToken)
+        mux.Handle(AdminAPIPrefix, admin)
+        mux.Handle(AdminAPIPrefix + "/", admin)
+        mux.Handle("/api/v1/tail", AdminTokenHandler(server.AdminToken, server.TailHandler()))
+        if buffer, ok := server.deadLetters.(*DeadLetterBuffer); ok {
+            mux.Handle("/dead_letters", AdminTokenHandler(server.AdminToken, buffer))
+        }
+    } else if _, ok := server.deadLetters.(*DeadLetterBuffer); ok {
+        log.Warn("rejected packets are not served since no admin token is set")
+    }
+    httpServer := &http.Server{Addr: fmt.Sprintf(":%d", server.PrometheusPort), Handler: mux}
+
+    if server.PrometheusTLS == nil {
+        log.Fatal(httpServer.ListenAndServe())
+    }
+    reloader, err := NewCertReloader(*server.PrometheusTLS)
+    if err != nil {
+        log.Fatal(fmt.Errorf("unable to start prometheus interface: %v", err))
+    }
+    httpServer.TLSConfig = reloader.TLSConfig()
+    log.Fatal(httpServer.ListenAndServeTLS("", ""))
+}
+
+// function used to start new prometheus server
+// to scrape metrics from Hermes. metrics are initialized
+// from the given config before the server is started.
+//
+// Deprecated: set the PrometheusPort of the HermesServer
+// instead, which serves the prometheus interface on Setup
 func ListenPrometheus(config HermesConfig, listenPort int) {
     // create prometheus metric objects from configuration
     InitializeMetrics(config)
     // create http interface to listen for prometheus scrape jobs
     connectionString := fmt.Sprintf(":%d", listenPort)
-    http.Handle("/metrics", promhttp.Handler())
+    http.Handle("/metrics", PrometheusHandler())
     log.Fatal(http.ListenAndServe(connectionString, nil))
 }
 
+// function used to protect HTTP handler with basic auth. the
+// handler is returned unchanged if no credentials are set
+func BasicAuthHandler(credentials *BasicAuthConfig, handler http.Handler) http.Handler {
+    if credentials == nil {
+        return handler
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        username, password, ok := r.BasicAuth()
+        // compare credentials in constant time
+        validUser := subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username)) == 1
+        validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password)) == 1
+        if !ok || !validUser || !validPassword {
+            w.Header().Set("WWW-Authenticate", `Basic realm="hermes"`)
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        }
+        handler.ServeHTTP(w, r)
+    })
+}
+
+// function used to generate HTTP handler that serves
+// all metrics stored in the hermes registry. note that
+// exemplars are only served in OpenMetrics format
+func PrometheusHandler() http.Handler {
+    // enable OpenMetrics negotiation to expose exemplars
+    opts := promhttp.HandlerOpts{EnableOpenMetrics: true}
+    return promhttp.InstrumentMetricHandler(Registerer, promhttp.HandlerFor(Gatherer, opts))
+}
+
+// function used to set the prometheus registry used to
+// register and gather hermes metrics. note that all
+// currently stored metrics are reset, and metrics must
+// be re-initialized with InitializeMetrics
+func UseRegistry(registry *prometheus.Registry) {
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    Registerer, Gatherer = registry, registry
+    resetMetrics()
+}
+
+// function used to reset the local mappings of metrics
+// and self metrics. note that metrics are NOT unregistered
+// from the prometheus registry
+func ResetMetrics() {
+    metricsLock.Lock()
+    defer metricsLock.Unlock()
+    resetMetrics()
+}
+
+// function used to reset metrics. the metrics lock
+// must be held by the caller
+func resetMetrics() {
+    Config = nil
+    Gauges     = map[string]*prometheus.GaugeVec{}
+    Counters   = map[string]*prometheus.CounterVec{}
+    Histograms = map[string]*prometheus.HistogramVec{}
+    Summaries  = map[string]*prometheus.SummaryVec{}
+    Sets       = map[string]*prometheus.GaugeVec{}
+    AccessRules = map[string]*AccessRule{}
+    DynamicMetrics = map[string]MetricDefinition{}
+    createSelfMetrics()
+}
+
 // function used to initialize hermes metrics by iterating
 // over the JSON configuration file and generating prometheus
 // Gauges/Counters for all the specified metrics
 func InitializeMetrics(config HermesConfig) error {
     Config = &config
+    // register metrics used to monitor hermes itself
+    registerSelfMetrics()
     // create gauges from config
     for _, gauge := range(config.Gauges) {
         log.Debug(fmt.Sprintf("creating new gauge from config %+v", gauge))
         if err := NewGauge(gauge); err != nil {
             log.Fatal(fmt.Errorf("unable to create new gauge: %v", err))
         }
+        if err := RegisterAccessRule(gauge.MetricName, gauge.AccessControl); err != nil {
+            log.Fatal(fmt.Errorf("unable to create access rule for gauge: %v", err))
+        }
     }
     // create counters from config
     for _, counter := range(config.Counters) {
@@ -58,6 +181,9 @@ func InitializeMetrics(config HermesConfig) error {
         if err := NewCounter(counter); err != nil {
             log.Fatal(fmt.Errorf("unable to create new counter: %v", err))
         }
+        if err := RegisterAccessRule(counter.MetricName, counter.AccessControl); err != nil {
+            log.Fatal(fmt.Errorf("unable to create access rule for counter: %v", err))
+        }
     }
     // create histograms from config
     for _, histogram := range(config.Histograms) {
@@ -65,6 +191,9 @@ func InitializeMetrics(config HermesConfig) error {
         if err := NewHistogram(histogram); err != nil {
             log.Fatal(fmt.Errorf("unable to create new histogram: %v", err))
         }
+        if err := RegisterAccessRule(histogram.MetricName, histogram.AccessControl); err != nil {
+            log.Fatal(fmt.Errorf("unable to create access rule for histogram: %v", err))
+        }
     }
     // create summaries from config
     for _, summary := range(config.Summaries) {
@@ -72,6 +201,31 @@ func InitializeMetrics(config HermesConfig) error {
         if err := NewSummary(summary); err != nil {
             log.Fatal(fmt.Errorf("unable to create new summary: %v", err))
         }
+        if err := RegisterAccessRule(summary.MetricName, summary.AccessControl); err != nil {
+            log.Fatal(fmt.Errorf("unable to create access rule for summary: %v", err))
+        }
+    }
+    // create sets from config
+    for _, set := range(config.Sets) {
+        log.Debug(fmt.Sprintf("creating new set from config %+v", set))
+        if err := NewSet(set); err != nil {
+            log.Fatal(fmt.Errorf("unable to create new set: %v", err))
+        }
+        if err := RegisterAccessRule(set.MetricName, set.AccessControl); err != nil {
+            log.Fatal(fmt.Errorf("unable to create access rule for set: %v", err))
+        }
+    }
+    // register dynamic metrics persisted by previous runs
+    if config.DynamicMetrics != nil && len(config.DynamicMetrics.Path) > 0 {
+        if err := LoadDynamicMetrics(config.DynamicMetrics.Path); err != nil {
+            log.Error(fmt.Errorf("unable to load dynamic metrics: %v", err))
+        }
+    }
+    // restore counter and gauge values from state file if configured
+    if config.State != nil && len(config.State.Path) > 0 {
+        if err := RestoreState(config.State.Path); err != nil {
+            log.Error(fmt.Errorf("unable to restore hermes state: %v", err))
+        }
     }
     return nil
 }
@@ -95,9 +249,25 @@ func GetMetricType(metric string) (string, error) {
     if _, ok := Summaries[metric]; ok {
         return "summary", nil
     }
+    // check if metric is present in registered sets
+    if _, ok := Sets[metric]; ok {
+        return "set", nil
+    }
     return "", ErrUnregisteredMetric
 }
 
+// function used to ensure that labels of a metric do not include
+// the labels reserved by prometheus, i.e. the bucket label of
+// histograms and the quantile label of summaries. prometheus
+// accepts these labels on registration but panics on every update
+func ValidateReservedLabels(metricType string, labels []string) error {
+    reserved := map[string]string{"histogram": "le", "summary": "quantile"}[metricType]
+    if len(reserved) > 0 && utils.SliceContains(labels, reserved) {
+        return fmt.Errorf("%w: label '%s' is reserved for %ss", ErrInvalidLabels, reserved, metricType)
+    }
+    return nil
+}
+
 // function used to determine if a given set of labels
 // matches the label configuration expected for the
 // specified metric
@@ -131,6 +301,11 @@ func SetPrometheusLabels(labels map[string]string, labelConfig []string) (promet
             promLabels[metricLabel] = label
         }
     }
+    // ensure that all labels defined in config have been given
+    if len(promLabels) != len(labelConfig) {
+        log.Error(fmt.Sprintf("invalid label configuration. missing labels for %v", labelConfig))
+        return prometheus.Labels{}, ErrInvalidLabels
+    }
     return promLabels, nil
 }
 
@@ -173,6 +348,13 @@ func GenerateLabels(labels map[string]string, metricType,
                 promLabels, err = SetPrometheusLabels(labels, summary.Labels)
             }
         }
+    case "set":
+        for _, set := range(Config.Sets) {
+            if set.MetricName == metricName {
+                // create labels for set instance
+                promLabels, err = SetPrometheusLabels(labels, set.Labels)
+            }
+        }
     }
     return promLabels, err
 }
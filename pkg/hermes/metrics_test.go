@@ -0,0 +1,41 @@
+package hermes
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// test that labels are only accepted if all labels defined
+// in the config are given, and no other labels are given
+func TestSetPrometheusLabels(t *testing.T) {
+    tests := []struct {
+        name     string
+        labels   map[string]string
+        config   []string
+        expected prometheus.Labels
+        err      error
+    }{
+        {"all labels", map[string]string{"app": "web", "host": "a"}, []string{"app", "host"},
+            prometheus.Labels{"app": "web", "host": "a"}, nil},
+        {"no labels", map[string]string{}, []string{}, prometheus.Labels{}, nil},
+        {"missing label", map[string]string{"app": "web"}, []string{"app", "host"},
+            prometheus.Labels{}, ErrInvalidLabels},
+        {"missing all labels", nil, []string{"app"}, prometheus.Labels{}, ErrInvalidLabels},
+        {"unknown label", map[string]string{"app": "web", "region": "eu"}, []string{"app"},
+            prometheus.Labels{}, ErrInvalidLabels},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            labels, err := SetPrometheusLabels(test.labels, test.config)
+            if !errors.Is(err, test.err) {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+            if !reflect.DeepEqual(labels, test.expected) {
+                t.Fatalf("expected labels %v but got %v", test.expected, labels)
+            }
+        })
+    }
+}
@@ -1,5 +1,8 @@
 package hermes
 
+import (
+    "encoding/json"
+)
 
 // struct used to define the global hermes configuration
 // loaded for the local JSON file
@@ -9,6 +12,165 @@ type HermesConfig struct {
     Counters      []HermesCounter   `json:"counters"`
     Histograms    []HermesHistogram `json:"histograms"`
     Summaries     []HermesSummary   `json:"summaries"`
+    Sets          []HermesSet       `json:"sets"`
+
+    // optional configuration used to persist state across restarts
+    State         *HermesStateConfig `json:"state"`
+    // optional configuration used to push metrics via remote write
+    RemoteWrite   *RemoteWriteConfig `json:"remote_write"`
+    // optional configuration used to push metrics to a pushgateway
+    Pushgateway   *PushgatewayConfig `json:"pushgateway"`
+    // optional configuration used to write metrics to a textfile
+    Textfile      *TextfileConfig    `json:"textfile"`
+    // optional configuration used to authenticate packets
+    Auth          *AuthConfig        `json:"auth"`
+    // optional configuration used to register metrics over the wire
+    DynamicMetrics *DynamicMetricsConfig `json:"dynamic_metrics"`
+    // optional configuration used to record rejected packets
+    DeadLetter    *DeadLetterConfig  `json:"dead_letter"`
+    // optional configuration used to forward packets to an upstream server
+    Relay         *RelayConfig       `json:"relay"`
+    // optional configuration used to aggregate updates over flush windows
+    Aggregation   *AggregationConfig `json:"aggregation"`
+}
+
+// struct used to define configuration for persisting counter
+// and gauge values to a local state file. the snapshot interval
+// is given in seconds
+type HermesStateConfig struct {
+    Path             string `json:"path"`
+    SnapshotInterval int    `json:"snapshot_interval"`
+}
+
+// struct used to define configuration for pushing metrics to
+// a prometheus remote write endpoint. the interval and timeout
+// are given in seconds
+type RemoteWriteConfig struct {
+    URL         string           `json:"url"`
+    Interval    int              `json:"interval"`
+    Timeout     int              `json:"timeout"`
+    QueueSize   int              `json:"queue_size"`
+    MaxRetries  int              `json:"max_retries"`
+    BasicAuth   *BasicAuthConfig `json:"basic_auth"`
+    BearerToken string           `json:"bearer_token"`
+}
+
+// struct used to define configuration for pushing metrics to a
+// prometheus pushgateway. the job defaults to the service name
+// of the hermes config, and the grouping labels are added to the
+// grouping key of all pushes. the interval is given in seconds
+type PushgatewayConfig struct {
+    URL       string            `json:"url"`
+    Job       string            `json:"job"`
+    Grouping  map[string]string `json:"grouping"`
+    Interval  int               `json:"interval"`
+    Timeout   int               `json:"timeout"`
+    BasicAuth *BasicAuthConfig  `json:"basic_auth"`
+}
+
+// struct used to define configuration for writing metrics to a
+// .prom file picked up by the node_exporter textfile collector.
+// the interval is given in seconds
+type TextfileConfig struct {
+    Path     string `json:"path"`
+    Interval int    `json:"interval"`
+}
+
+// struct used to define configuration for authenticating packets
+// with HMAC signatures. multiple keys can be configured to allow
+// for key rotation. if signatures are not required, unsigned packets
+// are accepted but signed packets are still verified. the max skew
+// (in seconds) defines how old signed packets may be
+type AuthConfig struct {
+    Required bool          `json:"required"`
+    Keys     []AuthKey     `json:"keys"`
+    MaxSkew  int           `json:"max_skew"`
+}
+
+// struct used to define a shared secret used to sign packets
+type AuthKey struct {
+    KeyID  string `json:"key_id"`
+    Secret string `json:"secret"`
+}
+
+// struct used to define policy for metrics registered dynamically
+// from definitions sent with packets. metric names must start with
+// one of the allowed prefixes (if any are set), and at most max
+// metrics can be registered. dynamic metrics are persisted to the
+// config file at the given path if set, and the access control is
+// applied to both registration and updates of dynamic metrics
+type DynamicMetricsConfig struct {
+    AllowedPrefixes []string `json:"allowed_prefixes"`
+    MaxMetrics      int      `json:"max_metrics"`
+    Path            string   `json:"path"`
+
+    AccessControl
+}
+
+// struct used to define sink that rejected packets are written
+// to. packets are written to a JSONL file if a path is set, which
+// is rotated once it exceeds the max size (in bytes). otherwise,
+// the most recent packets are kept in an in-memory buffer that is
+// served over the prometheus interface
+type DeadLetterConfig struct {
+    Path       string `json:"path"`
+    MaxSize    int    `json:"max_size"`
+    MaxFiles   int    `json:"max_files"`
+    BufferSize int    `json:"buffer_size"`
+}
+
+// struct used to define configuration for forwarding packets to an
+// upstream hermes server instead of updating local metrics. the given
+// labels (and the hostname of the local host, if a host label is set)
+// are added to all forwarded packets. packets that cannot be delivered
+// are written to the spool file (up to the max spool size in bytes)
+// and re-sent once the upstream server is available again. gauge sets
+// are aggregated over the flush interval (in seconds) if enabled
+type RelayConfig struct {
+    URL           string            `json:"url"`
+    Labels        map[string]string `json:"labels"`
+    HostLabel     string            `json:"host_label"`
+    Encoding      string            `json:"encoding"`
+    KeyID         string            `json:"key_id"`
+    Secret        string            `json:"secret"`
+    QueueSize     int               `json:"queue_size"`
+    MaxRetries    int               `json:"max_retries"`
+    FlushInterval int               `json:"flush_interval"`
+    Aggregate     bool              `json:"aggregate"`
+    SpoolPath     string            `json:"spool_path"`
+    MaxSpoolSize  int               `json:"max_spool_size"`
+}
+
+// struct used to define configuration for aggregating updates over
+// flush windows (in seconds). counters are converted to per-second
+// rates, and histograms and summaries to percentiles, min, max and
+// count over each window. all counters, histograms and summaries are
+// aggregated unless specific metrics are given. at most max samples
+// are kept per series and window to compute percentiles, and the
+// aggregates are pushed to the pushgateway and textfile after each
+// flush if enabled
+type AggregationConfig struct {
+    FlushInterval int       `json:"flush_interval"`
+    Percentiles   []float64 `json:"percentiles"`
+    Metrics       []string  `json:"metrics"`
+    MaxSamples    int       `json:"max_samples"`
+    PushOnFlush   bool      `json:"push_on_flush"`
+}
+
+// struct used to define credentials for basic auth
+type BasicAuthConfig struct {
+    Username string `json:"username"`
+    Password string `json:"password"`
+}
+
+// struct used to define which clients may update a particular
+// metric. clients are identified by the ID of the key used to
+// sign packets, or by source addresses given in CIDR notation.
+// metrics without any allowed keys or sources can be updated
+// by any client
+type AccessControl struct {
+    AllowedKeys    []string `json:"allowed_keys"`
+    AllowedSources []string `json:"allowed_sources"`
 }
 
 // struct used to define a Gauge from the Hermes config
@@ -17,6 +179,8 @@ type HermesGauge struct {
     Labels            []string `json:"labels"`
     MetricName        string   `json:"metric_name"`
     MetricDescription string   `json:"metric_description"`
+
+    AccessControl
 }
 
 // struct used to define a Counter from the Hermes config
@@ -25,14 +189,20 @@ type HermesCounter struct {
     Labels            []string `json:"labels"`
     MetricName        string   `json:"metric_name"`
     MetricDescription string   `json:"metric_description"`
+
+    AccessControl
 }
 
 // struct used to define a Counter from the Hermes config
 // used to create a prometheus counter instance
 type HermesHistogram struct {
-    Labels            []string `json:"labels"`
-    MetricName        string   `json:"metric_name"`
-    MetricDescription string   `json:"metric_description"`
+    Labels            []string  `json:"labels"`
+    MetricName        string    `json:"metric_name"`
+    MetricDescription string    `json:"metric_description"`
+    // optional buckets (defaults to prometheus default buckets)
+    Buckets           []float64 `json:"buckets,omitempty"`
+
+    AccessControl
 }
 
 // struct used to define a Counter from the Hermes config
@@ -41,13 +211,51 @@ type HermesSummary struct {
     Labels            []string `json:"labels"`
     MetricName        string   `json:"metric_name"`
     MetricDescription string   `json:"metric_description"`
+
+    AccessControl
+}
+
+// struct used to define a Set from the Hermes config used to
+// create a prometheus gauge counting the unique values received
+// over each aggregation window
+type HermesSet struct {
+    Labels            []string `json:"labels"`
+    MetricName        string   `json:"metric_name"`
+    MetricDescription string   `json:"metric_description"`
+
+    AccessControl
 }
 
 // struct used to define format of UDP packets
-// sent from a hermes client
+// sent from a hermes client. the payload is kept in
+// its raw form until the metric type is known. the
+// key ID, timestamp, nonce and signature are only
+// set on signed packets. the binary flag is set if
+// the packet was sent with the binary protocol, in
+// which case the payload is protobuf encoded. the
+// definition is only set on packets of metrics that
+// are registered dynamically on first use
 type HermesPayload struct {
-    MetricName string      `json:"metric_name"`
-    Payload    interface{} `json:"payload"`
+    MetricName string          `json:"metric_name"`
+    Payload    json.RawMessage `json:"payload"`
+
+    KeyID      string          `json:"key_id,omitempty"`
+    Timestamp  int64           `json:"timestamp,omitempty"`
+    Nonce      string          `json:"nonce,omitempty"`
+    Signature  string          `json:"signature,omitempty"`
+    Definition *MetricDefinition `json:"definition,omitempty"`
+
+    Binary     bool            `json:"-"`
+}
+
+// struct used to define metric sent with packets. the type
+// is one of counter, gauge, histogram, summary or set, and the
+// buckets are only used for histograms
+type MetricDefinition struct {
+    Type        string    `json:"type"`
+    Description string    `json:"description"`
+    Labels      []string  `json:"labels"`
+    Buckets     []float64 `json:"buckets,omitempty"`
 }
 
 // struct used to define JSON format of UDP packets
@@ -62,16 +270,34 @@ type GaugeJSON struct {
 
 // struct used to define JSON format of UDP packets for counters
 type CounterJSON struct {
-    Labels map[string]string `json:"labels"`
+    Labels   map[string]string `json:"labels"`
+    Exemplar *ExemplarJSON     `json:"exemplar"`
 }
 
 // struct used to define JSON format of UDP packets for counters
 type HistogramJSON struct {
     Labels      map[string]string `json:"labels"`
     Observation float64           `json:"observation"`
+    Exemplar    *ExemplarJSON     `json:"exemplar"`
+}
+
+// struct used to define JSON format of exemplars attached to
+// counters and histograms. for counters, the value defines the
+// amount that the counter is incremented by (defaults to 1). for
+// histograms, the value must match the observation if set
+type ExemplarJSON struct {
+    TraceID string   `json:"trace_id"`
+    SpanID  string   `json:"span_id"`
+    Value   *float64 `json:"value"`
 }
 
 type SummaryJSON struct {
     Labels      map[string]string `json:"labels"`
     Observation float64           `json:"observation"`
+}
+
+// struct used to define JSON format of UDP packets for sets
+type SetJSON struct {
+    Labels map[string]string `json:"labels"`
+    Value  string            `json:"value"`
 }
\ No newline at end of file
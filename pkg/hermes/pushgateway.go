@@ -0,0 +1,84 @@
+package hermes
+
+import (
+    "fmt"
+    "sort"
+    "time"
+    "net/http"
+
+    "github.com/prometheus/client_golang/prometheus/push"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define defaults used for pushgateway exporter
+    DefaultPushgatewayInterval = 15
+    DefaultPushgatewayTimeout  = 10
+    DefaultPushgatewayJob      = "hermes"
+)
+
+// struct used to periodically push all metrics from the
+// hermes registry to a prometheus pushgateway
+type PushgatewayExporter struct {
+    Config PushgatewayConfig
+    Pusher *push.Pusher
+}
+
+// function used to create new pushgateway exporter. the job is
+// derived from the service name if not explicitly configured
+func NewPushgatewayExporter(config PushgatewayConfig, serviceName string) *PushgatewayExporter {
+    if len(config.Job) == 0 {
+        config.Job = serviceName
+    }
+    if len(config.Job) == 0 {
+        config.Job = DefaultPushgatewayJob
+    }
+    if config.Interval <= 0 {
+        config.Interval = DefaultPushgatewayInterval
+    }
+    if config.Timeout <= 0 {
+        config.Timeout = DefaultPushgatewayTimeout
+    }
+    client := &http.Client{Timeout: time.Second * time.Duration(config.Timeout)}
+    pusher := push.New(config.URL, config.Job).Gatherer(Gatherer).Client(client)
+    // add grouping labels in sorted order to generate consistent URLs
+    keys := []string{}
+    for key := range(config.Grouping) {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range(keys) {
+        pusher = pusher.Grouping(key, config.Grouping[key])
+    }
+    if config.BasicAuth != nil {
+        pusher = pusher.BasicAuth(config.BasicAuth.Username, config.BasicAuth.Password)
+    }
+    return &PushgatewayExporter{Config: config, Pusher: pusher}
+}
+
+// function used to push all metrics to the pushgateway. note that
+// all metrics previously pushed with the same grouping key are
+// replaced by the pushed metrics
+func(e *PushgatewayExporter) Push() error {
+    log.Debug(fmt.Sprintf("pushing metrics to pushgateway %s", e.Config.URL))
+    return e.Pusher.Push()
+}
+
+// function used to push metrics to the pushgateway on
+// every interval until the done channel is closed
+func(e *PushgatewayExporter) Run(done <-chan struct{}) {
+    log.Info(fmt.Sprintf("starting pushgateway exporter to %s with job '%s'",
+        e.Config.URL, e.Config.Job))
+    ticker := time.NewTicker(time.Second * time.Duration(e.Config.Interval))
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            if err := e.Push(); err != nil {
+                log.Error(fmt.Errorf("unable to push metrics to pushgateway: %v", err))
+            }
+        case <-done:
+            return
+        }
+    }
+}
@@ -0,0 +1,513 @@
+package hermes
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+    "errors"
+    "strings"
+    "net/url"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+    hermes_client "github.com/PSauerborn/hermes/pkg/client"
+)
+
+var (
+    // define defaults used for relay. the max spool size is given
+    // in bytes and the flush interval in seconds
+    DefaultRelayQueueSize     = 10000
+    DefaultRelayMaxRetries    = 3
+    DefaultRelayFlushInterval = 5
+    DefaultRelayMaxSpoolSize  = 100 * 1024 * 1024
+    RelayMinBackoff           = time.Millisecond * 100
+    RelayMaxBackoff           = time.Second * 5
+
+    ErrInvalidRelayConfig = errors.New("Invalid relay configuration")
+    ErrRelayQueueFull     = errors.New("Relay queue full")
+)
+
+// define reasons used to count packets dropped by the relay
+const (
+    RelayReasonQueueFull   = "queue_full"
+    RelayReasonSpoolFull   = "spool_full"
+    RelayReasonUnavailable = "upstream_unavailable"
+    RelayReasonInvalid     = "invalid_packet"
+    RelayReasonTooLarge    = "packet_too_large"
+)
+
+// struct used to define packet waiting to be forwarded, along
+// with the definition of the metric if sent with the packet
+type relayPacket struct {
+    metricName string
+    packet     interface{}
+    definition *hermes_client.HermesMetricDefinition
+}
+
+// struct used to forward packets to an upstream hermes server. packets
+// are re-encoded with the hermes client after the relay labels have
+// been added, and are sent in order by a single sender. once a packet
+// cannot be delivered, all packets are written to the spool until the
+// spool has been drained, so that the order of packets is kept
+type Relay struct {
+    Config RelayConfig
+    Client *hermes_client.HermesClient
+
+    labels    map[string]string
+    queue     chan relayPacket
+    stopped   chan struct{}
+
+    // gauge sets aggregated over the current flush interval
+    mu        sync.Mutex
+    gauges    map[string]relayPacket
+
+    // spool file and size of spooled packets. only accessed by the sender
+    spool     *CaptureWriter
+    spoolSize int
+}
+
+// function used to create new relay. defaults are assigned for
+// all values not specified in the config. note that packets are
+// only relayed over stream connections (tcp or unix), since failed
+// sends cannot be detected (and hence spooled) with datagrams
+func NewRelay(config RelayConfig) (*Relay, error) {
+    if len(config.URL) == 0 {
+        return nil, fmt.Errorf("%w: missing upstream URL", ErrInvalidRelayConfig)
+    }
+    upstream, err := url.Parse(config.URL)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidRelayConfig, err)
+    }
+    if upstream.Scheme != "tcp" && upstream.Scheme != "unix" {
+        return nil, fmt.Errorf("%w: unsupported upstream scheme '%s' (must be tcp or unix)",
+            ErrInvalidRelayConfig, upstream.Scheme)
+    }
+    if config.QueueSize <= 0 {
+        config.QueueSize = DefaultRelayQueueSize
+    }
+    if config.MaxRetries <= 0 {
+        config.MaxRetries = DefaultRelayMaxRetries
+    }
+    if config.FlushInterval <= 0 {
+        config.FlushInterval = DefaultRelayFlushInterval
+    }
+    if config.MaxSpoolSize <= 0 {
+        config.MaxSpoolSize = DefaultRelayMaxSpoolSize
+    }
+    client, err := hermes_client.NewFromURL(config.URL)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidRelayConfig, err)
+    }
+    client.SetEncoding(config.Encoding)
+    if len(config.Secret) > 0 {
+        client.SetAuth(config.KeyID, config.Secret)
+    }
+    labels := map[string]string{}
+    for key, value := range(config.Labels) {
+        labels[key] = value
+    }
+    if len(config.HostLabel) > 0 {
+        hostname, err := os.Hostname()
+        if err != nil {
+            return nil, fmt.Errorf("%w: unable to determine hostname: %v", ErrInvalidRelayConfig, err)
+        }
+        labels[config.HostLabel] = hostname
+    }
+    return &Relay{Config: config, Client: client, labels: labels,
+        queue: make(chan relayPacket, config.QueueSize), stopped: make(chan struct{}),
+        gauges: map[string]relayPacket{}}, nil
+}
+
+// function used to forward counter update
+func(r *Relay) ForwardCounter(payload HermesPayload, counter CounterJSON) error {
+    packet := hermes_client.HermesCounterPacket{
+        MetricName: payload.MetricName,
+        Payload: hermes_client.HermesCounterPayload{CounterLabels: r.withLabels(counter.Labels),
+            CounterExemplar: relayExemplar(counter.Exemplar)},
+    }
+    return r.enqueue(r.newPacket(payload, packet))
+}
+
+// function used to forward gauge update. gauge sets are aggregated
+// until the next flush if enabled, and increments and decrements are
+// applied to the aggregated value if a set is pending
+func(r *Relay) ForwardGauge(payload HermesPayload, gauge GaugeJSON) error {
+    packet := hermes_client.HermesGaugePacket{
+        MetricName: payload.MetricName,
+        Payload: hermes_client.HermesGaugePayload{GaugeOperation: gauge.Operation,
+            GaugeValue: gauge.Value, GaugeLabels: r.withLabels(gauge.Labels)},
+    }
+    forwarded := r.newPacket(payload, packet)
+    if r.Config.Aggregate && r.aggregateGauge(forwarded) {
+        return nil
+    }
+    return r.enqueue(forwarded)
+}
+
+// function used to forward histogram observation
+func(r *Relay) ForwardHistogram(payload HermesPayload, histogram HistogramJSON) error {
+    packet := hermes_client.HermesHistogramPacket{
+        MetricName: payload.MetricName,
+        Payload: hermes_client.HermesHistogramPayload{HistogramObservation: histogram.Observation,
+            HistogramLabels: r.withLabels(histogram.Labels), HistogramExemplar: relayExemplar(histogram.Exemplar)},
+    }
+    return r.enqueue(r.newPacket(payload, packet))
+}
+
+// function used to forward summary observation
+func(r *Relay) ForwardSummary(payload HermesPayload, summary SummaryJSON) error {
+    packet := hermes_client.HermesSummaryPacket{
+        MetricName: payload.MetricName,
+        Payload: hermes_client.HermesSummaryPayload{SummaryObservation: summary.Observation,
+            SummaryLabels: r.withLabels(summary.Labels)},
+    }
+    return r.enqueue(r.newPacket(payload, packet))
+}
+
+// function used to forward set value
+func(r *Relay) ForwardSet(payload HermesPayload, set SetJSON) error {
+    packet := hermes_client.HermesSetPacket{
+        MetricName: payload.MetricName,
+        Payload: hermes_client.HermesSetPayload{SetValue: set.Value, SetLabels: r.withLabels(set.Labels)},
+    }
+    return r.enqueue(r.newPacket(payload, packet))
+}
+
+// function used to add relay labels to the labels of a packet.
+// relay labels take precedence over labels set by clients
+func(r *Relay) withLabels(labels map[string]string) map[string]string {
+    merged := make(map[string]string, len(labels) + len(r.labels))
+    for key, value := range(labels) {
+        merged[key] = value
+    }
+    for key, value := range(r.labels) {
+        merged[key] = value
+    }
+    return merged
+}
+
+// function used to create packet waiting to be forwarded. the
+// relay labels are added to the definition of the metric if set
+func(r *Relay) newPacket(payload HermesPayload, packet interface{}) relayPacket {
+    forwarded := relayPacket{metricName: payload.MetricName, packet: packet}
+    if payload.Definition != nil {
+        definition := hermes_client.HermesMetricDefinition{Type: payload.Definition.Type,
+            Description: payload.Definition.Description, Buckets: payload.Definition.Buckets,
+            Labels: append([]string{}, payload.Definition.Labels...)}
+        for key := range(r.labels) {
+            if !utils.SliceContains(definition.Labels, key) {
+                definition.Labels = append(definition.Labels, key)
+            }
+        }
+        forwarded.definition = &definition
+    }
+    return forwarded
+}
+
+// function used to add packet to the queue of the sender
+func(r *Relay) enqueue(packet relayPacket) error {
+    select {
+    case r.queue <- packet:
+        return nil
+    default:
+        RelayDropped.WithLabelValues(RelayReasonQueueFull).Inc()
+        return ErrRelayQueueFull
+    }
+}
+
+// function used to aggregate gauge update. false is returned if the
+// update cannot be aggregated and has to be forwarded immediately
+func(r *Relay) aggregateGauge(packet relayPacket) bool {
+    gauge := packet.packet.(hermes_client.HermesGaugePacket)
+    key := seriesKey(gauge.MetricName, gauge.Payload.GaugeLabels)
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    pending, ok := r.gauges[key]
+    switch gauge.Payload.GaugeOperation {
+    case "set":
+        if gauge.Payload.GaugeValue == nil {
+            return false
+        }
+        value := *gauge.Payload.GaugeValue
+        gauge.Payload.GaugeValue = &value
+        packet.packet = gauge
+        r.gauges[key] = packet
+        return true
+    case "increment", "decrement":
+        if !ok {
+            return false
+        }
+        value := pending.packet.(hermes_client.HermesGaugePacket).Payload.GaugeValue
+        if gauge.Payload.GaugeOperation == "increment" {
+            *value++
+        } else {
+            *value--
+        }
+        return true
+    }
+    return false
+}
+
+// function used to add all aggregated gauges to the queue
+func(r *Relay) flushGauges() {
+    r.mu.Lock()
+    gauges := r.gauges
+    r.gauges = map[string]relayPacket{}
+    r.mu.Unlock()
+    for _, packet := range(gauges) {
+        r.enqueue(packet)
+    }
+}
+
+// function used to start sender of the relay. queued packets are sent
+// until the done channel is closed, after which all remaining packets
+// are sent (or spooled). aggregated gauges are flushed and the spool is
+// drained on every flush interval
+func(r *Relay) Run(done <-chan struct{}) {
+    defer close(r.stopped)
+    log.Info(fmt.Sprintf("starting relay to upstream hermes server %s", r.Config.URL))
+    // drain packets spooled before the last shutdown
+    if len(r.Config.SpoolPath) > 0 {
+        r.drainSpool()
+    }
+    ticker := time.NewTicker(time.Second * time.Duration(r.Config.FlushInterval))
+    defer ticker.Stop()
+    for {
+        select {
+        case packet := <-r.queue:
+            r.deliver(packet, done)
+        case <-ticker.C:
+            r.flushGauges()
+            if r.spool != nil {
+                r.drainSpool()
+            }
+        case <-done:
+            r.flushGauges()
+            for len(r.queue) > 0 {
+                r.deliver(<-r.queue, done)
+            }
+            r.closeSpool()
+            return
+        }
+    }
+}
+
+// function used to wait until the sender has stopped
+func(r *Relay) Wait() {
+    <-r.stopped
+}
+
+// function used to send packet to upstream server. packets are
+// written to the spool if the upstream server is not available, or
+// if packets are already waiting in the spool
+func(r *Relay) deliver(packet relayPacket, done <-chan struct{}) {
+    if packet.definition != nil {
+        r.Client.DefineMetric(packet.metricName, *packet.definition)
+    }
+    bytes, err := r.Client.EncodePacket(packet.packet)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to encode relayed packet: %v", err))
+        RelayDropped.WithLabelValues(RelayReasonInvalid).Inc()
+        return
+    }
+    if r.spool == nil {
+        err := r.sendWithRetry(bytes, done)
+        if err == nil {
+            RelayForwarded.Inc()
+            return
+        }
+        log.Warn(fmt.Sprintf("unable to forward packet to upstream hermes server: %v", err))
+    }
+    r.spoolPacket(bytes)
+}
+
+// function used to send packet with exponential backoff. only a
+// single attempt is made once the done channel has been closed
+func(r *Relay) sendWithRetry(bytes []byte, done <-chan struct{}) error {
+    backoff := RelayMinBackoff
+    for attempt := 0; ; attempt++ {
+        err := r.Client.Send(bytes)
+        if err == nil || attempt >= r.Config.MaxRetries {
+            return err
+        }
+        select {
+        case <-time.After(backoff):
+        case <-done:
+            return err
+        }
+        if backoff *= 2; backoff > RelayMaxBackoff {
+            backoff = RelayMaxBackoff
+        }
+    }
+}
+
+// function used to write packet to the spool. packets are dropped
+// if no spool is configured or the spool has reached its max size.
+// packets exceeding the max size of captured packets are dropped as
+// well, since they could not be read when the spool is drained
+func(r *Relay) spoolPacket(bytes []byte) {
+    if len(r.Config.SpoolPath) == 0 {
+        RelayDropped.WithLabelValues(RelayReasonUnavailable).Inc()
+        return
+    }
+    if len(bytes) > maxCapturedPacketSize() {
+        log.Warn(fmt.Sprintf("dropping relayed packet of %d bytes: packet exceeds max spooled packet size",
+            len(bytes)))
+        RelayDropped.WithLabelValues(RelayReasonTooLarge).Inc()
+        return
+    }
+    if r.spoolSize + len(bytes) > r.Config.MaxSpoolSize {
+        RelayDropped.WithLabelValues(RelayReasonSpoolFull).Inc()
+        return
+    }
+    if r.spool == nil {
+        // append to existing spool file, which still holds unsent
+        // packets if the spool could not be drained or replaced
+        spool, err := AppendCaptureWriter(r.Config.SpoolPath)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to open relay spool: %v", err))
+            RelayDropped.WithLabelValues(RelayReasonUnavailable).Inc()
+            return
+        }
+        log.Warn(fmt.Sprintf("upstream hermes server unavailable. spooling packets to %s", r.Config.SpoolPath))
+        r.spool, r.spoolSize = spool, int(spool.size) - len(CaptureHeader)
+    }
+    if err := r.spool.Write(time.Now(), nil, bytes); err != nil {
+        log.Error(fmt.Errorf("unable to write packet to relay spool: %v", err))
+        RelayDropped.WithLabelValues(RelayReasonUnavailable).Inc()
+        return
+    }
+    r.spoolSize += len(bytes)
+    RelaySpoolSize.Set(float64(r.spoolSize))
+}
+
+// function used to send all spooled packets to the upstream server.
+// the spool is kept as is if the first packet cannot be sent, while
+// the remaining packets are moved to a new spool file if the upstream
+// server becomes unavailable while the spool is drained
+func(r *Relay) drainSpool() {
+    if r.spool != nil {
+        if err := r.spool.Flush(); err != nil {
+            log.Error(fmt.Errorf("unable to flush relay spool: %v", err))
+        }
+    }
+    file, err := os.Open(r.Config.SpoolPath)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Error(fmt.Errorf("unable to open relay spool: %v", err))
+        }
+        return
+    }
+    defer file.Close()
+    reader, err := NewCaptureReader(file)
+    if err == nil {
+        var sent int
+        for {
+            captured, err := reader.Next()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                log.Error(fmt.Errorf("unable to read relay spool. discarding remaining packets: %v", err))
+                break
+            }
+            if err := r.Client.Send(captured.Packet); err != nil {
+                // keep writing to the existing spool if no packets were sent
+                if sent == 0 && r.spool != nil {
+                    return
+                }
+                r.closeSpool()
+                r.respool(reader, captured)
+                return
+            }
+            sent++
+            RelayForwarded.Inc()
+        }
+        log.Info(fmt.Sprintf("forwarded %d spooled packets to upstream hermes server", sent))
+    } else {
+        log.Error(fmt.Errorf("unable to read relay spool. discarding spool: %v", err))
+    }
+    r.closeSpool()
+    if err := os.Remove(r.Config.SpoolPath); err != nil {
+        log.Error(fmt.Errorf("unable to remove relay spool: %v", err))
+    }
+    r.spoolSize = 0
+    RelaySpoolSize.Set(0)
+}
+
+// function used to close spool file if open
+func(r *Relay) closeSpool() {
+    if r.spool == nil {
+        return
+    }
+    if err := r.spool.Close(); err != nil {
+        log.Error(fmt.Errorf("unable to close relay spool: %v", err))
+    }
+    r.spool = nil
+}
+
+// function used to move the given and all remaining spooled packets
+// to a new spool file, which replaces the existing spool file
+func(r *Relay) respool(reader *CaptureReader, first CapturedPacket) {
+    path := r.Config.SpoolPath + ".tmp"
+    spool, err := NewCaptureWriter(path)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to create relay spool: %v", err))
+        return
+    }
+    size, captured := 0, first
+    for {
+        if err := spool.Write(captured.Timestamp, nil, captured.Packet); err != nil {
+            log.Error(fmt.Errorf("unable to write packet to relay spool: %v", err))
+            break
+        }
+        size += len(captured.Packet)
+        next, err := reader.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            log.Error(fmt.Errorf("unable to read relay spool. discarding remaining packets: %v", err))
+            break
+        }
+        captured = next
+    }
+    // note that the existing spool is kept if it cannot be replaced,
+    // so that packets are sent again rather than lost
+    if err := os.Rename(path, r.Config.SpoolPath); err != nil {
+        log.Error(fmt.Errorf("unable to replace relay spool: %v", err))
+        spool.Close()
+        os.Remove(path)
+        return
+    }
+    r.spool, r.spoolSize = spool, size
+    RelaySpoolSize.Set(float64(size))
+}
+
+// function used to convert exemplar of a decoded payload
+func relayExemplar(exemplar *ExemplarJSON) *hermes_client.HermesExemplar {
+    if exemplar == nil {
+        return nil
+    }
+    return &hermes_client.HermesExemplar{TraceID: exemplar.TraceID, SpanID: exemplar.SpanID,
+        Value: exemplar.Value}
+}
+
+// function used to generate unique key of a series from the
+// metric name and the label values in sorted label order
+func seriesKey(metricName string, labels map[string]string) string {
+    keys := []string{}
+    for key := range(labels) {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    parts := []string{metricName}
+    for _, key := range(keys) {
+        parts = append(parts, key + "=" + labels[key])
+    }
+    return strings.Join(parts, "\xff")
+}
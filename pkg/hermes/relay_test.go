@@ -0,0 +1,466 @@
+package hermes
+
+import (
+    "os"
+    "net"
+    "sort"
+    "sync"
+    "time"
+    "bufio"
+    "bytes"
+    "errors"
+    "reflect"
+    "strconv"
+    "testing"
+    "path/filepath"
+
+    "github.com/PSauerborn/hermes/pkg/client"
+)
+
+// test that relays are only created for stream upstream servers
+func TestNewRelay(t *testing.T) {
+    tests := []struct {
+        name string
+        url  string
+        err  error
+    }{
+        {"tcp", "tcp://hermes-central:7790", nil},
+        {"unix", "unix:///var/run/hermes.sock", nil},
+        {"udp", "udp://hermes-central:7789", ErrInvalidRelayConfig},
+        {"unixgram", "unixgram:///var/run/hermes.sock", ErrInvalidRelayConfig},
+        {"missing scheme", "hermes-central:7790", ErrInvalidRelayConfig},
+        {"missing URL", "", ErrInvalidRelayConfig},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            if _, err := NewRelay(RelayConfig{URL: test.url}); !errors.Is(err, test.err) {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+        })
+    }
+}
+
+// test that packets exceeding the max captured packet size are not
+// spooled, so that the spool can always be read when drained
+func TestSpoolPacketSize(t *testing.T) {
+    initTestMetrics(t, HermesConfig{})
+    path := filepath.Join(tempDir(t), "relay.spool")
+    relay := &Relay{Config: RelayConfig{SpoolPath: path, MaxSpoolSize: 4 * maxCapturedPacketSize()}}
+    packets := [][]byte{
+        bytes.Repeat([]byte("a"), maxCapturedPacketSize() + 1),
+        bytes.Repeat([]byte("b"), maxCapturedPacketSize()),
+        []byte(`{"metric_name": "events_total"}`),
+    }
+    for _, packet := range(packets) {
+        relay.spoolPacket(packet)
+    }
+    relay.closeSpool()
+    spooled := readCapture(t, path)
+    if len(spooled) != 2 {
+        t.Fatalf("expected 2 spooled packets but got %d", len(spooled))
+    }
+    if relay.spoolSize != len(packets[1]) + len(packets[2]) {
+        t.Fatalf("unexpected spool size %d", relay.spoolSize)
+    }
+}
+
+// struct used to define upstream hermes server used to test relays.
+// packets received over all connections are recorded in order, and
+// the server can be stopped and restarted on the same address
+type testUpstream struct {
+    t        *testing.T
+    address  string
+
+    mu       sync.Mutex
+    listener net.Listener
+    conns    map[net.Conn]struct{}
+    packets  []HermesPayload
+    wg       sync.WaitGroup
+}
+
+// function used to create upstream server that is not yet started
+func newTestUpstream(t *testing.T) *testUpstream {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("unable to listen: %v", err)
+    }
+    u := &testUpstream{t: t, address: listener.Addr().String(), conns: map[net.Conn]struct{}{}}
+    listener.Close()
+    t.Cleanup(u.stop)
+    return u
+}
+
+// function used to start accepting connections
+func(u *testUpstream) start() {
+    listener, err := net.Listen("tcp", u.address)
+    if err != nil {
+        u.t.Fatalf("unable to restart upstream server: %v", err)
+    }
+    u.mu.Lock()
+    u.listener = listener
+    u.mu.Unlock()
+    u.wg.Add(1)
+    go func() {
+        defer u.wg.Done()
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            u.mu.Lock()
+            u.conns[conn] = struct{}{}
+            u.mu.Unlock()
+            u.wg.Add(1)
+            go u.handle(conn)
+        }
+    }()
+}
+
+// function used to record all packets sent over a connection
+func(u *testUpstream) handle(conn net.Conn) {
+    defer u.wg.Done()
+    reader := bufio.NewReader(conn)
+    for {
+        packet, err := readStreamPacket(reader)
+        if err != nil {
+            return
+        }
+        payload, err := DecodePacket(packet)
+        if err != nil {
+            u.t.Errorf("upstream received invalid packet: %v", err)
+            continue
+        }
+        u.mu.Lock()
+        u.packets = append(u.packets, payload)
+        u.mu.Unlock()
+    }
+}
+
+// function used to stop listener and close all connections
+func(u *testUpstream) stop() {
+    u.mu.Lock()
+    if u.listener != nil {
+        u.listener.Close()
+        u.listener = nil
+    }
+    for conn := range(u.conns) {
+        conn.Close()
+        delete(u.conns, conn)
+    }
+    u.mu.Unlock()
+    u.wg.Wait()
+}
+
+// function used to wait until the given number of packets have
+// been received, returning the seq labels of the received counters
+func(u *testUpstream) waitFor(n int) []string {
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        u.mu.Lock()
+        packets := append([]HermesPayload{}, u.packets...)
+        u.mu.Unlock()
+        if len(packets) >= n || time.Now().After(deadline) {
+            seqs := []string{}
+            for _, packet := range(packets) {
+                counter, err := DecodeCounter(packet)
+                if err != nil {
+                    u.t.Fatalf("unable to decode counter: %v", err)
+                }
+                seqs = append(seqs, counter.Labels["seq"])
+            }
+            return seqs
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+// struct used to define transport that fails a number of sends
+// before handing packets to the wrapped transport. an optional hook
+// is run after the given number of successful sends
+type testTransport struct {
+    hermes_client.Transport
+    failures int
+    attempts int
+    sent     [][]byte
+    hookAfter int
+    hook     func()
+}
+
+// function used to send packet with the wrapped transport
+func(t *testTransport) Send(packet []byte) error {
+    t.attempts++
+    if t.failures > 0 {
+        t.failures--
+        return hermes_client.ErrHermesConnection
+    }
+    if t.Transport != nil {
+        if err := t.Transport.Send(packet); err != nil {
+            return err
+        }
+    }
+    t.sent = append(t.sent, append([]byte{}, packet...))
+    if t.hook != nil && len(t.sent) == t.hookAfter {
+        t.hook()
+    }
+    return nil
+}
+
+// function used to speed up relay retries during tests
+func fastRelayBackoff(t *testing.T) {
+    backoff := RelayMinBackoff
+    RelayMinBackoff = time.Millisecond
+    t.Cleanup(func() { RelayMinBackoff = backoff })
+}
+
+// function used to forward counter with the given seq label and
+// deliver it synchronously instead of running the relay sender
+func forwardCounter(t *testing.T, r *Relay, seq int) {
+    counter := CounterJSON{Labels: map[string]string{"seq": strconv.Itoa(seq)}}
+    if err := r.ForwardCounter(HermesPayload{MetricName: "jobs_total"}, counter); err != nil {
+        t.Fatalf("unable to forward counter: %v", err)
+    }
+    r.deliver(<-r.queue, nil)
+}
+
+// function used to generate seq labels from first to last
+func seqs(first, last int) []string {
+    values := []string{}
+    for i := first; i <= last; i++ {
+        values = append(values, strconv.Itoa(i))
+    }
+    return values
+}
+
+// test that failed sends are retried before packets are dropped
+func TestRelayRetry(t *testing.T) {
+    fastRelayBackoff(t)
+    tests := []struct {
+        name      string
+        failures  int
+        attempts  int
+        delivered bool
+    }{
+        {"first attempt", 0, 1, true},
+        {"retried", 2, 3, true},
+        {"retries exhausted", 5, 3, false},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            registry := initTestMetrics(t, HermesConfig{})
+            r, err := NewRelay(RelayConfig{URL: "tcp://127.0.0.1:1", MaxRetries: 2})
+            if err != nil {
+                t.Fatalf("unable to create relay: %v", err)
+            }
+            transport := &testTransport{failures: test.failures}
+            r.Client.Transport = transport
+            forwardCounter(t, r, 1)
+            if transport.attempts != test.attempts {
+                t.Fatalf("expected %d attempts but got %d", test.attempts, transport.attempts)
+            }
+            if delivered := len(transport.sent) == 1; delivered != test.delivered {
+                t.Fatalf("expected packet to be delivered: %v", test.delivered)
+            }
+            dropped, _ := seriesValue(t, registry, "hermes_relay_dropped_total",
+                map[string]string{"reason": RelayReasonUnavailable})
+            if (dropped == 1) == test.delivered {
+                t.Fatalf("unexpected number of dropped packets %g", dropped)
+            }
+        })
+    }
+}
+
+// test that packets are spooled while the upstream server is down and
+// forwarded in order once it is up again, including when the upstream
+// server goes down while the spool is drained and when the spool is
+// drained on startup
+func TestRelaySpool(t *testing.T) {
+    fastRelayBackoff(t)
+    registry := initTestMetrics(t, HermesConfig{})
+    upstream := newTestUpstream(t)
+    path := filepath.Join(tempDir(t), "relay.spool")
+    config := RelayConfig{URL: "tcp://" + upstream.address, MaxRetries: 1, SpoolPath: path}
+    r, err := NewRelay(config)
+    if err != nil {
+        t.Fatalf("unable to create relay: %v", err)
+    }
+
+    // upstream down: packets are spooled
+    for seq := 1; seq <= 3; seq++ {
+        forwardCounter(t, r, seq)
+    }
+    if r.spool == nil || r.spoolSize == 0 {
+        t.Fatalf("expected packets to be spooled")
+    }
+    // upstream up: new packets are spooled behind the existing packets
+    // until the spool is drained, after which packets are sent directly
+    upstream.start()
+    forwardCounter(t, r, 4)
+    r.drainSpool()
+    forwardCounter(t, r, 5)
+    if received := upstream.waitFor(5); !reflect.DeepEqual(received, seqs(1, 5)) {
+        t.Fatalf("expected packets 1-5 in order but got %v", received)
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) || r.spool != nil {
+        t.Fatalf("expected spool to be removed once drained")
+    }
+
+    // upstream down again: the connection closed by the upstream server
+    // is detected, so that no packets are lost on the stale connection
+    upstream.stop()
+    time.Sleep(50 * time.Millisecond)
+    for seq := 6; seq <= 9; seq++ {
+        forwardCounter(t, r, seq)
+    }
+    // upstream down mid-drain: the remaining packets are respooled
+    upstream.start()
+    transport := &testTransport{Transport: r.Client.Transport, hookAfter: 2, hook: func() {
+        upstream.waitFor(7)
+        upstream.stop()
+        time.Sleep(50 * time.Millisecond)
+    }}
+    r.Client.Transport = transport
+    r.drainSpool()
+    if received := upstream.waitFor(7); !reflect.DeepEqual(received, seqs(1, 7)) {
+        t.Fatalf("expected packets 1-7 in order but got %v", received)
+    }
+    r.closeSpool()
+    if spooled := readCapture(t, path); len(spooled) != 2 {
+        t.Fatalf("expected 2 respooled packets but got %d", len(spooled))
+    }
+
+    // spool is drained on startup of a new relay
+    upstream.start()
+    restarted, err := NewRelay(config)
+    if err != nil {
+        t.Fatalf("unable to create relay: %v", err)
+    }
+    done := make(chan struct{})
+    go restarted.Run(done)
+    received := upstream.waitFor(9)
+    close(done)
+    restarted.Wait()
+    if !reflect.DeepEqual(received, seqs(1, 9)) {
+        t.Fatalf("expected packets 1-9 in order but got %v", received)
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Fatalf("expected spool to be removed once drained")
+    }
+    if forwarded, _ := seriesValue(t, registry, "hermes_relay_forwarded_total", map[string]string{}); forwarded != 9 {
+        t.Fatalf("expected 9 forwarded packets but got %g", forwarded)
+    }
+}
+
+// test that packets are appended to spool files that could not be
+// drained, rather than overwriting the unsent packets
+func TestRelaySpoolAppend(t *testing.T) {
+    fastRelayBackoff(t)
+    initTestMetrics(t, HermesConfig{})
+    path := filepath.Join(tempDir(t), "relay.spool")
+    config := RelayConfig{URL: "tcp://127.0.0.1:1", MaxRetries: 1, SpoolPath: path}
+    for i := 0; i < 2; i++ {
+        r, err := NewRelay(config)
+        if err != nil {
+            t.Fatalf("unable to create relay: %v", err)
+        }
+        forwardCounter(t, r, 2 * i + 1)
+        forwardCounter(t, r, 2 * i + 2)
+        r.closeSpool()
+    }
+    if spooled := readCapture(t, path); len(spooled) != 4 {
+        t.Fatalf("expected 4 spooled packets but got %d", len(spooled))
+    }
+}
+
+// test that relay labels are added to forwarded packets and definitions
+func TestRelayLabels(t *testing.T) {
+    initTestMetrics(t, HermesConfig{})
+    r, err := NewRelay(RelayConfig{URL: "tcp://127.0.0.1:1", HostLabel: "host",
+        Labels: map[string]string{"dc": "eu-west-1"}})
+    if err != nil {
+        t.Fatalf("unable to create relay: %v", err)
+    }
+    transport := &testTransport{}
+    r.Client.Transport = transport
+    hostname, _ := os.Hostname()
+    payload := HermesPayload{MetricName: "jobs_total",
+        Definition: &MetricDefinition{Type: "counter", Labels: []string{"job", "dc"}}}
+    if err := r.ForwardCounter(payload, CounterJSON{Labels: map[string]string{"job": "a", "dc": "client"}}); err != nil {
+        t.Fatalf("unable to forward counter: %v", err)
+    }
+    r.deliver(<-r.queue, nil)
+    if len(transport.sent) != 1 {
+        t.Fatalf("expected packet to be sent")
+    }
+    forwarded, err := DecodePacket(transport.sent[0])
+    if err != nil {
+        t.Fatalf("unable to decode forwarded packet: %v", err)
+    }
+    counter, err := DecodeCounter(forwarded)
+    if err != nil {
+        t.Fatalf("unable to decode forwarded counter: %v", err)
+    }
+    expected := map[string]string{"job": "a", "dc": "eu-west-1", "host": hostname}
+    if !reflect.DeepEqual(counter.Labels, expected) {
+        t.Fatalf("expected labels %v but got %v", expected, counter.Labels)
+    }
+    if forwarded.Definition == nil {
+        t.Fatalf("expected definition to be forwarded")
+    }
+    labels := append([]string{}, forwarded.Definition.Labels...)
+    sort.Strings(labels)
+    if !reflect.DeepEqual(labels, []string{"dc", "host", "job"}) {
+        t.Fatalf("unexpected labels of forwarded definition %v", forwarded.Definition.Labels)
+    }
+}
+
+// test that gauge updates are folded into pending gauge sets
+func TestRelayAggregateGauge(t *testing.T) {
+    value := func(v float64) *float64 { return &v }
+    tests := []struct {
+        name       string
+        updates    []GaugeJSON
+        immediate  int
+        aggregated []float64
+    }{
+        {"set", []GaugeJSON{{Operation: "set", Value: value(5)}}, 0, []float64{5}},
+        {"last set wins", []GaugeJSON{{Operation: "set", Value: value(5)}, {Operation: "set", Value: value(2)}},
+            0, []float64{2}},
+        {"increments folded into set", []GaugeJSON{{Operation: "set", Value: value(5)},
+            {Operation: "increment"}, {Operation: "increment"}, {Operation: "decrement"}}, 0, []float64{6}},
+        {"increment without set", []GaugeJSON{{Operation: "increment"}, {Operation: "set", Value: value(1)}},
+            1, []float64{1}},
+        {"set without value", []GaugeJSON{{Operation: "set"}}, 1, nil},
+        {"separate series", []GaugeJSON{{Operation: "set", Value: value(1)},
+            {Operation: "set", Value: value(2), Labels: map[string]string{"app": "web"}}}, 0, []float64{1, 2}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            initTestMetrics(t, HermesConfig{})
+            r, err := NewRelay(RelayConfig{URL: "tcp://127.0.0.1:1", Aggregate: true})
+            if err != nil {
+                t.Fatalf("unable to create relay: %v", err)
+            }
+            for _, update := range(test.updates) {
+                if err := r.ForwardGauge(HermesPayload{MetricName: "connections"}, update); err != nil {
+                    t.Fatalf("unable to forward gauge: %v", err)
+                }
+            }
+            if len(r.queue) != test.immediate {
+                t.Fatalf("expected %d gauges forwarded immediately but got %d", test.immediate, len(r.queue))
+            }
+            for i := 0; i < test.immediate; i++ {
+                <-r.queue
+            }
+            r.flushGauges()
+            values := []float64{}
+            for len(r.queue) > 0 {
+                gauge := (<-r.queue).packet.(hermes_client.HermesGaugePacket)
+                values = append(values, *gauge.Payload.GaugeValue)
+            }
+            sort.Float64s(values)
+            if len(values) != len(test.aggregated) || (len(values) > 0 && !reflect.DeepEqual(values, test.aggregated)) {
+                t.Fatalf("expected aggregated gauges %v but got %v", test.aggregated, values)
+            }
+        })
+    }
+}
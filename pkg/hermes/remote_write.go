@@ -0,0 +1,186 @@
+package hermes
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "net/http"
+
+    "github.com/golang/snappy"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define defaults used for remote write exporter
+    DefaultRemoteWriteInterval   = 15
+    DefaultRemoteWriteTimeout    = 10
+    DefaultRemoteWriteQueueSize  = 10
+    DefaultRemoteWriteMaxRetries = 5
+    RemoteWriteMinBackoff        = time.Millisecond * 500
+    RemoteWriteMaxBackoff        = time.Second * 30
+
+    ErrRemoteWriteRecoverable = errors.New("Recoverable remote write error")
+    ErrRemoteWriteRejected    = errors.New("Remote write request rejected")
+)
+
+// struct used to periodically gather all metrics from the hermes
+// registry and push them to a prometheus remote write endpoint.
+// gathered snapshots are stored in a bounded queue, and the oldest
+// snapshot is dropped if the queue is full
+type RemoteWriteExporter struct {
+    Config RemoteWriteConfig
+    Client *http.Client
+
+    queue  chan []byte
+}
+
+// function used to create new remote write exporter. defaults
+// are assigned for all values not specified in the config
+func NewRemoteWriteExporter(config RemoteWriteConfig) *RemoteWriteExporter {
+    if config.Interval <= 0 {
+        config.Interval = DefaultRemoteWriteInterval
+    }
+    if config.Timeout <= 0 {
+        config.Timeout = DefaultRemoteWriteTimeout
+    }
+    if config.QueueSize <= 0 {
+        config.QueueSize = DefaultRemoteWriteQueueSize
+    }
+    if config.MaxRetries <= 0 {
+        config.MaxRetries = DefaultRemoteWriteMaxRetries
+    }
+    return &RemoteWriteExporter{
+        Config: config,
+        Client: &http.Client{Timeout: time.Second * time.Duration(config.Timeout)},
+        queue: make(chan []byte, config.QueueSize),
+    }
+}
+
+// function used to start remote write exporter. metrics are gathered
+// on every interval and sent on a separate goroutine until the done
+// channel is closed
+func(e *RemoteWriteExporter) Run(done <-chan struct{}) {
+    log.Info(fmt.Sprintf("starting remote write exporter to %s", e.Config.URL))
+    go e.sendQueued(done)
+
+    ticker := time.NewTicker(time.Second * time.Duration(e.Config.Interval))
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            if err := e.Collect(); err != nil {
+                log.Error(fmt.Errorf("unable to collect metrics for remote write: %v", err))
+            }
+        case <-done:
+            return
+        }
+    }
+}
+
+// function used to gather all metrics from the hermes registry
+// and add the encoded write request to the queue
+func(e *RemoteWriteExporter) Collect() error {
+    families, err := Gatherer.Gather()
+    if err != nil {
+        return err
+    }
+    timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+    request := WriteRequest{Timeseries: FamiliesToTimeSeries(families, timestamp)}
+    body := snappy.Encode(nil, request.Marshal())
+    for {
+        select {
+        case e.queue <- body:
+            return nil
+        default:
+            // drop oldest snapshot if queue is full
+            select {
+            case <-e.queue:
+                log.Warn("remote write queue full. dropping oldest snapshot")
+            default:
+            }
+        }
+    }
+}
+
+// function used to send all queued write requests
+func(e *RemoteWriteExporter) sendQueued(done <-chan struct{}) {
+    for {
+        select {
+        case body := <-e.queue:
+            if err := e.sendWithRetry(body, done); err != nil {
+                log.Error(fmt.Errorf("unable to send remote write request: %v", err))
+            }
+        case <-done:
+            return
+        }
+    }
+}
+
+// function used to send write request with exponential backoff.
+// only recoverable errors (i.e. network errors and 5xx or 429
+// responses) are retried
+func(e *RemoteWriteExporter) sendWithRetry(body []byte, done <-chan struct{}) error {
+    backoff := RemoteWriteMinBackoff
+    for attempt := 0; ; attempt++ {
+        err := e.Send(body)
+        if err == nil || !errors.Is(err, ErrRemoteWriteRecoverable) || attempt >= e.Config.MaxRetries {
+            return err
+        }
+        log.Warn(fmt.Sprintf("remote write failed. retrying in %v: %v", backoff, err))
+        select {
+        case <-time.After(backoff):
+        case <-done:
+            return err
+        }
+        if backoff *= 2; backoff > RemoteWriteMaxBackoff {
+            backoff = RemoteWriteMaxBackoff
+        }
+    }
+}
+
+// function used to send a single snappy compressed
+// write request to the remote write endpoint
+func(e *RemoteWriteExporter) Send(body []byte) error {
+    req, err := http.NewRequest(http.MethodPost, e.Config.URL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Encoding", "snappy")
+    req.Header.Set("Content-Type", "application/x-protobuf")
+    req.Header.Set("User-Agent", "hermes")
+    req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+    // set authentication headers
+    if e.Config.BasicAuth != nil {
+        req.SetBasicAuth(e.Config.BasicAuth.Username, e.Config.BasicAuth.Password)
+    } else if len(e.Config.BearerToken) > 0 {
+        req.Header.Set("Authorization", "Bearer " + e.Config.BearerToken)
+    }
+
+    resp, err := e.Client.Do(req)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrRemoteWriteRecoverable, err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode / 100 == 2 {
+        return nil
+    }
+    message, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode / 100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+        return fmt.Errorf("%w: received status %d: %s", ErrRemoteWriteRecoverable,
+            resp.StatusCode, message)
+    }
+    return fmt.Errorf("%w: received status %d: %s", ErrRemoteWriteRejected,
+        resp.StatusCode, message)
+}
+
+// function used to decode snappy compressed remote write
+// request received by a remote write endpoint
+func DecodeWriteRequest(body []byte) (WriteRequest, error) {
+    decoded, err := snappy.Decode(nil, body)
+    if err != nil {
+        return WriteRequest{}, ErrInvalidWriteRequest
+    }
+    return UnmarshalWriteRequest(decoded)
+}
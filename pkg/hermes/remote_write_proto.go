@@ -0,0 +1,230 @@
+package hermes
+
+import (
+    "math"
+    "sort"
+    "strconv"
+    "errors"
+
+    "google.golang.org/protobuf/encoding/protowire"
+    dto "github.com/prometheus/client_model/go"
+)
+
+var (
+    ErrInvalidWriteRequest = errors.New("Invalid remote write request")
+)
+
+// struct used to define a prometheus remote write request.
+// the request is encoded by hand using the field numbers
+// defined in the prometheus prompb/remote.proto schema
+type WriteRequest struct {
+    Timeseries []TimeSeries
+}
+
+// struct used to define a single series of a write request
+type TimeSeries struct {
+    Labels  []Label
+    Samples []Sample
+}
+
+// struct used to define a label pair of a series
+type Label struct {
+    Name  string
+    Value string
+}
+
+// struct used to define a sample of a series. note
+// that the timestamp is given in milliseconds
+type Sample struct {
+    Value     float64
+    Timestamp int64
+}
+
+// function used to convert gathered prometheus metric families into
+// remote write time series. histograms and summaries are split into
+// their _bucket/_sum/_count and quantile/_sum/_count series
+func FamiliesToTimeSeries(families []*dto.MetricFamily, timestamp int64) []TimeSeries {
+    series := []TimeSeries{}
+    // function used to append new series with given name and labels
+    add := func(name string, pairs []*dto.LabelPair, value float64, extra ...Label) {
+        labels := []Label{{Name: "__name__", Value: name}}
+        for _, pair := range(pairs) {
+            labels = append(labels, Label{Name: pair.GetName(), Value: pair.GetValue()})
+        }
+        labels = append(labels, extra...)
+        sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+        series = append(series, TimeSeries{
+            Labels: labels,
+            Samples: []Sample{{Value: value, Timestamp: timestamp}},
+        })
+    }
+    for _, family := range(families) {
+        name := family.GetName()
+        for _, metric := range(family.GetMetric()) {
+            labels := metric.GetLabel()
+            switch family.GetType() {
+            case dto.MetricType_COUNTER:
+                add(name, labels, metric.GetCounter().GetValue())
+            case dto.MetricType_GAUGE:
+                add(name, labels, metric.GetGauge().GetValue())
+            case dto.MetricType_UNTYPED:
+                add(name, labels, metric.GetUntyped().GetValue())
+            case dto.MetricType_HISTOGRAM:
+                histogram := metric.GetHistogram()
+                for _, bucket := range(histogram.GetBucket()) {
+                    add(name + "_bucket", labels, float64(bucket.GetCumulativeCount()),
+                        Label{Name: "le", Value: formatFloat(bucket.GetUpperBound())})
+                }
+                add(name + "_bucket", labels, float64(histogram.GetSampleCount()),
+                    Label{Name: "le", Value: "+Inf"})
+                add(name + "_sum", labels, histogram.GetSampleSum())
+                add(name + "_count", labels, float64(histogram.GetSampleCount()))
+            case dto.MetricType_SUMMARY:
+                summary := metric.GetSummary()
+                for _, quantile := range(summary.GetQuantile()) {
+                    add(name, labels, quantile.GetValue(),
+                        Label{Name: "quantile", Value: formatFloat(quantile.GetQuantile())})
+                }
+                add(name + "_sum", labels, summary.GetSampleSum())
+                add(name + "_count", labels, float64(summary.GetSampleCount()))
+            }
+        }
+    }
+    return series
+}
+
+// function used to encode write request into protobuf format
+func(r WriteRequest) Marshal() []byte {
+    var b []byte
+    for _, ts := range(r.Timeseries) {
+        var series []byte
+        for _, label := range(ts.Labels) {
+            var l []byte
+            l = protowire.AppendTag(l, 1, protowire.BytesType)
+            l = protowire.AppendString(l, label.Name)
+            l = protowire.AppendTag(l, 2, protowire.BytesType)
+            l = protowire.AppendString(l, label.Value)
+            series = protowire.AppendTag(series, 1, protowire.BytesType)
+            series = protowire.AppendBytes(series, l)
+        }
+        for _, sample := range(ts.Samples) {
+            var s []byte
+            s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+            s = protowire.AppendFixed64(s, math.Float64bits(sample.Value))
+            s = protowire.AppendTag(s, 2, protowire.VarintType)
+            s = protowire.AppendVarint(s, uint64(sample.Timestamp))
+            series = protowire.AppendTag(series, 2, protowire.BytesType)
+            series = protowire.AppendBytes(series, s)
+        }
+        b = protowire.AppendTag(b, 1, protowire.BytesType)
+        b = protowire.AppendBytes(b, series)
+    }
+    return b
+}
+
+// function used to decode write request from protobuf format.
+// unknown fields (i.e. metadata) are skipped
+func UnmarshalWriteRequest(b []byte) (WriteRequest, error) {
+    var request WriteRequest
+    err := parseMessage(b, func(num protowire.Number, value []byte) error {
+        if num != 1 {
+            return nil
+        }
+        var ts TimeSeries
+        err := parseMessage(value, func(num protowire.Number, value []byte) error {
+            switch num {
+            case 1:
+                var label Label
+                err := parseMessage(value, func(num protowire.Number, value []byte) error {
+                    switch num {
+                    case 1:
+                        label.Name = string(value)
+                    case 2:
+                        label.Value = string(value)
+                    }
+                    return nil
+                })
+                ts.Labels = append(ts.Labels, label)
+                return err
+            case 2:
+                sample, err := parseSample(value)
+                ts.Samples = append(ts.Samples, sample)
+                return err
+            }
+            return nil
+        })
+        request.Timeseries = append(request.Timeseries, ts)
+        return err
+    })
+    return request, err
+}
+
+// function used to decode a single sample
+func parseSample(b []byte) (Sample, error) {
+    var sample Sample
+    for len(b) > 0 {
+        num, typ, n := protowire.ConsumeTag(b)
+        if n < 0 {
+            return sample, ErrInvalidWriteRequest
+        }
+        b = b[n:]
+        switch {
+        case num == 1 && typ == protowire.Fixed64Type:
+            v, n := protowire.ConsumeFixed64(b)
+            if n < 0 {
+                return sample, ErrInvalidWriteRequest
+            }
+            sample.Value, b = math.Float64frombits(v), b[n:]
+        case num == 2 && typ == protowire.VarintType:
+            v, n := protowire.ConsumeVarint(b)
+            if n < 0 {
+                return sample, ErrInvalidWriteRequest
+            }
+            sample.Timestamp, b = int64(v), b[n:]
+        default:
+            n := protowire.ConsumeFieldValue(num, typ, b)
+            if n < 0 {
+                return sample, ErrInvalidWriteRequest
+            }
+            b = b[n:]
+        }
+    }
+    return sample, nil
+}
+
+// function used to iterate over all length-delimited fields of
+// a protobuf message. all other field types are skipped
+func parseMessage(b []byte, handler func(protowire.Number, []byte) error) error {
+    for len(b) > 0 {
+        num, typ, n := protowire.ConsumeTag(b)
+        if n < 0 {
+            return ErrInvalidWriteRequest
+        }
+        b = b[n:]
+        if typ != protowire.BytesType {
+            n := protowire.ConsumeFieldValue(num, typ, b)
+            if n < 0 {
+                return ErrInvalidWriteRequest
+            }
+            b = b[n:]
+            continue
+        }
+        value, n := protowire.ConsumeBytes(b)
+        if n < 0 {
+            return ErrInvalidWriteRequest
+        }
+        b = b[n:]
+        if err := handler(num, value); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// function used to format float values of labels
+func formatFloat(value float64) string {
+    if math.IsInf(value, 1) {
+        return "+Inf"
+    }
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
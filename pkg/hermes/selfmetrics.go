@@ -0,0 +1,112 @@
+package hermes
+
+import (
+    "fmt"
+    "runtime"
+
+    "github.com/prometheus/client_golang/prometheus"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define metrics used to monitor hermes itself. self metrics
+    // are re-created whenever the local metrics are reset
+    AuthFailures     *prometheus.CounterVec
+    AccessDenied     *prometheus.CounterVec
+    TruncatedPackets *prometheus.CounterVec
+    DroppedPackets   *prometheus.CounterVec
+    QueueLength      prometheus.Gauge
+    ProcessingPanics prometheus.Counter
+    DecompressionFailures *prometheus.CounterVec
+    DynamicMetricsCount   prometheus.Gauge
+    DynamicMetricRejections *prometheus.CounterVec
+    BuildInfo             *prometheus.GaugeVec
+    RejectedPackets       *prometheus.CounterVec
+    RelayForwarded        prometheus.Counter
+    RelayDropped          *prometheus.CounterVec
+    RelaySpoolSize        prometheus.Gauge
+)
+
+func init() {
+    createSelfMetrics()
+}
+
+// function used to create new instances of all self metrics
+func createSelfMetrics() {
+    AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_auth_failures_total",
+        Help: "Number of packets rejected due to failed authentication",
+    }, []string{"reason"})
+    AccessDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_access_denied_total",
+        Help: "Number of packets rejected due to per-metric access control",
+    }, []string{"metric_name"})
+    TruncatedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_truncated_packets_total",
+        Help: "Number of datagrams dropped for exceeding the max packet size",
+    }, []string{"transport"})
+    DroppedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_dropped_packets_total",
+        Help: "Number of packets dropped because the processing queue was full",
+    }, []string{"policy"})
+    QueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "hermes_queue_length",
+        Help: "Number of packets waiting in the processing queue",
+    })
+    ProcessingPanics = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "hermes_processing_panics_total",
+        Help: "Number of packets that caused a panic during processing",
+    })
+    DecompressionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_decompression_failures_total",
+        Help: "Number of compressed datagrams dropped due to failed or oversized decompression",
+    }, []string{"algorithm"})
+    DynamicMetricsCount = prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "hermes_dynamic_metrics",
+        Help: "Number of metrics registered dynamically from definitions sent with packets",
+    })
+    DynamicMetricRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_dynamic_metric_rejections_total",
+        Help: "Number of metric definitions rejected by the dynamic metrics policy",
+    }, []string{"reason"})
+    BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "hermes_build_info",
+        Help: "Build information of hermes, with a constant value of 1",
+    }, []string{"version", "commit", "goversion"})
+    BuildInfo.WithLabelValues(Version, Commit, runtime.Version()).Set(1)
+    RejectedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_rejected_packets_total",
+        Help: "Number of packets rejected during processing",
+    }, []string{"reason"})
+    RelayForwarded = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "hermes_relay_forwarded_total",
+        Help: "Number of packets forwarded to the upstream hermes server",
+    })
+    RelayDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "hermes_relay_dropped_total",
+        Help: "Number of packets dropped by the relay before reaching the upstream hermes server",
+    }, []string{"reason"})
+    RelaySpoolSize = prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "hermes_relay_spool_bytes",
+        Help: "Size of packets waiting in the relay spool file",
+    })
+}
+
+// function used to retrieve all self metrics
+func selfMetrics() []prometheus.Collector {
+    return []prometheus.Collector{AuthFailures, AccessDenied, TruncatedPackets, DroppedPackets,
+        QueueLength, ProcessingPanics, DecompressionFailures, DynamicMetricsCount, DynamicMetricRejections, BuildInfo,
+        RejectedPackets, RelayForwarded, RelayDropped, RelaySpoolSize}
+}
+
+// function used to register all self metrics with the hermes
+// registry. metrics that are already registered are skipped
+func registerSelfMetrics() {
+    for _, collector := range(selfMetrics()) {
+        if err := Registerer.Register(collector); err != nil {
+            if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+                log.Error(fmt.Errorf("unable to register hermes self metric: %v", err))
+            }
+        }
+    }
+}
@@ -0,0 +1,22 @@
+package hermes
+
+import (
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// function used to create a new set instance. sets are exposed as
+// prometheus gauges holding the number of unique values received
+// over the last aggregation window. Pointers to the gauges are stored
+// in the global set map, which maps the name of the set/metric to the
+// prometheus pointer that stores the metrics themselves
+func NewSet(set HermesSet) error {
+    opts := prometheus.GaugeOpts{Name: set.MetricName, Help: set.MetricDescription}
+    // create new gauge instance
+    promSet := prometheus.NewGaugeVec(opts, set.Labels)
+    // register set and insert into maps
+    if err := Registerer.Register(promSet); err != nil {
+        return err
+    }
+    Sets[set.MetricName] = promSet
+    return nil
+}
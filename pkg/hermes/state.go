@@ -0,0 +1,175 @@
+package hermes
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "errors"
+    "io/ioutil"
+    "encoding/json"
+
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+)
+
+var (
+    // define default interval (in seconds) between state snapshots
+    DefaultSnapshotInterval = 30
+
+    ErrInvalidState = errors.New("Invalid hermes state file")
+)
+
+// struct used to define the snapshot of all counter and
+// gauge values persisted to the local state file
+type HermesState struct {
+    Timestamp time.Time                `json:"timestamp"`
+    Counters  map[string][]SeriesState `json:"counters"`
+    Gauges    map[string][]SeriesState `json:"gauges"`
+}
+
+// struct used to define the value of a single series
+type SeriesState struct {
+    Labels map[string]string `json:"labels"`
+    Value  float64           `json:"value"`
+}
+
+// function used to generate snapshot of all counter and gauge
+// values currently stored in the hermes registry
+func SnapshotState() (HermesState, error) {
+    state := HermesState{
+        Timestamp: time.Now().UTC(),
+        Counters: map[string][]SeriesState{},
+        Gauges: map[string][]SeriesState{},
+    }
+    families, err := Gatherer.Gather()
+    if err != nil {
+        return state, err
+    }
+    metricsLock.RLock()
+    defer metricsLock.RUnlock()
+    for _, family := range(families) {
+        name := family.GetName()
+        _, isCounter := Counters[name]
+        _, isGauge := Gauges[name]
+        if !isCounter && !isGauge {
+            continue
+        }
+        // extract labels and values of each series
+        for _, metric := range(family.GetMetric()) {
+            series := SeriesState{Labels: map[string]string{}}
+            for _, pair := range(metric.GetLabel()) {
+                series.Labels[pair.GetName()] = pair.GetValue()
+            }
+            if isCounter {
+                series.Value = metric.GetCounter().GetValue()
+                state.Counters[name] = append(state.Counters[name], series)
+            } else {
+                series.Value = metric.GetGauge().GetValue()
+                state.Gauges[name] = append(state.Gauges[name], series)
+            }
+        }
+    }
+    return state, nil
+}
+
+// function used to write a snapshot of the current hermes state
+// to the specified path. the snapshot is written atomically to
+// ensure that the state file is never left partially written
+func SaveState(path string) error {
+    state, err := SnapshotState()
+    if err != nil {
+        return err
+    }
+    bytesJson, err := json.Marshal(state)
+    if err != nil {
+        return err
+    }
+    return utils.WriteFileAtomic(path, bytesJson)
+}
+
+// function used to load hermes state from the specified
+// path. an empty state is returned if no file exists
+func LoadState(path string) (HermesState, error) {
+    var state HermesState
+    bytesJson, err := ioutil.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return state, nil
+        }
+        log.Error(fmt.Errorf("cannot load hermes state file: %v", err))
+        return state, ErrInvalidState
+    }
+    if err := json.Unmarshal(bytesJson, &state); err != nil {
+        log.Error(fmt.Errorf("cannot load hermes state file: %v", err))
+        return state, ErrInvalidState
+    }
+    return state, nil
+}
+
+// function used to restore counter and gauge values from
+// the state file. only metrics that are still registered
+// with the same labels are restored
+func RestoreState(path string) error {
+    state, err := LoadState(path)
+    if err != nil {
+        return err
+    }
+    for name, series := range(state.Counters) {
+        counter, ok := Counters[name]
+        if !ok {
+            log.Warn(fmt.Sprintf("skipping restore of unregistered counter '%s'", name))
+            continue
+        }
+        for _, s := range(series) {
+            if s.Value < 0 {
+                log.Warn(fmt.Sprintf("skipping restore of counter '%s' %v: negative value", name, s.Labels))
+                continue
+            }
+            promCounter, err := counter.GetMetricWith(s.Labels)
+            if err != nil {
+                log.Warn(fmt.Sprintf("skipping restore of counter '%s' %v: %v", name, s.Labels, err))
+                continue
+            }
+            promCounter.Add(s.Value)
+        }
+    }
+    for name, series := range(state.Gauges) {
+        gauge, ok := Gauges[name]
+        if !ok {
+            log.Warn(fmt.Sprintf("skipping restore of unregistered gauge '%s'", name))
+            continue
+        }
+        for _, s := range(series) {
+            promGauge, err := gauge.GetMetricWith(s.Labels)
+            if err != nil {
+                log.Warn(fmt.Sprintf("skipping restore of gauge '%s' %v: %v", name, s.Labels, err))
+                continue
+            }
+            promGauge.Set(s.Value)
+        }
+    }
+    log.Info(fmt.Sprintf("restored hermes state from %s (snapshot taken at %v)", path, state.Timestamp))
+    return nil
+}
+
+// function used to periodically write snapshots of the hermes
+// state to the state file until the done channel is closed
+func SnapshotStatePeriodically(config HermesStateConfig, done <-chan struct{}) {
+    interval := config.SnapshotInterval
+    if interval <= 0 {
+        interval = DefaultSnapshotInterval
+    }
+    ticker := time.NewTicker(time.Second * time.Duration(interval))
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            if err := SaveState(config.Path); err != nil {
+                log.Error(fmt.Errorf("unable to save hermes state: %v", err))
+            }
+        case <-done:
+            return
+        }
+    }
+}
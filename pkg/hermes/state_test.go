@@ -0,0 +1,111 @@
+package hermes
+
+import (
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+)
+
+// define config of metrics used to test state snapshots
+var stateTestConfig = HermesConfig{
+    Counters: []HermesCounter{
+        {MetricName: "requests_total", Labels: []string{"app"}},
+    },
+    Gauges: []HermesGauge{
+        {MetricName: "connections", Labels: []string{"app"}},
+        {MetricName: "temperature"},
+    },
+}
+
+// test that counter and gauge values survive a save and restore
+func TestSaveRestoreState(t *testing.T) {
+    path := filepath.Join(tempDir(t), "state.json")
+    web, api, value := map[string]string{"app": "web"}, map[string]string{"app": "api"}, -3.5
+
+    initTestMetrics(t, stateTestConfig)
+    for i := 0; i < 3; i++ {
+        IncrementCounter("requests_total", CounterJSON{Labels: web})
+    }
+    IncrementCounter("requests_total", CounterJSON{Labels: api})
+    ProcessGauge("connections", GaugeJSON{Labels: web, Operation: "increment"})
+    ProcessGauge("temperature", GaugeJSON{Operation: "set", Value: &value})
+    if err := SaveState(path); err != nil {
+        t.Fatalf("unable to save state: %v", err)
+    }
+
+    // restore state into new registry
+    registry := initTestMetrics(t, stateTestConfig)
+    if err := RestoreState(path); err != nil {
+        t.Fatalf("unable to restore state: %v", err)
+    }
+    tests := []struct {
+        name   string
+        labels map[string]string
+        value  float64
+    }{
+        {"requests_total", web, 3},
+        {"requests_total", api, 1},
+        {"connections", web, 1},
+        {"temperature", map[string]string{}, -3.5},
+    }
+    for _, test := range(tests) {
+        got, ok := seriesValue(t, registry, test.name, test.labels)
+        if !ok {
+            t.Errorf("series %s %v was not restored", test.name, test.labels)
+        } else if got != test.value {
+            t.Errorf("expected %s %v to be %g but got %g", test.name, test.labels, test.value, got)
+        }
+    }
+}
+
+// test that series that no longer match the config are skipped
+func TestRestoreStateSkipsUnknownSeries(t *testing.T) {
+    path := filepath.Join(tempDir(t), "state.json")
+    state := `{"counters": {"requests_total": [{"labels": {"app": "web"}, "value": 2},
+        {"labels": {"host": "a"}, "value": 5}, {"labels": {"app": "api"}, "value": -1}],
+        "removed_total": [{"labels": {}, "value": 7}]}, "gauges": {}}`
+    if err := ioutil.WriteFile(path, []byte(state), 0644); err != nil {
+        t.Fatal(err)
+    }
+    registry := initTestMetrics(t, stateTestConfig)
+    if err := RestoreState(path); err != nil {
+        t.Fatalf("unable to restore state: %v", err)
+    }
+    if got, _ := seriesValue(t, registry, "requests_total", map[string]string{"app": "web"}); got != 2 {
+        t.Errorf("expected restored counter to be 2 but got %g", got)
+    }
+    if _, ok := seriesValue(t, registry, "requests_total", map[string]string{"app": "api"}); ok {
+        t.Errorf("expected negative counter value to be skipped")
+    }
+    if _, ok := seriesValue(t, registry, "removed_total", map[string]string{}); ok {
+        t.Errorf("expected unregistered counter to be skipped")
+    }
+}
+
+// test loading of missing and invalid state files
+func TestLoadState(t *testing.T) {
+    dir := tempDir(t)
+    invalid := filepath.Join(dir, "invalid.json")
+    if err := ioutil.WriteFile(invalid, []byte("{not json"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name string
+        path string
+        err  error
+    }{
+        {"missing", filepath.Join(dir, "missing.json"), nil},
+        {"invalid", invalid, ErrInvalidState},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            state, err := LoadState(test.path)
+            if err != test.err {
+                t.Fatalf("expected error %v but got %v", test.err, err)
+            }
+            if err == nil && (len(state.Counters) > 0 || len(state.Gauges) > 0) {
+                t.Fatalf("expected empty state but got %+v", state)
+            }
+        })
+    }
+}
@@ -10,7 +10,7 @@ import (
 // function used to make an observation on a particular histogram
 func ObserveSummary(name string, summaryJson SummaryJSON) error {
     if summary, ok := Summaries[name]; ok {
-        log.Info(fmt.Sprintf("making histogram observation %f on '%s' %v", summaryJson.Observation, name, summary))
+        log.Debug(fmt.Sprintf("making summary observation %f on '%s' %v", summaryJson.Observation, name, summary))
         // generate labels for prometheus metric and check for errors
         labels, err := GenerateLabels(summaryJson.Labels, "summary", name)
         if err != nil {
@@ -27,11 +27,16 @@ func ObserveSummary(name string, summaryJson SummaryJSON) error {
 // maps the name of the histogram/metric to the prometheus pointer
 // that stores the metrics themselves
 func NewSummary(summary HermesSummary) error {
+    if err := ValidateReservedLabels("summary", summary.Labels); err != nil {
+        return err
+    }
     opts := prometheus.SummaryOpts{Name: summary.MetricName, Help: summary.MetricDescription}
     // create new histogram instance
     promSummary := prometheus.NewSummaryVec(opts, summary.Labels)
     // register gauge and insert into maps
-    prometheus.MustRegister(promSummary)
+    if err := Registerer.Register(promSummary); err != nil {
+        return err
+    }
     Summaries[summary.MetricName] = promSummary
     return nil
 }
\ No newline at end of file
@@ -0,0 +1,312 @@
+package hermes
+
+import (
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "errors"
+    "strings"
+    "strconv"
+    "net/http"
+    "sync/atomic"
+    "unicode/utf8"
+    "encoding/json"
+
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define max number of concurrent tail subscribers, and the
+    // default and max rate (in events per second) of each subscriber
+    MaxTailSubscribers = 10
+    DefaultTailRate    = 10.0
+    MaxTailRate        = 100.0
+
+    ErrTooManySubscribers = errors.New("Max number of tail subscribers reached")
+)
+
+// define outcome of packets that were processed successfully.
+// rejected packets have the rejection reason as outcome
+const OutcomeAccepted = "accepted"
+
+// struct used to define event emitted for each processed packet
+type TailEvent struct {
+    Timestamp  time.Time   `json:"timestamp"`
+    Source     string      `json:"source"`
+    MetricName string      `json:"metric_name,omitempty"`
+    KeyID      string      `json:"key_id,omitempty"`
+    Binary     bool        `json:"binary"`
+    Outcome    string      `json:"outcome"`
+    Error      string      `json:"error,omitempty"`
+    Payload    interface{} `json:"payload,omitempty"`
+}
+
+// struct used to define filters of tail subscribers. the metric
+// name must match exactly, the source is either an IP address or
+// a CIDR range, and the outcome is either a specific outcome or
+// "rejected" to match all rejected packets
+type TailFilter struct {
+    MetricName string
+    Source     *net.IPNet
+    Outcome    string
+}
+
+// struct used to define subscriber of tail events. events are
+// rate limited with a token bucket, and events exceeding the
+// rate (or the capacity of the subscriber) are dropped
+type tailSubscriber struct {
+    filter  TailFilter
+    events  chan TailEvent
+    dropped uint64
+
+    mu      sync.Mutex
+    rate    float64
+    tokens  float64
+    last    time.Time
+}
+
+// struct used to fan out tail events to all subscribers. note
+// that events are only generated while subscribers are active,
+// and publishing never blocks packet processing
+type TailBroker struct {
+    mu          sync.RWMutex
+    subscribers map[*tailSubscriber]struct{}
+    active      int32
+}
+
+// function used to create new tail broker
+func NewTailBroker() *TailBroker {
+    return &TailBroker{subscribers: map[*tailSubscriber]struct{}{}}
+}
+
+// function used to determine if any subscribers are active
+func(b *TailBroker) Active() bool {
+    return b != nil && atomic.LoadInt32(&b.active) > 0
+}
+
+// function used to add new subscriber with the given filter and
+// rate (in events per second). the rate is capped at MaxTailRate
+func(b *TailBroker) subscribe(filter TailFilter, rate float64) (*tailSubscriber, error) {
+    if rate <= 0 {
+        rate = DefaultTailRate
+    }
+    if rate > MaxTailRate {
+        rate = MaxTailRate
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if len(b.subscribers) >= MaxTailSubscribers {
+        return nil, ErrTooManySubscribers
+    }
+    subscriber := &tailSubscriber{filter: filter, events: make(chan TailEvent, int(rate) + 1),
+        rate: rate, tokens: rate, last: time.Now()}
+    b.subscribers[subscriber] = struct{}{}
+    atomic.StoreInt32(&b.active, int32(len(b.subscribers)))
+    return subscriber, nil
+}
+
+// function used to remove subscriber
+func(b *TailBroker) unsubscribe(subscriber *tailSubscriber) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    delete(b.subscribers, subscriber)
+    atomic.StoreInt32(&b.active, int32(len(b.subscribers)))
+}
+
+// function used to publish event to all matching subscribers
+func(b *TailBroker) Publish(event TailEvent) {
+    b.deliver(event, b.recipients(event))
+}
+
+// function used to determine subscribers that receive an event, i.e.
+// subscribers whose filter matches the event and whose rate allows
+// another event. note that the payload of the event is not matched,
+// so that the payload only needs to be set if there are recipients
+func(b *TailBroker) recipients(event TailEvent) []*tailSubscriber {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+    var subscribers []*tailSubscriber
+    for subscriber := range(b.subscribers) {
+        if !subscriber.matches(event) {
+            continue
+        }
+        if !subscriber.allow() {
+            atomic.AddUint64(&subscriber.dropped, 1)
+            continue
+        }
+        subscribers = append(subscribers, subscriber)
+    }
+    return subscribers
+}
+
+// function used to send event to the given subscribers. events
+// are dropped if the buffer of a subscriber is full
+func(b *TailBroker) deliver(event TailEvent, subscribers []*tailSubscriber) {
+    for _, subscriber := range(subscribers) {
+        select {
+        case subscriber.events <- event:
+        default:
+            atomic.AddUint64(&subscriber.dropped, 1)
+        }
+    }
+}
+
+// function used to determine if event matches filter of subscriber
+func(s *tailSubscriber) matches(event TailEvent) bool {
+    if len(s.filter.MetricName) > 0 && s.filter.MetricName != event.MetricName {
+        return false
+    }
+    switch s.filter.Outcome {
+    case "":
+    case "rejected":
+        if event.Outcome == OutcomeAccepted {
+            return false
+        }
+    default:
+        if s.filter.Outcome != event.Outcome {
+            return false
+        }
+    }
+    if s.filter.Source != nil {
+        host, _, err := net.SplitHostPort(event.Source)
+        if err != nil {
+            host = event.Source
+        }
+        ip := net.ParseIP(host)
+        if ip == nil || !s.filter.Source.Contains(ip) {
+            return false
+        }
+    }
+    return true
+}
+
+// function used to take token from the bucket of the subscriber
+func(s *tailSubscriber) allow() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    now := time.Now()
+    s.tokens += now.Sub(s.last).Seconds() * s.rate
+    if s.tokens > s.rate {
+        s.tokens = s.rate
+    }
+    s.last = now
+    if s.tokens < 1 {
+        return false
+    }
+    s.tokens--
+    return true
+}
+
+// function used to publish tail event of processed packet. events
+// are only generated if any tail subscribers are active, and the
+// payload of the event is only set once the subscribers receiving
+// the event are known. the packet decoded during processing is
+// re-used, and is nil if the packet could not be decoded
+func(server *HermesServer) publishTail(packet []byte, decoded *HermesPayload, remoteAddr net.Addr,
+    outcome string, err error, payload interface{}) {
+    if !server.tail.Active() {
+        return
+    }
+    event := TailEvent{Timestamp: time.Now().UTC(), Outcome: outcome}
+    if remoteAddr != nil {
+        event.Source = remoteAddr.String()
+    }
+    if err != nil {
+        event.Error = err.Error()
+    }
+    if decoded != nil {
+        event.MetricName, event.KeyID, event.Binary = decoded.MetricName, decoded.KeyID, decoded.Binary
+    }
+    subscribers := server.tail.recipients(event)
+    if len(subscribers) == 0 {
+        return
+    }
+    switch {
+    case payload != nil:
+    case decoded != nil && !decoded.Binary:
+        payload = decoded.Payload
+    case decoded == nil && utf8.Valid(packet):
+        // note that undecodable packets are copied, since packet
+        // buffers are re-used once processed
+        payload = string(packet)
+    }
+    event.Payload = payload
+    server.tail.deliver(event, subscribers)
+}
+
+// function used to parse tail filter from query parameters
+func ParseTailFilter(r *http.Request) (TailFilter, float64, error) {
+    query := r.URL.Query()
+    filter := TailFilter{MetricName: query.Get("metric"), Outcome: query.Get("outcome")}
+    if source := query.Get("source"); len(source) > 0 {
+        rule, err := NewAccessRule(AccessControl{AllowedSources: []string{source}})
+        if err != nil {
+            return filter, 0, err
+        }
+        filter.Source = rule.AllowedSources[0]
+    }
+    var rate float64
+    if value := query.Get("rate"); len(value) > 0 {
+        var err error
+        if rate, err = strconv.ParseFloat(value, 64); err != nil {
+            return filter, 0, fmt.Errorf("invalid rate '%s'", value)
+        }
+    }
+    return filter, rate, nil
+}
+
+// function used to generate HTTP handler streaming tail events
+// as server-sent events. events can be filtered with the metric,
+// source and outcome query parameters, and are capped at the rate
+// given in the rate query parameter. the number of events dropped
+// since the last event is sent as a comment
+func(server *HermesServer) TailHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+            writeJSONError(w, http.StatusInternalServerError, "Streaming not supported")
+            return
+        }
+        filter, rate, err := ParseTailFilter(r)
+        if err != nil {
+            writeJSONError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        subscriber, err := server.tail.subscribe(filter, rate)
+        if err != nil {
+            writeJSONError(w, http.StatusTooManyRequests, err.Error())
+            return
+        }
+        defer server.tail.unsubscribe(subscriber)
+        log.Info(fmt.Sprintf("new tail subscriber %s with filter %+v", r.RemoteAddr, filter))
+
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        w.WriteHeader(http.StatusOK)
+        flusher.Flush()
+        keepalive := time.NewTicker(15 * time.Second)
+        defer keepalive.Stop()
+        for {
+            select {
+            case <-r.Context().Done():
+                return
+            case <-server.done:
+                return
+            case <-keepalive.C:
+                fmt.Fprint(w, ": keepalive\n\n")
+            case event := <-subscriber.events:
+                if dropped := atomic.SwapUint64(&subscriber.dropped, 0); dropped > 0 {
+                    fmt.Fprintf(w, ": dropped %d events\n\n", dropped)
+                }
+                bytesJson, err := json.Marshal(event)
+                if err != nil {
+                    log.Error(fmt.Errorf("unable to convert tail event to JSON: %v", err))
+                    continue
+                }
+                fmt.Fprintf(w, "data: %s\n\n", strings.TrimSpace(string(bytesJson)))
+            }
+            flusher.Flush()
+        }
+    })
+}
@@ -0,0 +1,54 @@
+package hermes
+
+import (
+    "net"
+    "testing"
+)
+
+// test that tail events are only delivered to matching subscribers
+// and carry the metadata of the decoded packet
+func TestPublishTail(t *testing.T) {
+    initTestMetrics(t, processTestConfig)
+    server := &HermesServer{tail: NewTailBroker()}
+    all, _ := server.tail.subscribe(TailFilter{}, MaxTailRate)
+    requests, _ := server.tail.subscribe(TailFilter{MetricName: "requests_total"}, MaxTailRate)
+    rejected, _ := server.tail.subscribe(TailFilter{Outcome: "rejected"}, MaxTailRate)
+    remoteAddr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+
+    server.ProcessPayload([]byte(`{"metric_name": "events_total"}`), remoteAddr)
+    server.ProcessPayload([]byte(`{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}`), remoteAddr)
+    server.ProcessPayload([]byte(`{"metric_name": "unknown_total"}`), remoteAddr)
+    server.ProcessPayload([]byte(`not a packet`), remoteAddr)
+
+    tests := []struct {
+        name       string
+        subscriber *tailSubscriber
+        outcomes   []string
+    }{
+        {"all packets", all, []string{OutcomeAccepted, OutcomeAccepted, ReasonUnregisteredMetric, ReasonInvalidPacket}},
+        {"metric filter", requests, []string{OutcomeAccepted}},
+        {"rejected packets", rejected, []string{ReasonUnregisteredMetric, ReasonInvalidPacket}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            if len(test.subscriber.events) != len(test.outcomes) {
+                t.Fatalf("expected %d events but got %d", len(test.outcomes), len(test.subscriber.events))
+            }
+            for _, outcome := range(test.outcomes) {
+                event := <-test.subscriber.events
+                if event.Outcome != outcome {
+                    t.Fatalf("expected outcome '%s' but got '%s'", outcome, event.Outcome)
+                }
+                if event.Source != remoteAddr.String() {
+                    t.Fatalf("expected source %s but got %s", remoteAddr, event.Source)
+                }
+                if outcome != ReasonInvalidPacket && len(event.MetricName) == 0 {
+                    t.Fatalf("expected metric name of decoded packet to be set")
+                }
+                if event.Payload == nil {
+                    t.Fatalf("expected payload of %s event to be set", outcome)
+                }
+            }
+        })
+    }
+}
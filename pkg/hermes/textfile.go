@@ -0,0 +1,81 @@
+package hermes
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "errors"
+    "strings"
+    "path/filepath"
+
+    "github.com/prometheus/common/expfmt"
+    log "github.com/sirupsen/logrus"
+
+    "github.com/PSauerborn/hermes/pkg/utils"
+)
+
+var (
+    // define default interval (in seconds) between textfile writes
+    DefaultTextfileInterval = 15
+
+    // define prefixes of runtime metrics that are excluded from
+    // the textfile, since node_exporter exposes its own versions
+    TextfileExcludedPrefixes = []string{"go_", "process_", "promhttp_"}
+
+    ErrInvalidTextfile = errors.New("Invalid textfile path. path must end in .prom")
+)
+
+// function used to write all metrics from the hermes registry to
+// the specified path in prometheus text format. the file is written
+// atomically so that the textfile collector never reads partial files
+func WriteTextfile(path string) error {
+    if filepath.Ext(path) != ".prom" {
+        return ErrInvalidTextfile
+    }
+    families, err := Gatherer.Gather()
+    if err != nil {
+        return err
+    }
+    var buffer bytes.Buffer
+    for _, family := range(families) {
+        if isExcludedFromTextfile(family.GetName()) {
+            continue
+        }
+        if _, err := expfmt.MetricFamilyToText(&buffer, family); err != nil {
+            return err
+        }
+    }
+    return utils.WriteFileAtomic(path, buffer.Bytes())
+}
+
+// function used to write metrics to the textfile on every
+// interval until the done channel is closed
+func WriteTextfilePeriodically(config TextfileConfig, done <-chan struct{}) {
+    interval := config.Interval
+    if interval <= 0 {
+        interval = DefaultTextfileInterval
+    }
+    log.Info(fmt.Sprintf("writing metrics to textfile %s every %d seconds", config.Path, interval))
+    ticker := time.NewTicker(time.Second * time.Duration(interval))
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            if err := WriteTextfile(config.Path); err != nil {
+                log.Error(fmt.Errorf("unable to write metrics to textfile: %v", err))
+            }
+        case <-done:
+            return
+        }
+    }
+}
+
+// function used to determine if metric is excluded from textfile
+func isExcludedFromTextfile(name string) bool {
+    for _, prefix := range(TextfileExcludedPrefixes) {
+        if strings.HasPrefix(name, prefix) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,158 @@
+package hermes
+
+import (
+    "os"
+    "fmt"
+    "sync"
+    "time"
+    "errors"
+    "io/ioutil"
+    "crypto/tls"
+    "crypto/x509"
+
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define interval at which certificate files are checked for changes
+    CertReloadInterval = time.Second * 10
+
+    ErrInvalidTLSConfig = errors.New("Invalid TLS configuration")
+)
+
+// struct used to define TLS settings of the prometheus interface.
+// client certificates are verified against the client CA file if
+// set, and only required if RequireClientCert is set
+type TLSConfig struct {
+    CertFile          string
+    KeyFile           string
+    ClientCAFile      string
+    RequireClientCert bool
+}
+
+// struct used to serve TLS certificates that are reloaded
+// whenever the certificate, key or client CA files change
+type CertReloader struct {
+    Config    TLSConfig
+
+    mu        sync.RWMutex
+    cert      *tls.Certificate
+    clientCAs *x509.CertPool
+    modTimes  map[string]time.Time
+    checked   time.Time
+}
+
+// function used to validate TLS config. note that client
+// certificates can only be required if a client CA is set,
+// since client certificates could not be verified otherwise
+func(config TLSConfig) Validate() error {
+    if len(config.CertFile) == 0 || len(config.KeyFile) == 0 {
+        return fmt.Errorf("%w: certificate and key files must be set", ErrInvalidTLSConfig)
+    }
+    if config.RequireClientCert && len(config.ClientCAFile) == 0 {
+        return fmt.Errorf("%w: client CA file must be set to require client certificates",
+            ErrInvalidTLSConfig)
+    }
+    return nil
+}
+
+// function used to create new certificate reloader. the
+// config is validated and the certificates are loaded
+// initially, where an error is returned if either is invalid
+func NewCertReloader(config TLSConfig) (*CertReloader, error) {
+    if err := config.Validate(); err != nil {
+        return nil, err
+    }
+    reloader := &CertReloader{Config: config}
+    if err := reloader.load(); err != nil {
+        return nil, err
+    }
+    return reloader, nil
+}
+
+// function used to generate TLS config for HTTP server
+func(r *CertReloader) TLSConfig() *tls.Config {
+    return &tls.Config{GetConfigForClient: r.GetConfigForClient}
+}
+
+// function used to generate TLS config for each client
+// connection. certificates are reloaded if changed
+func(r *CertReloader) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+    r.reloadIfChanged()
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    config := &tls.Config{
+        Certificates: []tls.Certificate{*r.cert},
+        MinVersion: tls.VersionTLS12,
+    }
+    if r.clientCAs != nil {
+        config.ClientCAs = r.clientCAs
+        config.ClientAuth = tls.VerifyClientCertIfGiven
+        if r.Config.RequireClientCert {
+            config.ClientAuth = tls.RequireAndVerifyClientCert
+        }
+    }
+    return config, nil
+}
+
+// function used to reload certificates if any of the files have
+// been modified. files are checked at most once per reload interval,
+// and the previous certificates are kept if the reload fails
+func(r *CertReloader) reloadIfChanged() {
+    r.mu.RLock()
+    due := time.Since(r.checked) >= CertReloadInterval
+    r.mu.RUnlock()
+    if !due {
+        return
+    }
+    r.mu.Lock()
+    r.checked = time.Now()
+    changed := false
+    for path, modTime := range(r.modTimes) {
+        if info, err := os.Stat(path); err == nil && !info.ModTime().Equal(modTime) {
+            changed = true
+        }
+    }
+    r.mu.Unlock()
+    if changed {
+        log.Info("TLS certificate files changed. reloading certificates")
+        if err := r.load(); err != nil {
+            log.Error(fmt.Errorf("unable to reload TLS certificates: %v", err))
+        }
+    }
+}
+
+// function used to load certificate, key and client CA files
+func(r *CertReloader) load() error {
+    modTimes := map[string]time.Time{}
+    for _, path := range([]string{r.Config.CertFile, r.Config.KeyFile, r.Config.ClientCAFile}) {
+        if len(path) == 0 {
+            continue
+        }
+        info, err := os.Stat(path)
+        if err != nil {
+            return fmt.Errorf("%w: %v", ErrInvalidTLSConfig, err)
+        }
+        modTimes[path] = info.ModTime()
+    }
+    cert, err := tls.LoadX509KeyPair(r.Config.CertFile, r.Config.KeyFile)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidTLSConfig, err)
+    }
+    var clientCAs *x509.CertPool
+    if len(r.Config.ClientCAFile) > 0 {
+        pem, err := ioutil.ReadFile(r.Config.ClientCAFile)
+        if err != nil {
+            return fmt.Errorf("%w: %v", ErrInvalidTLSConfig, err)
+        }
+        clientCAs = x509.NewCertPool()
+        if !clientCAs.AppendCertsFromPEM(pem) {
+            return fmt.Errorf("%w: no certificates found in %s", ErrInvalidTLSConfig,
+                r.Config.ClientCAFile)
+        }
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.cert, r.clientCAs, r.modTimes, r.checked = &cert, clientCAs, modTimes, time.Now()
+    return nil
+}
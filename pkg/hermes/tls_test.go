@@ -0,0 +1,273 @@
+package hermes
+
+import (
+    "os"
+    "time"
+    "errors"
+    "testing"
+    "net/http"
+    "math/big"
+    "io/ioutil"
+    "crypto/tls"
+    "crypto/rand"
+    "crypto/x509"
+    "encoding/pem"
+    "path/filepath"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/x509/pkix"
+    "net/http/httptest"
+)
+
+// struct used to define certificate generated for tests
+type testCert struct {
+    cert     *x509.Certificate
+    key      *ecdsa.PrivateKey
+    certFile string
+    keyFile  string
+}
+
+// function used to generate certificate signed by the given CA
+// (or self-signed if no CA is given) and write it to the directory
+func newTestCert(t *testing.T, dir, name string, serial int64, ca *testCert) *testCert {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatalf("unable to generate key: %v", err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(serial),
+        Subject: pkix.Name{CommonName: name},
+        NotBefore: time.Now().Add(-time.Hour),
+        NotAfter: time.Now().Add(time.Hour),
+        DNSNames: []string{"localhost"},
+        ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+        KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+    }
+    parent, signer := template, key
+    if ca == nil {
+        template.IsCA, template.BasicConstraintsValid = true, true
+    } else {
+        parent, signer = ca.cert, ca.key
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+    if err != nil {
+        t.Fatalf("unable to create certificate: %v", err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatalf("unable to parse certificate: %v", err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatalf("unable to marshal key: %v", err)
+    }
+    generated := &testCert{cert: cert, key: key, certFile: filepath.Join(dir, name + ".crt"),
+        keyFile: filepath.Join(dir, name + ".key")}
+    writePEM(t, generated.certFile, "CERTIFICATE", der)
+    writePEM(t, generated.keyFile, "EC PRIVATE KEY", keyDER)
+    return generated
+}
+
+// function used to write PEM encoded block to file
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+    encoded := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+    if err := ioutil.WriteFile(path, encoded, 0600); err != nil {
+        t.Fatalf("unable to write %s: %v", path, err)
+    }
+}
+
+// function used to convert certificate to key pair used by TLS clients
+func(c *testCert) keyPair(t *testing.T) tls.Certificate {
+    pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+    if err != nil {
+        t.Fatalf("unable to load key pair: %v", err)
+    }
+    return pair
+}
+
+// function used to start HTTPS server that serves the
+// certificates of the given TLS config
+func newTLSTestServer(t *testing.T, config TLSConfig) *httptest.Server {
+    reloader, err := NewCertReloader(config)
+    if err != nil {
+        t.Fatalf("unable to create certificate reloader: %v", err)
+    }
+    server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    }))
+    server.TLS = reloader.TLSConfig()
+    server.StartTLS()
+    t.Cleanup(server.Close)
+    return server
+}
+
+// function used to send request to HTTPS server with the given
+// client certificate. note that the certificate is always sent,
+// even if not issued by a CA accepted by the server. the server
+// certificate is returned
+func tlsGet(server *httptest.Server, ca *testCert, certs ...tls.Certificate) (*x509.Certificate, error) {
+    roots := x509.NewCertPool()
+    roots.AddCert(ca.cert)
+    config := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+    if len(certs) > 0 {
+        config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+            return &certs[0], nil
+        }
+    }
+    client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+    response, err := client.Get(server.URL)
+    if err != nil {
+        return nil, err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        return nil, errors.New(response.Status)
+    }
+    return response.TLS.PeerCertificates[0], nil
+}
+
+// test that invalid TLS configs are rejected
+func TestNewCertReloaderInvalid(t *testing.T) {
+    dir := tempDir(t)
+    ca := newTestCert(t, dir, "ca", 1, nil)
+    server := newTestCert(t, dir, "server", 2, ca)
+    empty := filepath.Join(dir, "empty.pem")
+    if err := ioutil.WriteFile(empty, []byte("no certificates"), 0600); err != nil {
+        t.Fatalf("unable to write %s: %v", empty, err)
+    }
+
+    tests := []struct {
+        name   string
+        config TLSConfig
+    }{
+        {"missing certificate", TLSConfig{KeyFile: server.keyFile}},
+        {"missing key", TLSConfig{CertFile: server.certFile}},
+        {"non-existent certificate", TLSConfig{CertFile: filepath.Join(dir, "missing.crt"),
+            KeyFile: server.keyFile}},
+        {"mismatched key", TLSConfig{CertFile: server.certFile, KeyFile: ca.keyFile}},
+        {"client certificate without client CA", TLSConfig{CertFile: server.certFile,
+            KeyFile: server.keyFile, RequireClientCert: true}},
+        {"client CA without certificates", TLSConfig{CertFile: server.certFile,
+            KeyFile: server.keyFile, ClientCAFile: empty}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            if _, err := NewCertReloader(test.config); !errors.Is(err, ErrInvalidTLSConfig) {
+                t.Fatalf("expected ErrInvalidTLSConfig but got %v", err)
+            }
+        })
+    }
+}
+
+// test that client certificates are verified against the client
+// CA, and only required if configured
+func TestClientCertificates(t *testing.T) {
+    dir := tempDir(t)
+    ca := newTestCert(t, dir, "ca", 1, nil)
+    server := newTestCert(t, dir, "server", 2, ca)
+    client := newTestCert(t, dir, "client", 3, ca)
+    otherCA := newTestCert(t, dir, "other-ca", 4, nil)
+    untrusted := newTestCert(t, dir, "untrusted", 5, otherCA)
+
+    tests := []struct {
+        name     string
+        require  bool
+        certs    []tls.Certificate
+        accepted bool
+    }{
+        {"required and given", true, []tls.Certificate{client.keyPair(t)}, true},
+        {"required and missing", true, nil, false},
+        {"required and untrusted", true, []tls.Certificate{untrusted.keyPair(t)}, false},
+        {"optional and given", false, []tls.Certificate{client.keyPair(t)}, true},
+        {"optional and missing", false, nil, true},
+        {"optional and untrusted", false, []tls.Certificate{untrusted.keyPair(t)}, false},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            config := TLSConfig{CertFile: server.certFile, KeyFile: server.keyFile,
+                ClientCAFile: ca.certFile, RequireClientCert: test.require}
+            _, err := tlsGet(newTLSTestServer(t, config), ca, test.certs...)
+            if test.accepted && err != nil {
+                t.Fatalf("expected request to be accepted but got %v", err)
+            }
+            if !test.accepted && err == nil {
+                t.Fatalf("expected request to be rejected")
+            }
+        })
+    }
+}
+
+// test that certificates are reloaded once changed on disk
+func TestCertReloader(t *testing.T) {
+    interval := CertReloadInterval
+    CertReloadInterval = 0
+    t.Cleanup(func() { CertReloadInterval = interval })
+
+    dir := tempDir(t)
+    ca := newTestCert(t, dir, "ca", 1, nil)
+    newTestCert(t, dir, "server", 2, ca)
+    config := TLSConfig{CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")}
+    server := newTLSTestServer(t, config)
+    if cert, err := tlsGet(server, ca); err != nil || cert.SerialNumber.Int64() != 2 {
+        t.Fatalf("expected initial certificate to be served but got %v", err)
+    }
+
+    // ensure that the modification time changes on all filesystems
+    newTestCert(t, dir, "server", 3, ca)
+    modTime := time.Now().Add(time.Minute)
+    for _, path := range([]string{config.CertFile, config.KeyFile}) {
+        if err := os.Chtimes(path, modTime, modTime); err != nil {
+            t.Fatalf("unable to set modification time: %v", err)
+        }
+    }
+    if cert, err := tlsGet(server, ca); err != nil || cert.SerialNumber.Int64() != 3 {
+        t.Fatalf("expected reloaded certificate to be served but got %v", err)
+    }
+
+    // previous certificates are kept if the reload fails
+    ioutil.WriteFile(config.CertFile, []byte("invalid"), 0600)
+    modTime = modTime.Add(time.Minute)
+    os.Chtimes(config.CertFile, modTime, modTime)
+    if cert, err := tlsGet(server, ca); err != nil || cert.SerialNumber.Int64() != 3 {
+        t.Fatalf("expected previous certificate to be served but got %v", err)
+    }
+}
+
+// test that requests are only accepted with valid basic auth credentials
+func TestBasicAuthHandler(t *testing.T) {
+    credentials := &BasicAuthConfig{Username: "prometheus", Password: "secret"}
+    tests := []struct {
+        name        string
+        credentials *BasicAuthConfig
+        username    string
+        password    string
+        setAuth     bool
+        expected    int
+    }{
+        {"valid credentials", credentials, "prometheus", "secret", true, http.StatusOK},
+        {"invalid password", credentials, "prometheus", "wrong", true, http.StatusUnauthorized},
+        {"invalid username", credentials, "admin", "secret", true, http.StatusUnauthorized},
+        {"missing credentials", credentials, "", "", false, http.StatusUnauthorized},
+        {"auth disabled", nil, "", "", false, http.StatusOK},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            handler := BasicAuthHandler(test.credentials, http.HandlerFunc(func(w http.ResponseWriter,
+                r *http.Request) {
+                w.WriteHeader(http.StatusOK)
+            }))
+            request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+            if test.setAuth {
+                request.SetBasicAuth(test.username, test.password)
+            }
+            recorder := httptest.NewRecorder()
+            handler.ServeHTTP(recorder, request)
+            if recorder.Code != test.expected {
+                t.Fatalf("expected status %d but got %d", test.expected, recorder.Code)
+            }
+            if test.expected == http.StatusUnauthorized && len(recorder.Header().Get("WWW-Authenticate")) == 0 {
+                t.Fatalf("expected basic auth challenge")
+            }
+        })
+    }
+}
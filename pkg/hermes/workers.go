@@ -0,0 +1,153 @@
+package hermes
+
+import (
+    "fmt"
+    "net"
+    "sync"
+    "time"
+    "errors"
+    "runtime"
+    "sync/atomic"
+
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    // define defaults used for packet processing queue
+    DefaultQueueSize  = 1024
+    DefaultDropPolicy = DropNewest
+
+    ErrInvalidDropPolicy = errors.New("Invalid queue drop policy")
+
+    // define pool of buffers used to copy queued packets
+    packetPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+)
+
+// define policies used when packet queue is full
+const (
+    // discard incoming packet
+    DropNewest = "drop_newest"
+    // discard oldest queued packet to make room
+    DropOldest = "drop_oldest"
+    // block reader until a worker frees up space
+    Block      = "block"
+)
+
+// struct used to define packet queued for processing. the
+// packet data is stored in a pooled buffer that is returned
+// to the pool once the packet has been processed
+type queuedPacket struct {
+    data       *[]byte
+    remoteAddr net.Addr
+}
+
+// function used to start packet processing workers. the number of
+// workers defaults to the number of CPUs, and the queue depth and
+// drop policy default to DefaultQueueSize and DefaultDropPolicy
+func(server *HermesServer) startWorkers() {
+    workers := server.Workers
+    if workers <= 0 {
+        workers = runtime.NumCPU()
+    }
+    size := server.QueueSize
+    if size <= 0 {
+        size = DefaultQueueSize
+    }
+    if len(server.DropPolicy) == 0 {
+        server.DropPolicy = DefaultDropPolicy
+    }
+    server.queue = make(chan queuedPacket, size)
+    log.Info(fmt.Sprintf("starting %d packet workers with queue size %d (%s)", workers, size,
+        server.DropPolicy))
+    for i := 0; i < workers; i++ {
+        go server.work()
+    }
+}
+
+// function used to process queued packets until the server is closed
+func(server *HermesServer) work() {
+    for {
+        select {
+        case packet := <-server.queue:
+            QueueLength.Set(float64(len(server.queue)))
+            server.processSafely(packet)
+        case <-server.done:
+            return
+        }
+    }
+}
+
+// function used to process packet on a worker. any panics are
+// recovered so that a single packet cannot stall ingestion
+func(server *HermesServer) processSafely(packet queuedPacket) {
+    defer func() {
+        packetPool.Put(packet.data)
+        if r := recover(); r != nil {
+            log.Error(fmt.Sprintf("recovered panic while processing packet from %v: %+v",
+                packet.remoteAddr, r))
+            ProcessingPanics.Inc()
+        }
+    }()
+    server.ProcessPayload(*packet.data, packet.remoteAddr)
+}
+
+// function used to hand packet received by any listener to the
+// workers. the packet is recorded if capture is enabled, and the
+// time of the last packet is updated for the health endpoint
+func(server *HermesServer) receive(packet []byte, remoteAddr net.Addr) {
+    atomic.StoreInt64(&server.lastPacket, time.Now().UnixNano())
+    server.capturePacket(packet, remoteAddr)
+    server.enqueue(packet, remoteAddr)
+}
+
+// function used to add packet to processing queue. the packet
+// is copied into a pooled buffer, since the read buffer is reused
+// by the reader. if the queue is full, the drop policy of the
+// server is applied
+func(server *HermesServer) enqueue(data []byte, remoteAddr net.Addr) {
+    buffer := packetPool.Get().(*[]byte)
+    *buffer = append((*buffer)[:0], data...)
+    packet := queuedPacket{data: buffer, remoteAddr: remoteAddr}
+    defer func() { QueueLength.Set(float64(len(server.queue))) }()
+    select {
+    case server.queue <- packet:
+        return
+    default:
+    }
+    switch server.DropPolicy {
+    case Block:
+        select {
+        case server.queue <- packet:
+        case <-server.done:
+            packetPool.Put(buffer)
+        }
+    case DropOldest:
+        // note that the packet is always queued before any further
+        // packet is dropped, since select picks ready cases at random
+        for {
+            select {
+            case server.queue <- packet:
+                return
+            default:
+            }
+            select {
+            case dropped := <-server.queue:
+                packetPool.Put(dropped.data)
+                DroppedPackets.WithLabelValues(DropOldest).Inc()
+            default:
+            }
+        }
+    default:
+        packetPool.Put(buffer)
+        DroppedPackets.WithLabelValues(DropNewest).Inc()
+    }
+}
+
+// function used to validate drop policy
+func ValidateDropPolicy(policy string) error {
+    switch policy {
+    case DropNewest, DropOldest, Block:
+        return nil
+    }
+    return fmt.Errorf("%w '%s'", ErrInvalidDropPolicy, policy)
+}
@@ -0,0 +1,157 @@
+package hermes
+
+import (
+    "time"
+    "reflect"
+    "testing"
+)
+
+// function used to create server with a packet queue of the given
+// size and drop policy, without any workers consuming the queue
+func newQueueTestServer(policy string, size int) *HermesServer {
+    return &HermesServer{DropPolicy: policy, done: make(chan struct{}),
+        queue: make(chan queuedPacket, size)}
+}
+
+// function used to start worker that takes the next packet from
+// the queue and blocks until released, so that all further packets
+// stay in the queue. the data of the taken packet is sent on the
+// returned channel
+func startBlockedWorker(t *testing.T, server *HermesServer) <-chan string {
+    taken, release := make(chan string, 1), make(chan struct{})
+    go func() {
+        packet := <-server.queue
+        taken <- string(*packet.data)
+        <-release
+    }()
+    t.Cleanup(func() { close(release) })
+    return taken
+}
+
+// function used to drain all packets from the queue
+func drainQueue(server *HermesServer) []string {
+    queued := []string{}
+    for {
+        select {
+        case packet := <-server.queue:
+            queued = append(queued, string(*packet.data))
+        default:
+            return queued
+        }
+    }
+}
+
+// test that the drop policy is applied once the queue is full
+// because the only worker is blocked
+func TestEnqueueDropPolicy(t *testing.T) {
+    tests := []struct {
+        name     string
+        policy   string
+        expected []string
+    }{
+        {"drop newest", DropNewest, []string{"1", "2"}},
+        {"drop oldest", DropOldest, []string{"2", "3"}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            registry := initTestMetrics(t, HermesConfig{})
+            server := newQueueTestServer(test.policy, 2)
+            taken := startBlockedWorker(t, server)
+            server.enqueue([]byte("0"), nil)
+            if packet := <-taken; packet != "0" {
+                t.Fatalf("expected worker to take packet 0 but got %s", packet)
+            }
+            for _, packet := range([]string{"1", "2", "3"}) {
+                server.enqueue([]byte(packet), nil)
+            }
+            if queued := drainQueue(server); !reflect.DeepEqual(queued, test.expected) {
+                t.Fatalf("expected queued packets %v but got %v", test.expected, queued)
+            }
+            dropped, _ := seriesValue(t, registry, "hermes_dropped_packets_total",
+                map[string]string{"policy": test.policy})
+            if dropped != 1 {
+                t.Fatalf("expected 1 dropped packet but got %g", dropped)
+            }
+        })
+    }
+}
+
+// test that readers are blocked while the queue is full, and
+// are released once a worker frees up space or the server closes
+func TestEnqueueBlock(t *testing.T) {
+    tests := []struct {
+        name     string
+        close    bool
+        expected []string
+    }{
+        {"released by worker", false, []string{"2", "3"}},
+        {"released by close", true, []string{"1", "2"}},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            registry := initTestMetrics(t, HermesConfig{})
+            server := newQueueTestServer(Block, 2)
+            taken := startBlockedWorker(t, server)
+            server.enqueue([]byte("0"), nil)
+            <-taken
+            server.enqueue([]byte("1"), nil)
+            server.enqueue([]byte("2"), nil)
+
+            enqueued := make(chan struct{})
+            go func() {
+                server.enqueue([]byte("3"), nil)
+                close(enqueued)
+            }()
+            select {
+            case <-enqueued:
+                t.Fatalf("expected reader to block while the queue is full")
+            case <-time.After(50 * time.Millisecond):
+            }
+            if test.close {
+                close(server.done)
+            } else {
+                // free up space in the same way as a worker
+                <-server.queue
+            }
+            select {
+            case <-enqueued:
+            case <-time.After(2 * time.Second):
+                t.Fatalf("expected reader to be released")
+            }
+            if queued := drainQueue(server); !reflect.DeepEqual(queued, test.expected) {
+                t.Fatalf("expected queued packets %v but got %v", test.expected, queued)
+            }
+            if _, ok := seriesValue(t, registry, "hermes_dropped_packets_total",
+                map[string]string{"policy": Block}); ok {
+                t.Fatalf("expected no packets to be dropped")
+            }
+        })
+    }
+}
+
+// test that panics during processing are recovered, and that the
+// worker keeps processing packets afterwards
+func TestWorkerPanicRecovery(t *testing.T) {
+    registry := initTestMetrics(t, processTestConfig)
+    // counters without a prometheus vector panic once incremented
+    metricsLock.Lock()
+    Counters["broken_total"] = nil
+    metricsLock.Unlock()
+
+    server := newQueueTestServer(DropNewest, 2)
+    exited := make(chan struct{})
+    go func() {
+        server.work()
+        close(exited)
+    }()
+    t.Cleanup(func() {
+        close(server.done)
+        <-exited
+    })
+    server.enqueue([]byte(`{"metric_name": "broken_total", "payload": {"labels": {}}}`), nil)
+    server.enqueue([]byte(`{"metric_name": "requests_total", "payload": {"labels": {"app": "web"}}}`), nil)
+    waitForCounter(t, registry, 1)
+    if panics, _ := seriesValue(t, registry, "hermes_processing_panics_total", map[string]string{}); panics != 1 {
+        t.Fatalf("expected 1 recovered panic but got %g", panics)
+    }
+}
@@ -0,0 +1,88 @@
+package hermestest
+
+import (
+    "sync"
+
+    "github.com/PSauerborn/hermes/pkg/hermes"
+    "github.com/PSauerborn/hermes/pkg/client"
+)
+
+// struct used to define a fake hermes transport that
+// records all packets sent by a hermes client instead
+// of sending them over the network
+type Recorder struct {
+    mu      sync.Mutex
+    packets [][]byte
+}
+
+// function used to generate new hermes client that
+// records all packets with the returned recorder
+func NewRecordingClient() (*hermes_client.HermesClient, *Recorder) {
+    recorder := &Recorder{}
+    return hermes_client.NewWithTransport(recorder), recorder
+}
+
+// function used to record packet sent by hermes client
+func(r *Recorder) Send(packet []byte) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.packets = append(r.packets, append([]byte{}, packet...))
+    return nil
+}
+
+// function used to retrieve all raw packets recorded
+func(r *Recorder) Raw() [][]byte {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([][]byte{}, r.packets...)
+}
+
+// function used to retrieve all recorded packets
+// decoded into hermes payloads. packets sent with
+// the binary encoding are decoded as well, and
+// compressed batches are split into their packets
+func(r *Recorder) Packets() []hermes.HermesPayload {
+    payloads := []hermes.HermesPayload{}
+    for _, packet := range(r.Raw()) {
+        for _, unpacked := range(unpack(packet)) {
+            if payload, err := hermes.DecodePacket(unpacked); err == nil {
+                payloads = append(payloads, payload)
+            }
+        }
+    }
+    return payloads
+}
+
+// function used to decompress and split compressed batches.
+// uncompressed packets are returned unchanged
+func unpack(packet []byte) [][]byte {
+    algorithm := hermes.CompressionAlgorithm(packet)
+    if len(algorithm) == 0 {
+        return [][]byte{packet}
+    }
+    data, err := hermes.Decompress(packet, algorithm, hermes.DefaultMaxDecompressedSize)
+    if err != nil {
+        return nil
+    }
+    packets, _ := hermes.SplitBatch(data)
+    return packets
+}
+
+// function used to retrieve all recorded packets
+// for a particular metric
+func(r *Recorder) PacketsFor(metricName string) []hermes.HermesPayload {
+    payloads := []hermes.HermesPayload{}
+    for _, payload := range(r.Packets()) {
+        if payload.MetricName == metricName {
+            payloads = append(payloads, payload)
+        }
+    }
+    return payloads
+}
+
+// function used to clear all recorded packets
+func(r *Recorder) Reset() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.packets = nil
+}
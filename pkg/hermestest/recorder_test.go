@@ -0,0 +1,49 @@
+package hermestest
+
+import (
+    "testing"
+
+    "github.com/PSauerborn/hermes/pkg/client"
+)
+
+// test that recorded packets are decoded for all encodings
+// and compression algorithms supported by the client
+func TestRecorderPackets(t *testing.T) {
+    tests := []struct {
+        name        string
+        encoding    string
+        compression string
+    }{
+        {"json", hermes_client.EncodingJSON, hermes_client.CompressionNone},
+        {"binary", hermes_client.EncodingBinary, hermes_client.CompressionNone},
+        {"gzip", hermes_client.EncodingJSON, hermes_client.CompressionGzip},
+        {"zstd", hermes_client.EncodingBinary, hermes_client.CompressionZstd},
+        {"snappy", hermes_client.EncodingJSON, hermes_client.CompressionSnappy},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            client, recorder := NewRecordingClient()
+            client.SetEncoding(test.encoding)
+            client.SetCompression(test.compression, 0)
+            labels, value := map[string]string{"app": "web"}, 3.0
+            err := client.SendBatch(
+                hermes_client.HermesCounterPacket{MetricName: "requests",
+                    Payload: hermes_client.HermesCounterPayload{CounterLabels: labels}},
+                hermes_client.HermesCounterPacket{MetricName: "requests",
+                    Payload: hermes_client.HermesCounterPayload{CounterLabels: labels}},
+                hermes_client.HermesGaugePacket{MetricName: "connections",
+                    Payload: hermes_client.HermesGaugePayload{GaugeLabels: labels, GaugeValue: &value,
+                        GaugeOperation: "set"}},
+            )
+            if err != nil {
+                t.Fatalf("unable to send batch: %v", err)
+            }
+            if n := len(recorder.Packets()); n != 3 {
+                t.Fatalf("expected 3 recorded packets but got %d", n)
+            }
+            if n := len(recorder.PacketsFor("requests")); n != 2 {
+                t.Fatalf("expected 2 recorded packets for requests but got %d", n)
+            }
+        })
+    }
+}
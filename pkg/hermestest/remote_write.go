@@ -0,0 +1,103 @@
+package hermestest
+
+import (
+    "sync"
+    "net/http"
+    "io/ioutil"
+    "net/http/httptest"
+
+    "github.com/PSauerborn/hermes/pkg/hermes"
+)
+
+// struct used to define a local stand-in for a prometheus
+// remote write endpoint. all received write requests are
+// decoded and recorded for assertions
+type RemoteWriteReceiver struct {
+    *httptest.Server
+
+    mu         sync.Mutex
+    // status code returned to remote write clients
+    statusCode int
+    requests   []hermes.WriteRequest
+    headers    []http.Header
+}
+
+// function used to start new remote write receiver
+func NewRemoteWriteReceiver() *RemoteWriteReceiver {
+    receiver := &RemoteWriteReceiver{statusCode: http.StatusNoContent}
+    receiver.Server = httptest.NewServer(http.HandlerFunc(receiver.handle))
+    return receiver
+}
+
+// function used to handle incoming remote write requests
+func(r *RemoteWriteReceiver) handle(w http.ResponseWriter, req *http.Request) {
+    body, err := ioutil.ReadAll(req.Body)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    request, err := hermes.DecodeWriteRequest(body)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    r.mu.Lock()
+    r.requests = append(r.requests, request)
+    r.headers = append(r.headers, req.Header.Clone())
+    status := r.statusCode
+    r.mu.Unlock()
+    w.WriteHeader(status)
+}
+
+// function used to set status code returned to clients
+func(r *RemoteWriteReceiver) SetStatusCode(status int) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.statusCode = status
+}
+
+// function used to retrieve all received write requests
+func(r *RemoteWriteReceiver) Requests() []hermes.WriteRequest {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([]hermes.WriteRequest{}, r.requests...)
+}
+
+// function used to retrieve headers of all received requests
+func(r *RemoteWriteReceiver) Headers() []http.Header {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([]http.Header{}, r.headers...)
+}
+
+// function used to find the latest received sample of
+// a series by metric name and (complete) set of labels
+func(r *RemoteWriteReceiver) LatestValue(name string, labels map[string]string) (float64, bool) {
+    requests := r.Requests()
+    for i := len(requests) - 1; i >= 0; i-- {
+        for _, ts := range(requests[i].Timeseries) {
+            if seriesMatches(ts, name, labels) && len(ts.Samples) > 0 {
+                return ts.Samples[len(ts.Samples) - 1].Value, true
+            }
+        }
+    }
+    return 0, false
+}
+
+// function used to determine if a remote write series
+// matches the given metric name and labels
+func seriesMatches(ts hermes.TimeSeries, name string, labels map[string]string) bool {
+    if len(ts.Labels) != len(labels) + 1 {
+        return false
+    }
+    for _, label := range(ts.Labels) {
+        if label.Name == "__name__" {
+            if label.Value != name {
+                return false
+            }
+        } else if value, ok := labels[label.Name]; !ok || value != label.Value {
+            return false
+        }
+    }
+    return true
+}
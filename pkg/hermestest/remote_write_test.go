@@ -0,0 +1,203 @@
+package hermestest
+
+import (
+    "time"
+    "testing"
+    "net/http"
+
+    "github.com/prometheus/client_golang/prometheus"
+
+    "github.com/PSauerborn/hermes/pkg/hermes"
+)
+
+// define config of metrics used to test remote write
+var remoteWriteTestConfig = hermes.HermesConfig{
+    Gauges: []hermes.HermesGauge{
+        {MetricName: "connections", Labels: []string{"app"}},
+    },
+}
+
+// function used to initialize metrics on a new registry and start
+// a remote write receiver. both are reset once the test has completed
+func newRemoteWriteTest(t *testing.T) *RemoteWriteReceiver {
+    hermes.UseRegistry(prometheus.NewRegistry())
+    if err := hermes.InitializeMetrics(remoteWriteTestConfig); err != nil {
+        t.Fatalf("unable to initialize metrics: %v", err)
+    }
+    t.Cleanup(hermes.ResetMetrics)
+    receiver := NewRemoteWriteReceiver()
+    t.Cleanup(receiver.Close)
+
+    backoff := hermes.RemoteWriteMinBackoff
+    hermes.RemoteWriteMinBackoff = time.Millisecond
+    t.Cleanup(func() { hermes.RemoteWriteMinBackoff = backoff })
+    return receiver
+}
+
+// function used to set value of the test gauge
+func setConnections(t *testing.T, value float64) {
+    err := hermes.SetGauge("connections", hermes.GaugeJSON{Labels: map[string]string{"app": "web"},
+        Value: &value, Operation: "set"})
+    if err != nil {
+        t.Fatalf("unable to set gauge: %v", err)
+    }
+}
+
+// function used to start remote write exporter. metrics are only
+// sent once collected explicitly, since the interval is never reached
+func runExporter(t *testing.T, exporter *hermes.RemoteWriteExporter) {
+    exporter.Config.Interval = 3600
+    done := make(chan struct{})
+    t.Cleanup(func() { close(done) })
+    go exporter.Run(done)
+}
+
+// function used to wait until the receiver received exactly n
+// requests. no further requests are expected after the settle time
+func waitForRequests(t *testing.T, receiver *RemoteWriteReceiver, n int) []hermes.WriteRequest {
+    deadline := time.Now().Add(DefaultTimeout)
+    for len(receiver.Requests()) < n && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond * 5)
+    }
+    time.Sleep(DefaultSettleTime)
+    requests := receiver.Requests()
+    if len(requests) != n {
+        t.Fatalf("expected %d remote write requests but got %d", n, len(requests))
+    }
+    return requests
+}
+
+// function used to retrieve value of the test gauge in a request
+func connections(request hermes.WriteRequest) (float64, bool) {
+    for _, ts := range(request.Timeseries) {
+        if seriesMatches(ts, "connections", map[string]string{"app": "web"}) && len(ts.Samples) > 0 {
+            return ts.Samples[0].Value, true
+        }
+    }
+    return 0, false
+}
+
+// test that gathered metrics are sent with the configured
+// authentication and remote write headers
+func TestRemoteWriteExporter(t *testing.T) {
+    tests := []struct {
+        name          string
+        config        hermes.RemoteWriteConfig
+        authorization string
+    }{
+        {"no auth", hermes.RemoteWriteConfig{}, ""},
+        {"basic auth", hermes.RemoteWriteConfig{BasicAuth: &hermes.BasicAuthConfig{Username: "hermes",
+            Password: "secret"}}, "Basic aGVybWVzOnNlY3JldA=="},
+        {"bearer token", hermes.RemoteWriteConfig{BearerToken: "token"}, "Bearer token"},
+        {"basic auth preferred over bearer token", hermes.RemoteWriteConfig{BearerToken: "token",
+            BasicAuth: &hermes.BasicAuthConfig{Username: "hermes", Password: "secret"}},
+            "Basic aGVybWVzOnNlY3JldA=="},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            receiver := newRemoteWriteTest(t)
+            setConnections(t, 42)
+            test.config.URL = receiver.URL
+            exporter := hermes.NewRemoteWriteExporter(test.config)
+            runExporter(t, exporter)
+            if err := exporter.Collect(); err != nil {
+                t.Fatalf("unable to collect metrics: %v", err)
+            }
+            waitForRequests(t, receiver, 1)
+
+            if value, ok := receiver.LatestValue("connections", map[string]string{"app": "web"}); !ok || value != 42 {
+                t.Fatalf("expected connections to be 42 but got %g", value)
+            }
+            headers := receiver.Headers()[0]
+            expected := map[string]string{
+                "Authorization": test.authorization,
+                "Content-Encoding": "snappy",
+                "Content-Type": "application/x-protobuf",
+                "X-Prometheus-Remote-Write-Version": "0.1.0",
+            }
+            for header, value := range(expected) {
+                if headers.Get(header) != value {
+                    t.Fatalf("expected header %s to be '%s' but got '%s'", header, value, headers.Get(header))
+                }
+            }
+        })
+    }
+}
+
+// test that only recoverable errors are retried, up to
+// the max number of retries
+func TestRemoteWriteRetry(t *testing.T) {
+    tests := []struct {
+        name     string
+        status   int
+        requests int
+    }{
+        {"success", http.StatusNoContent, 1},
+        {"server error", http.StatusServiceUnavailable, 3},
+        {"too many requests", http.StatusTooManyRequests, 3},
+        {"rejected", http.StatusBadRequest, 1},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            receiver := newRemoteWriteTest(t)
+            receiver.SetStatusCode(test.status)
+            exporter := hermes.NewRemoteWriteExporter(hermes.RemoteWriteConfig{URL: receiver.URL,
+                MaxRetries: 2})
+            runExporter(t, exporter)
+            exporter.Collect()
+            waitForRequests(t, receiver, test.requests)
+        })
+    }
+}
+
+// test that retried requests are sent until the endpoint recovers
+func TestRemoteWriteRecovery(t *testing.T) {
+    receiver := newRemoteWriteTest(t)
+    receiver.SetStatusCode(http.StatusInternalServerError)
+    exporter := hermes.NewRemoteWriteExporter(hermes.RemoteWriteConfig{URL: receiver.URL, MaxRetries: 100})
+    runExporter(t, exporter)
+    setConnections(t, 1)
+    exporter.Collect()
+
+    deadline := time.Now().Add(DefaultTimeout)
+    for len(receiver.Requests()) < 2 && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond * 5)
+    }
+    receiver.SetStatusCode(http.StatusNoContent)
+    // retries stop once a request is accepted
+    requests := receiver.Requests()
+    for time.Now().Before(deadline) {
+        time.Sleep(DefaultSettleTime)
+        if len(receiver.Requests()) == len(requests) {
+            break
+        }
+        requests = receiver.Requests()
+    }
+    if len(requests) < 3 {
+        t.Fatalf("expected failed requests to be retried until accepted but got %d requests", len(requests))
+    }
+    for _, request := range(requests) {
+        if value, ok := connections(request); !ok || value != 1 {
+            t.Fatalf("expected retried request to contain the same snapshot")
+        }
+    }
+}
+
+// test that the oldest snapshots are dropped once the queue is full
+func TestRemoteWriteQueueBound(t *testing.T) {
+    receiver := newRemoteWriteTest(t)
+    exporter := hermes.NewRemoteWriteExporter(hermes.RemoteWriteConfig{URL: receiver.URL, QueueSize: 3})
+    for i := 1; i <= 5; i++ {
+        setConnections(t, float64(i))
+        if err := exporter.Collect(); err != nil {
+            t.Fatalf("unable to collect metrics: %v", err)
+        }
+    }
+    runExporter(t, exporter)
+    requests := waitForRequests(t, receiver, 3)
+    for i, request := range(requests) {
+        if value, _ := connections(request); value != float64(i + 3) {
+            t.Fatalf("expected snapshot %d to contain %d connections but got %g", i, i + 3, value)
+        }
+    }
+}
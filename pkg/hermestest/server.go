@@ -0,0 +1,208 @@
+package hermestest
+
+import (
+    "fmt"
+    "net"
+    "time"
+    "errors"
+
+    "github.com/prometheus/client_golang/prometheus"
+    dto "github.com/prometheus/client_model/go"
+
+    "github.com/PSauerborn/hermes/pkg/hermes"
+    "github.com/PSauerborn/hermes/pkg/client"
+)
+
+var (
+    // define default timings used when waiting for metrics
+    DefaultTimeout    = time.Second * 2
+    DefaultSettleTime = time.Millisecond * 50
+
+    // define custom errors
+    ErrSeriesNotFound = errors.New("Cannot find series for specified metric and labels")
+)
+
+// struct used to define an in-process hermes server used
+// for testing. the server listens on an ephemeral UDP port
+// on the loopback interface and registers all metrics on
+// its own prometheus registry. Note that hermes stores its
+// metrics globally, so only one test server should be run
+// at any given time
+type Server struct {
+    *hermes.HermesServer
+
+    Registry *prometheus.Registry
+
+    // timings used when waiting for metrics to be updated
+    Timeout    time.Duration
+    SettleTime time.Duration
+}
+
+// function used to start new in-process hermes server
+// with an in-memory hermes configuration
+func NewServer(config hermes.HermesConfig) (*Server, error) {
+    server, err := hermes.NewWithConfig(config, "127.0.0.1", 0)
+    if err != nil {
+        return nil, fmt.Errorf("unable to start hermes test server: %v", err)
+    }
+    // disable prometheus interface and use new registry
+    server.PrometheusPort = 0
+    registry := prometheus.NewRegistry()
+    hermes.UseRegistry(registry)
+    // initialize metrics before listening for packets
+    server.Setup()
+    go server.Listen()
+
+    return &Server{HermesServer: server, Registry: registry,
+        Timeout: DefaultTimeout, SettleTime: DefaultSettleTime}, nil
+}
+
+// function used to stop test server and reset metrics
+func(s *Server) Close() error {
+    defer hermes.ResetMetrics()
+    return s.HermesServer.Close()
+}
+
+// function used to retrieve address that server is listening on
+func(s *Server) Addr() *net.UDPAddr {
+    return s.Socket.LocalAddr().(*net.UDPAddr)
+}
+
+// function used to generate new hermes client that
+// sends packets to the test server over UDP
+func(s *Server) Client() *hermes_client.HermesClient {
+    addr := s.Addr()
+    return hermes_client.New(addr.IP.String(), addr.Port)
+}
+
+// function used to retrieve current value of a counter
+func(s *Server) CounterValue(name string, labels map[string]string) (float64, error) {
+    metric, err := s.findSeries(name, labels)
+    if err != nil {
+        return 0, err
+    }
+    return metric.GetCounter().GetValue(), nil
+}
+
+// function used to retrieve current value of a gauge
+func(s *Server) GaugeValue(name string, labels map[string]string) (float64, error) {
+    metric, err := s.findSeries(name, labels)
+    if err != nil {
+        return 0, err
+    }
+    return metric.GetGauge().GetValue(), nil
+}
+
+// function used to retrieve number of observations and sum of
+// observations made on a histogram
+func(s *Server) HistogramValue(name string, labels map[string]string) (uint64, float64, error) {
+    metric, err := s.findSeries(name, labels)
+    if err != nil {
+        return 0, 0, err
+    }
+    return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum(), nil
+}
+
+// function used to retrieve number of observations and sum of
+// observations made on a summary
+func(s *Server) SummaryValue(name string, labels map[string]string) (uint64, float64, error) {
+    metric, err := s.findSeries(name, labels)
+    if err != nil {
+        return 0, 0, err
+    }
+    return metric.GetSummary().GetSampleCount(), metric.GetSummary().GetSampleSum(), nil
+}
+
+// function used to wait for a counter to be updated. UDP packets
+// are processed asynchronously, so the counter is polled until the
+// series exists and its value has settled. zero is returned if the
+// series does not appear before the server timeout
+func(s *Server) WaitForCounter(name string, labels map[string]string) float64 {
+    return s.waitForValue(func() (float64, error) {
+        return s.CounterValue(name, labels)
+    })
+}
+
+// function used to wait for a gauge to be updated. see WaitForCounter
+func(s *Server) WaitForGauge(name string, labels map[string]string) float64 {
+    return s.waitForValue(func() (float64, error) {
+        return s.GaugeValue(name, labels)
+    })
+}
+
+// function used to wait for a histogram to be updated. the number
+// of observations made on the histogram is returned
+func(s *Server) WaitForHistogram(name string, labels map[string]string) uint64 {
+    count := s.waitForValue(func() (float64, error) {
+        count, _, err := s.HistogramValue(name, labels)
+        return float64(count), err
+    })
+    return uint64(count)
+}
+
+// function used to wait for a summary to be updated. the number
+// of observations made on the summary is returned
+func(s *Server) WaitForSummary(name string, labels map[string]string) uint64 {
+    count := s.waitForValue(func() (float64, error) {
+        count, _, err := s.SummaryValue(name, labels)
+        return float64(count), err
+    })
+    return uint64(count)
+}
+
+// function used to poll a metric value until it exists and has
+// not changed for the settle time of the server
+func(s *Server) waitForValue(getValue func() (float64, error)) float64 {
+    var (value float64; settledAt time.Time)
+    deadline := time.Now().Add(s.Timeout)
+    for time.Now().Before(deadline) {
+        current, err := getValue()
+        switch {
+        case err != nil:
+            settledAt = time.Time{}
+        case settledAt.IsZero() || current != value:
+            value, settledAt = current, time.Now()
+        case time.Since(settledAt) >= s.SettleTime:
+            return value
+        }
+        time.Sleep(time.Millisecond * 5)
+    }
+    return value
+}
+
+// function used to find a series in the test registry
+// by metric name and (complete) set of labels
+func(s *Server) findSeries(name string, labels map[string]string) (*dto.Metric, error) {
+    families, err := s.Registry.Gather()
+    if err != nil {
+        return nil, err
+    }
+    for _, family := range(families) {
+        if family.GetName() != name {
+            continue
+        }
+        for _, metric := range(family.GetMetric()) {
+            if labelsMatch(metric.GetLabel(), labels) {
+                return metric, nil
+            }
+        }
+    }
+    return nil, ErrSeriesNotFound
+}
+
+// function used to determine if the labels of a gathered
+// series match a given set of labels. labels defined on
+// the metric but missing from the given set are matched
+// against empty values
+func labelsMatch(pairs []*dto.LabelPair, labels map[string]string) bool {
+    matched := 0
+    for _, pair := range(pairs) {
+        if labels[pair.GetName()] != pair.GetValue() {
+            return false
+        }
+        if _, ok := labels[pair.GetName()]; ok {
+            matched++
+        }
+    }
+    return matched == len(labels)
+}
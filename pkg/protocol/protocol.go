@@ -0,0 +1,494 @@
+package protocol
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "errors"
+    "unicode/utf8"
+
+    "google.golang.org/protobuf/encoding/protowire"
+)
+
+// define magic byte used to signal binary packets. JSON packets
+// always start with '{' (or whitespace), so binary packets can be
+// detected from the first byte. the byte doubles as the version
+// of the binary protocol. see docs/hermes.proto for the schema
+const BinaryMagic byte = 0x01
+
+var (
+    ErrInvalidPacket = errors.New("Invalid binary hermes packet")
+)
+
+// struct used to define binary hermes packet. the payload is
+// kept in its encoded form until the metric type is known
+type Packet struct {
+    MetricName string
+    Payload    []byte
+    KeyID      string
+    Timestamp  int64
+    Nonce      string
+    Signature  string
+    Definition *Definition
+}
+
+// struct used to define binary metric definition, sent
+// with packets of metrics that are registered dynamically
+type Definition struct {
+    Type        string
+    Description string
+    Labels      []string
+    Buckets     []float64
+}
+
+// struct used to define binary counter payload
+type Counter struct {
+    Labels   map[string]string
+    Exemplar *Exemplar
+}
+
+// struct used to define binary gauge payload
+type Gauge struct {
+    Labels    map[string]string
+    Operation string
+    Value     *float64
+}
+
+// struct used to define binary histogram payload
+type Histogram struct {
+    Labels      map[string]string
+    Observation float64
+    Exemplar    *Exemplar
+}
+
+// struct used to define binary summary payload
+type Summary struct {
+    Labels      map[string]string
+    Observation float64
+}
+
+// struct used to define binary set payload
+type Set struct {
+    Labels map[string]string
+    Value  string
+}
+
+// struct used to define binary exemplar
+type Exemplar struct {
+    TraceID string
+    SpanID  string
+    Value   *float64
+}
+
+// function used to determine if a packet is a binary packet
+func IsBinary(packet []byte) bool {
+    return len(packet) > 0 && packet[0] == BinaryMagic
+}
+
+// function used to encode packet into binary format,
+// including the leading magic byte
+func(p Packet) Marshal() []byte {
+    b := []byte{BinaryMagic}
+    b = appendString(b, 1, p.MetricName)
+    b = appendBytes(b, 2, p.Payload)
+    b = appendString(b, 3, p.KeyID)
+    if p.Timestamp != 0 {
+        b = protowire.AppendTag(b, 4, protowire.VarintType)
+        b = protowire.AppendVarint(b, uint64(p.Timestamp))
+    }
+    b = appendString(b, 5, p.Nonce)
+    b = appendString(b, 6, p.Signature)
+    if p.Definition != nil {
+        b = appendBytes(b, 7, p.Definition.Marshal())
+    }
+    return b
+}
+
+// function used to decode binary packet, including
+// the leading magic byte
+func UnmarshalPacket(b []byte) (Packet, error) {
+    var p Packet
+    if !IsBinary(b) {
+        return p, ErrInvalidPacket
+    }
+    err := parseFields(b[1:], func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeString(b, &p.MetricName)
+        case num == 2 && typ == protowire.BytesType:
+            v, n, err := consumeBytes(b)
+            p.Payload = append([]byte{}, v...)
+            return n, err
+        case num == 3 && typ == protowire.BytesType:
+            return consumeString(b, &p.KeyID)
+        case num == 4 && typ == protowire.VarintType:
+            v, n := protowire.ConsumeVarint(b)
+            if n < 0 {
+                return n, parseError(n)
+            }
+            p.Timestamp = int64(v)
+            return n, nil
+        case num == 5 && typ == protowire.BytesType:
+            return consumeString(b, &p.Nonce)
+        case num == 6 && typ == protowire.BytesType:
+            return consumeString(b, &p.Signature)
+        case num == 7 && typ == protowire.BytesType:
+            v, n, err := consumeBytes(b)
+            if err != nil {
+                return n, err
+            }
+            definition, err := UnmarshalDefinition(v)
+            p.Definition = &definition
+            return n, err
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return p, err
+}
+
+// function used to encode metric definition. buckets
+// are encoded as a packed repeated field
+func(d Definition) Marshal() []byte {
+    b := appendString(nil, 1, d.Type)
+    b = appendString(b, 2, d.Description)
+    for _, label := range(d.Labels) {
+        b = protowire.AppendTag(b, 3, protowire.BytesType)
+        b = protowire.AppendString(b, label)
+    }
+    if len(d.Buckets) > 0 {
+        var packed []byte
+        for _, bucket := range(d.Buckets) {
+            packed = protowire.AppendFixed64(packed, math.Float64bits(bucket))
+        }
+        b = appendBytes(b, 4, packed)
+    }
+    return b
+}
+
+// function used to decode metric definition. buckets
+// are accepted in both packed and unpacked form
+func UnmarshalDefinition(b []byte) (Definition, error) {
+    var d Definition
+    err := parseFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeString(b, &d.Type)
+        case num == 2 && typ == protowire.BytesType:
+            return consumeString(b, &d.Description)
+        case num == 3 && typ == protowire.BytesType:
+            var label string
+            n, err := consumeString(b, &label)
+            d.Labels = append(d.Labels, label)
+            return n, err
+        case num == 4 && typ == protowire.Fixed64Type:
+            var bucket float64
+            n, err := consumeDouble(b, &bucket)
+            d.Buckets = append(d.Buckets, bucket)
+            return n, err
+        case num == 4 && typ == protowire.BytesType:
+            packed, n, err := consumeBytes(b)
+            if err != nil {
+                return n, err
+            }
+            if len(packed) % 8 != 0 {
+                return -1, ErrInvalidPacket
+            }
+            for len(packed) > 0 {
+                var bucket float64
+                m, _ := consumeDouble(packed, &bucket)
+                d.Buckets = append(d.Buckets, bucket)
+                packed = packed[m:]
+            }
+            return n, nil
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return d, err
+}
+
+// function used to encode counter payload
+func(c Counter) Marshal() []byte {
+    b := appendLabels(nil, 1, c.Labels)
+    if c.Exemplar != nil {
+        b = appendBytes(b, 2, c.Exemplar.Marshal())
+    }
+    return b
+}
+
+// function used to decode counter payload
+func UnmarshalCounter(b []byte) (Counter, error) {
+    c := Counter{Labels: map[string]string{}}
+    err := parseFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeLabel(b, c.Labels)
+        case num == 2 && typ == protowire.BytesType:
+            return consumeExemplar(b, &c.Exemplar)
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return c, err
+}
+
+// function used to encode gauge payload
+func(g Gauge) Marshal() []byte {
+    b := appendLabels(nil, 1, g.Labels)
+    b = appendString(b, 2, g.Operation)
+    if g.Value != nil {
+        b = appendDouble(b, 3, *g.Value)
+    }
+    return b
+}
+
+// function used to decode gauge payload
+func UnmarshalGauge(b []byte) (Gauge, error) {
+    g := Gauge{Labels: map[string]string{}}
+    err := parseFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeLabel(b, g.Labels)
+        case num == 2 && typ == protowire.BytesType:
+            return consumeString(b, &g.Operation)
+        case num == 3 && typ == protowire.Fixed64Type:
+            var value float64
+            n, err := consumeDouble(b, &value)
+            g.Value = &value
+            return n, err
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return g, err
+}
+
+// function used to encode histogram payload
+func(h Histogram) Marshal() []byte {
+    b := appendLabels(nil, 1, h.Labels)
+    b = appendDouble(b, 2, h.Observation)
+    if h.Exemplar != nil {
+        b = appendBytes(b, 3, h.Exemplar.Marshal())
+    }
+    return b
+}
+
+// function used to decode histogram payload
+func UnmarshalHistogram(b []byte) (Histogram, error) {
+    h := Histogram{Labels: map[string]string{}}
+    err := parseFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeLabel(b, h.Labels)
+        case num == 2 && typ == protowire.Fixed64Type:
+            return consumeDouble(b, &h.Observation)
+        case num == 3 && typ == protowire.BytesType:
+            return consumeExemplar(b, &h.Exemplar)
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return h, err
+}
+
+// function used to encode summary payload
+func(s Summary) Marshal() []byte {
+    b := appendLabels(nil, 1, s.Labels)
+    return appendDouble(b, 2, s.Observation)
+}
+
+// function used to decode summary payload
+func UnmarshalSummary(b []byte) (Summary, error) {
+    s := Summary{Labels: map[string]string{}}
+    err := parseFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeLabel(b, s.Labels)
+        case num == 2 && typ == protowire.Fixed64Type:
+            return consumeDouble(b, &s.Observation)
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return s, err
+}
+
+// function used to encode set payload
+func(s Set) Marshal() []byte {
+    b := appendLabels(nil, 1, s.Labels)
+    return appendString(b, 2, s.Value)
+}
+
+// function used to decode set payload
+func UnmarshalSet(b []byte) (Set, error) {
+    s := Set{Labels: map[string]string{}}
+    err := parseFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeLabel(b, s.Labels)
+        case num == 2 && typ == protowire.BytesType:
+            return consumeString(b, &s.Value)
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return s, err
+}
+
+// function used to encode exemplar
+func(e Exemplar) Marshal() []byte {
+    b := appendString(nil, 1, e.TraceID)
+    b = appendString(b, 2, e.SpanID)
+    if e.Value != nil {
+        b = appendDouble(b, 3, *e.Value)
+    }
+    return b
+}
+
+// function used to decode exemplar
+func UnmarshalExemplar(b []byte) (Exemplar, error) {
+    var e Exemplar
+    err := parseFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeString(b, &e.TraceID)
+        case num == 2 && typ == protowire.BytesType:
+            return consumeString(b, &e.SpanID)
+        case num == 3 && typ == protowire.Fixed64Type:
+            var value float64
+            n, err := consumeDouble(b, &value)
+            e.Value = &value
+            return n, err
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    return e, err
+}
+
+// function used to iterate over all fields of an encoded message.
+// the handler consumes the value of each field and returns the
+// number of bytes consumed (negative if the value is invalid)
+func parseFields(b []byte, handler func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+    for len(b) > 0 {
+        num, typ, n := protowire.ConsumeTag(b)
+        if n < 0 {
+            return ErrInvalidPacket
+        }
+        b = b[n:]
+        n, err := handler(num, typ, b)
+        if err != nil {
+            return err
+        }
+        if n < 0 {
+            return ErrInvalidPacket
+        }
+        b = b[n:]
+    }
+    return nil
+}
+
+// function used to convert negative length returned by
+// protowire into an invalid packet error
+func parseError(n int) error {
+    return fmt.Errorf("%w: %v", ErrInvalidPacket, protowire.ParseError(n))
+}
+
+// function used to consume bytes field value
+func consumeBytes(b []byte) ([]byte, int, error) {
+    v, n := protowire.ConsumeBytes(b)
+    if n < 0 {
+        return nil, n, parseError(n)
+    }
+    return v, n, nil
+}
+
+// function used to consume string field value. strings must
+// be valid UTF-8, since prometheus panics on invalid label values
+func consumeString(b []byte, value *string) (int, error) {
+    v, n := protowire.ConsumeString(b)
+    if n < 0 {
+        return n, parseError(n)
+    }
+    if !utf8.ValidString(v) {
+        return n, fmt.Errorf("%w: string is not valid UTF-8", ErrInvalidPacket)
+    }
+    *value = v
+    return n, nil
+}
+
+// function used to consume double field value
+func consumeDouble(b []byte, value *float64) (int, error) {
+    v, n := protowire.ConsumeFixed64(b)
+    if n < 0 {
+        return n, parseError(n)
+    }
+    *value = math.Float64frombits(v)
+    return n, nil
+}
+
+// function used to consume label map entry
+func consumeLabel(b []byte, labels map[string]string) (int, error) {
+    entry, n, err := consumeBytes(b)
+    if err != nil {
+        return n, err
+    }
+    var key, value string
+    err = parseFields(entry, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+        switch {
+        case num == 1 && typ == protowire.BytesType:
+            return consumeString(b, &key)
+        case num == 2 && typ == protowire.BytesType:
+            return consumeString(b, &value)
+        }
+        return protowire.ConsumeFieldValue(num, typ, b), nil
+    })
+    labels[key] = value
+    return n, err
+}
+
+// function used to consume exemplar field value
+func consumeExemplar(b []byte, exemplar **Exemplar) (int, error) {
+    v, n, err := consumeBytes(b)
+    if err != nil {
+        return n, err
+    }
+    e, err := UnmarshalExemplar(v)
+    *exemplar = &e
+    return n, err
+}
+
+// function used to append non-empty string field
+func appendString(b []byte, num protowire.Number, value string) []byte {
+    if len(value) == 0 {
+        return b
+    }
+    b = protowire.AppendTag(b, num, protowire.BytesType)
+    return protowire.AppendString(b, value)
+}
+
+// function used to append non-empty bytes field
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+    if len(value) == 0 {
+        return b
+    }
+    b = protowire.AppendTag(b, num, protowire.BytesType)
+    return protowire.AppendBytes(b, value)
+}
+
+// function used to append double field
+func appendDouble(b []byte, num protowire.Number, value float64) []byte {
+    b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+    return protowire.AppendFixed64(b, math.Float64bits(value))
+}
+
+// function used to append label map in sorted order, so
+// that encoded payloads (and signatures) are deterministic
+func appendLabels(b []byte, num protowire.Number, labels map[string]string) []byte {
+    keys := make([]string, 0, len(labels))
+    for key := range(labels) {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range(keys) {
+        var entry []byte
+        entry = appendString(entry, 1, key)
+        entry = appendString(entry, 2, labels[key])
+        b = protowire.AppendTag(b, num, protowire.BytesType)
+        b = protowire.AppendBytes(b, entry)
+    }
+    return b
+}
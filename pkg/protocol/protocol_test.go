@@ -0,0 +1,148 @@
+package protocol
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+
+    "google.golang.org/protobuf/encoding/protowire"
+)
+
+// test that all messages survive an encode and decode round-trip
+func TestRoundTrip(t *testing.T) {
+    value := 2.5
+    labels := map[string]string{"app": "web", "region": "eu-west-1"}
+    exemplar := &Exemplar{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+    tests := []struct {
+        name      string
+        message   interface{ Marshal() []byte }
+        unmarshal func([]byte) (interface{}, error)
+    }{
+        {"packet", Packet{MetricName: "requests_total", Payload: []byte{0x0a, 0x00}, KeyID: "ci",
+            Timestamp: 1600000000000, Nonce: "abc", Signature: "def",
+            Definition: &Definition{Type: "histogram", Description: "Request duration",
+                Labels: []string{"app"}, Buckets: []float64{0.1, 1, 10}}},
+            func(b []byte) (interface{}, error) { return UnmarshalPacket(b) }},
+        {"counter", Counter{Labels: labels, Exemplar: exemplar},
+            func(b []byte) (interface{}, error) { return UnmarshalCounter(b) }},
+        {"counter with exemplar value", Counter{Labels: labels, Exemplar: &Exemplar{TraceID: exemplar.TraceID,
+            Value: &value}}, func(b []byte) (interface{}, error) { return UnmarshalCounter(b) }},
+        {"gauge", Gauge{Labels: labels, Operation: "set", Value: &value},
+            func(b []byte) (interface{}, error) { return UnmarshalGauge(b) }},
+        {"gauge without value", Gauge{Labels: labels, Operation: "increment"},
+            func(b []byte) (interface{}, error) { return UnmarshalGauge(b) }},
+        {"histogram", Histogram{Labels: labels, Observation: 0.25, Exemplar: exemplar},
+            func(b []byte) (interface{}, error) { return UnmarshalHistogram(b) }},
+        {"summary", Summary{Labels: labels, Observation: -1},
+            func(b []byte) (interface{}, error) { return UnmarshalSummary(b) }},
+        {"set", Set{Labels: labels, Value: "alice"},
+            func(b []byte) (interface{}, error) { return UnmarshalSet(b) }},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            encoded := test.message.Marshal()
+            decoded, err := test.unmarshal(encoded)
+            if err != nil {
+                t.Fatalf("unable to decode %s: %v", test.name, err)
+            }
+            if !reflect.DeepEqual(decoded, test.message) {
+                t.Fatalf("expected %+v but got %+v", test.message, decoded)
+            }
+        })
+    }
+}
+
+// test that malformed messages are rejected with ErrInvalidPacket
+func TestUnmarshalMalformed(t *testing.T) {
+    label := func(key, value string) []byte {
+        entry := protowire.AppendTag(nil, 1, protowire.BytesType)
+        entry = protowire.AppendString(entry, key)
+        entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+        entry = protowire.AppendString(entry, value)
+        b := protowire.AppendTag(nil, 1, protowire.BytesType)
+        return protowire.AppendBytes(b, entry)
+    }
+    tests := []struct {
+        name      string
+        data      []byte
+        unmarshal func([]byte) error
+    }{
+        {"missing magic byte", []byte{0x0a, 0x01, 'a'}, func(b []byte) error {
+            _, err := UnmarshalPacket(b)
+            return err
+        }},
+        {"truncated metric name", []byte{BinaryMagic, 0x0a, 0x05, 'a'}, func(b []byte) error {
+            _, err := UnmarshalPacket(b)
+            return err
+        }},
+        {"truncated payload", []byte{BinaryMagic, 0x12, 0x7f}, func(b []byte) error {
+            _, err := UnmarshalPacket(b)
+            return err
+        }},
+        {"truncated timestamp", []byte{BinaryMagic, 0x20, 0xff}, func(b []byte) error {
+            _, err := UnmarshalPacket(b)
+            return err
+        }},
+        {"invalid UTF-8 metric name", append([]byte{BinaryMagic, 0x0a, 0x02}, 0xff, 0xfe),
+            func(b []byte) error {
+                _, err := UnmarshalPacket(b)
+                return err
+            }},
+        {"invalid UTF-8 label value", label("app", "\xff\xfe"), func(b []byte) error {
+            _, err := UnmarshalCounter(b)
+            return err
+        }},
+        {"invalid UTF-8 label name", label("\xc3\x28", "web"), func(b []byte) error {
+            _, err := UnmarshalGauge(b)
+            return err
+        }},
+        {"truncated label", label("app", "web")[:5], func(b []byte) error {
+            _, err := UnmarshalSummary(b)
+            return err
+        }},
+        {"truncated observation", []byte{0x11, 0x00, 0x00}, func(b []byte) error {
+            _, err := UnmarshalHistogram(b)
+            return err
+        }},
+        {"truncated gauge value", []byte{0x19, 0x00}, func(b []byte) error {
+            _, err := UnmarshalGauge(b)
+            return err
+        }},
+        {"invalid UTF-8 set value", []byte{0x12, 0x01, 0xff}, func(b []byte) error {
+            _, err := UnmarshalSet(b)
+            return err
+        }},
+        {"invalid UTF-8 trace ID", []byte{0x12, 0x03, 0x0a, 0x01, 0xff}, func(b []byte) error {
+            _, err := UnmarshalCounter(b)
+            return err
+        }},
+        {"invalid packed buckets", []byte{0x22, 0x03, 0x00, 0x00, 0x00}, func(b []byte) error {
+            _, err := UnmarshalDefinition(b)
+            return err
+        }},
+        {"invalid tag", []byte{0x00}, func(b []byte) error {
+            _, err := UnmarshalCounter(b)
+            return err
+        }},
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            if err := test.unmarshal(test.data); !errors.Is(err, ErrInvalidPacket) {
+                t.Fatalf("expected ErrInvalidPacket but got %v", err)
+            }
+        })
+    }
+}
+
+// test that truncated packets never cause a panic
+func TestUnmarshalTruncated(t *testing.T) {
+    packet := Packet{MetricName: "requests_total", KeyID: "ci", Timestamp: 1600000000000,
+        Payload: Histogram{Labels: map[string]string{"app": "web"}, Observation: 1,
+            Exemplar: &Exemplar{TraceID: "abc"}}.Marshal(),
+        Definition: &Definition{Type: "histogram", Labels: []string{"app"}, Buckets: []float64{1}}}.Marshal()
+    for i := 0; i < len(packet); i++ {
+        if decoded, err := UnmarshalPacket(packet[:i]); err == nil {
+            UnmarshalHistogram(decoded.Payload)
+        }
+    }
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+    "fmt"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+)
+
+// function used to generate the HMAC-SHA256 signature of a hermes
+// packet. the signature covers the metric name, timestamp, nonce,
+// encoded metric definition (empty if the packet carries no
+// definition) and raw payload, and is returned in hex format. the
+// definition is prefixed with its length so that bytes cannot be
+// moved between the definition and the payload
+func SignPayload(secret, metricName string, timestamp int64, nonce string, definition,
+    payload []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(fmt.Sprintf("%s\n%d\n%s\n%d\n", metricName, timestamp, nonce, len(definition))))
+    mac.Write(definition)
+    mac.Write(payload)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// function used to verify the HMAC-SHA256 signature of a hermes
+// packet using a constant time comparison
+func VerifyPayload(secret, signature, metricName string, timestamp int64, nonce string,
+    definition, payload []byte) bool {
+    expected := SignPayload(secret, metricName, timestamp, nonce, definition, payload)
+    return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
-
+    "os"
+    "io/ioutil"
+    "path/filepath"
 )
 
 // function used to determine if a slice of strings
@@ -13,4 +15,32 @@ func SliceContains(slice []string, val string) bool {
         }
     }
     return false
-}
\ No newline at end of file
+}
+
+// function used to write data to a file atomically. the data is
+// first written to a temp file in the same directory, which is
+// then renamed to ensure that the file is never partially written
+func WriteFileAtomic(path string, data []byte) error {
+    tmp, err := ioutil.TempFile(filepath.Dir(path), "." + filepath.Base(path) + "-*")
+    if err != nil {
+        return err
+    }
+    // remove temp file if data could not be written
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    // temp files are created with 0600 permissions
+    if err := os.Chmod(tmp.Name(), 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}